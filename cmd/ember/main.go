@@ -1,12 +1,17 @@
 package main
 
 import (
+	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/compiler"
+	"ember_lang/ember_lang/diagnostic"
 	"ember_lang/ember_lang/evaluator"
 	"ember_lang/ember_lang/lexer"
 	"ember_lang/ember_lang/object"
 	"ember_lang/ember_lang/parser"
 	"ember_lang/ember_lang/repl"
+	"ember_lang/ember_lang/vm"
 	"ember_lang/logger"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,17 +19,57 @@ import (
 
 var debug = os.Getenv("DEBUG")
 
+var engine = flag.String("engine", "eval", "execution engine: eval or vm")
+
+var lang = flag.Int("lang", int(object.LatestVersion), "language version: 1 (blocks share the enclosing scope) or 2")
+
+var maxDepth = flag.Int("max-depth", object.DefaultMaxCallDepth, "maximum number of nested function calls before a stack overflow")
+
+var color = flag.Bool("color", os.Getenv("NO_COLOR") == "", "color error messages and stack traces")
+
+var integers = flag.String("integers", "big", "integer overflow: big (grow without limit) or checked (report an error)")
+
 func main() {
-	if len(os.Args) > 1 {
+	flag.Parse()
+
+	if *engine != "eval" && *engine != "vm" {
+		fmt.Printf("Error: Unknown engine %q (want eval or vm)\n", *engine)
+		os.Exit(1)
+	}
+
+	version := object.LanguageVersion(*lang)
+	if version < object.Version1 || version > object.LatestVersion {
+		fmt.Printf("Error: Unknown language version %d (want 1 to %d)\n", *lang, object.LatestVersion)
+		os.Exit(1)
+	}
+
+	var integerMode object.IntegerMode
+	switch *integers {
+	case "big":
+		integerMode = object.BigIntegers
+	case "checked":
+		integerMode = object.CheckedIntegers
+	default:
+		fmt.Printf("Error: Unknown integer mode %q (want big or checked)\n", *integers)
+		os.Exit(1)
+	}
+
+	if *maxDepth < 1 || *maxDepth > object.MaxCallDepthLimit {
+		fmt.Printf("Error: Invalid maximum call depth %d (want 1 to %d)\n", *maxDepth, object.MaxCallDepthLimit)
+		os.Exit(1)
+	}
+	evaluator.ReserveStack(*maxDepth)
+
+	if flag.NArg() > 0 {
 		// Execute file mode
-		executeFile(os.Args[1])
+		executeFile(flag.Arg(0), version, integerMode)
 	} else {
 		// REPL mode
-		repl.Start(os.Stdin, os.Stdout, debug)
+		repl.Start(os.Stdin, os.Stdout, debug, *engine, version, integerMode, *maxDepth, *color)
 	}
 }
 
-func executeFile(path string) {
+func executeFile(path string, version object.LanguageVersion, integers object.IntegerMode) {
 	// Check file extension
 	if filepath.Ext(path) != ".em" {
 		fmt.Printf("Error: File must have .em extension\n")
@@ -47,6 +92,8 @@ func executeFile(path string) {
 		logger.StartTiming()
 	}
 
+	renderer := &diagnostic.Renderer{Filename: path, Source: string(code), Color: *color}
+
 	// Lexical analysis
 	l := lexer.New(string(code))
 
@@ -60,7 +107,7 @@ func executeFile(path string) {
 	program := p.ParseProgram()
 
 	if len(p.Errors()) != 0 {
-		printParserErrors(p.Errors())
+		fmt.Print(renderer.RenderAll(p.Errors()))
 		os.Exit(1)
 	}
 
@@ -69,19 +116,35 @@ func executeFile(path string) {
 	}
 
 	// Evaluation
-	env := object.NewEnvironment()
-	result := evaluator.Eval(program, env)
+	var result object.Object
+	if *engine == "vm" {
+		result = runVM(program, version, integers)
+	} else {
+		env := object.NewVersionedEnvironment(version)
+		env.SetIntegerMode(integers)
+		env.SetMaxCallDepth(*maxDepth)
+		result = evaluator.Eval(program, env)
+	}
 
 	if debug == "1" || debug == "2" {
 		logger.LogResult(result)
+	} else if err, ok := result.(*object.Error); ok {
+		fmt.Print(renderer.Render(err.Diagnostic()))
+		os.Exit(1)
 	} else if result != nil {
 		fmt.Println(result.Inspect())
 	}
 }
 
-func printParserErrors(errors []string) {
-	fmt.Println("\x1b[31mParser errors:\x1b[0m")
-	for _, msg := range errors {
-		fmt.Printf("\t%s\n", msg)
+func runVM(program *ast.Program, version object.LanguageVersion, integers object.IntegerMode) object.Object {
+	comp := compiler.NewWithState(compiler.NewVersionedSymbolTable(version), []object.Object{})
+	if err := comp.Compile(program); err != nil {
+		fmt.Printf("\x1b[31mCompilation failed:\x1b[0m\n\t%s\n", err)
+		os.Exit(1)
 	}
+
+	machine := vm.New(comp.Bytecode())
+	machine.SetIntegerMode(integers)
+	machine.SetMaxCallDepth(*maxDepth)
+	return machine.Run()
 }
@@ -3,6 +3,7 @@ package ast
 import (
 	"bytes"
 	"ember_lang/ember_lang/token"
+	"math/big"
 	"strings"
 )
 
@@ -11,6 +12,7 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Span() token.Span // The source range the node was parsed from
 }
 
 type Statement interface {
@@ -23,6 +25,45 @@ type Expression interface {
 	expressionNode()
 }
 
+// spanOf returns the span of node, or the zero span if node is missing.
+func spanOf(node Node) token.Span {
+	if node == nil {
+		return token.Span{}
+	}
+	return node.Span()
+}
+
+// joinSpans extends span over every node given. Missing nodes, such as an
+// absent else branch, are skipped.
+func joinSpans(span token.Span, nodes ...Node) token.Span {
+	for _, node := range nodes {
+		span = span.Join(spanOf(node))
+	}
+	return span
+}
+
+// ----------------------------------- BadExpression -----------------------------------
+
+// BadExpression stands in for an expression that failed to parse, so the tree
+// of a program with syntax errors can still be printed and walked.
+type BadExpression struct {
+	Token token.Token // The token the expression was expected at
+}
+
+func (be *BadExpression) expressionNode() {}
+
+func (be *BadExpression) TokenLiteral() string {
+	return be.Token.Literal
+}
+
+func (be *BadExpression) Span() token.Span {
+	return be.Token.Span
+}
+
+func (be *BadExpression) String() string {
+	return "<bad expression>"
+}
+
 // ------------------------------------- Identifier -------------------------------------
 
 type Identifier struct {
@@ -37,6 +78,13 @@ func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *Identifier) Span() token.Span {
+	if i == nil {
+		return token.Span{}
+	}
+	return i.Token.Span
+}
+
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -54,6 +102,13 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Span() token.Span {
+	if len(p.Statements) == 0 {
+		return token.Span{}
+	}
+	return spanOf(p.Statements[0]).Join(spanOf(p.Statements[len(p.Statements)-1]))
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -78,6 +133,13 @@ func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
 
+func (ls *LetStatement) Span() token.Span {
+	if ls == nil {
+		return token.Span{}
+	}
+	return joinSpans(ls.Token.Span, ls.Name, ls.Value)
+}
+
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -107,6 +169,10 @@ func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
 
+func (rs *ReturnStatement) Span() token.Span {
+	return joinSpans(rs.Token.Span, rs.ReturnValue)
+}
+
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -121,6 +187,85 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ------------------------------------- ThrowStatement -------------------------------------
+
+type ThrowStatement struct {
+	Token token.Token // token.THROW token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+
+func (ts *ThrowStatement) TokenLiteral() string {
+	return ts.Token.Literal
+}
+
+func (ts *ThrowStatement) Span() token.Span {
+	return joinSpans(ts.Token.Span, ts.Value)
+}
+
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// ------------------------------------- BreakStatement -------------------------------------
+
+type BreakStatement struct {
+	Token token.Token // token.BREAK token
+	Label *Identifier // Loop to leave; nil for the innermost one
+}
+
+func (bs *BreakStatement) statementNode() {}
+
+func (bs *BreakStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+
+func (bs *BreakStatement) Span() token.Span {
+	return joinSpans(bs.Token.Span, bs.Label)
+}
+
+func (bs *BreakStatement) String() string {
+	if bs.Label != nil {
+		return bs.TokenLiteral() + " " + bs.Label.String() + ";"
+	}
+	return bs.TokenLiteral() + ";"
+}
+
+// ------------------------------------- ContinueStatement -------------------------------------
+
+type ContinueStatement struct {
+	Token token.Token // token.CONTINUE token
+	Label *Identifier // Loop to continue; nil for the innermost one
+}
+
+func (cs *ContinueStatement) statementNode() {}
+
+func (cs *ContinueStatement) TokenLiteral() string {
+	return cs.Token.Literal
+}
+
+func (cs *ContinueStatement) Span() token.Span {
+	return joinSpans(cs.Token.Span, cs.Label)
+}
+
+func (cs *ContinueStatement) String() string {
+	if cs.Label != nil {
+		return cs.TokenLiteral() + " " + cs.Label.String() + ";"
+	}
+	return cs.TokenLiteral() + ";"
+}
+
 // ------------------------------------- ExpressionStatement -------------------------------------
 
 type ExpressionStatement struct {
@@ -134,6 +279,10 @@ func (es *ExpressionStatement) TokenLiteral() string {
 	return es.Token.Literal
 }
 
+func (es *ExpressionStatement) Span() token.Span {
+	return joinSpans(es.Token.Span, es.Expression)
+}
+
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -146,6 +295,7 @@ func (es *ExpressionStatement) String() string {
 type BlockStatement struct {
 	Token      token.Token // token.LBRACE token
 	Statements []Statement
+	RBrace     token.Token // token.RBRACE token
 }
 
 func (bs *BlockStatement) statementNode() {}
@@ -154,6 +304,13 @@ func (bs *BlockStatement) TokenLiteral() string {
 	return bs.Token.Literal
 }
 
+func (bs *BlockStatement) Span() token.Span {
+	if bs == nil {
+		return token.Span{}
+	}
+	return bs.Token.Span.Join(bs.RBrace.Span)
+}
+
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -169,6 +326,7 @@ func (bs *BlockStatement) String() string {
 type IntegerLiteral struct {
 	Token token.Token // token.INT token
 	Value int64
+	Big   *big.Int // Set instead of Value when the literal does not fit in 64 bits
 }
 
 func (il *IntegerLiteral) expressionNode() {}
@@ -177,10 +335,35 @@ func (il *IntegerLiteral) TokenLiteral() string {
 	return il.Token.Literal
 }
 
+func (il *IntegerLiteral) Span() token.Span {
+	return il.Token.Span
+}
+
 func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
 
+// ------------------------------------- FloatLiteral -------------------------------------
+
+type FloatLiteral struct {
+	Token token.Token // token.FLOAT token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode() {}
+
+func (fl *FloatLiteral) TokenLiteral() string {
+	return fl.Token.Literal
+}
+
+func (fl *FloatLiteral) Span() token.Span {
+	return fl.Token.Span
+}
+
+func (fl *FloatLiteral) String() string {
+	return fl.Token.Literal
+}
+
 // ------------------------------------- PrefixExpression -------------------------------------
 
 type PrefixExpression struct {
@@ -195,6 +378,10 @@ func (pe *PrefixExpression) TokenLiteral() string {
 	return pe.Token.Literal
 }
 
+func (pe *PrefixExpression) Span() token.Span {
+	return joinSpans(pe.Token.Span, pe.Right)
+}
+
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -221,6 +408,10 @@ func (ie *InfixExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *InfixExpression) Span() token.Span {
+	return joinSpans(ie.Token.Span, ie.Left, ie.Right)
+}
+
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -246,6 +437,10 @@ func (b *Boolean) TokenLiteral() string {
 	return b.Token.Literal
 }
 
+func (b *Boolean) Span() token.Span {
+	return b.Token.Span
+}
+
 func (b *Boolean) String() string {
 	return b.Token.Literal
 }
@@ -265,6 +460,10 @@ func (ie *IfExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *IfExpression) Span() token.Span {
+	return joinSpans(ie.Token.Span, ie.Condition, ie.Consequence, ie.Alternative)
+}
+
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -281,10 +480,55 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// ------------------------------------- TryExpression -------------------------------------
+
+// TryExpression is try { } catch (e) { } finally { }. Either the catch or the
+// finally clause may be left out, and so may the (e) of the catch clause.
+type TryExpression struct {
+	Token     token.Token // token.TRY token
+	Body      *BlockStatement
+	Parameter *Identifier     // Bound to the caught error; nil if not named
+	Catch     *BlockStatement // nil without a catch clause
+	Finally   *BlockStatement // nil without a finally clause
+}
+
+func (te *TryExpression) expressionNode() {}
+
+func (te *TryExpression) TokenLiteral() string {
+	return te.Token.Literal
+}
+
+func (te *TryExpression) Span() token.Span {
+	return joinSpans(te.Token.Span, te.Body, te.Catch, te.Finally)
+}
+
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Body.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch ")
+		if te.Parameter != nil {
+			out.WriteString("(" + te.Parameter.String() + ") ")
+		}
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
 // ------------------------------------- FunctionLiteral -------------------------------------
 
 type FunctionLiteral struct {
 	Token      token.Token // token.FUNCTION token
+	Name       string      // Name of the let statement the function is bound by, if any
 	Parameters []*Identifier
 	Body       *BlockStatement
 }
@@ -295,6 +539,10 @@ func (fl *FunctionLiteral) TokenLiteral() string {
 	return fl.Token.Literal
 }
 
+func (fl *FunctionLiteral) Span() token.Span {
+	return joinSpans(fl.Token.Span, fl.Body)
+}
+
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -318,6 +566,8 @@ type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
+	RParen    token.Token // The ')' token
+	Tail      bool        // Whether the calling function returns the call's result as is; see MarkTailCalls
 }
 
 func (ce *CallExpression) expressionNode() {}
@@ -326,6 +576,10 @@ func (ce *CallExpression) TokenLiteral() string {
 	return ce.Token.Literal
 }
 
+func (ce *CallExpression) Span() token.Span {
+	return joinSpans(ce.Token.Span.Join(ce.RParen.Span), ce.Function)
+}
+
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -355,15 +609,59 @@ func (sl *StringLiteral) TokenLiteral() string {
 	return sl.Token.Literal
 }
 
+func (sl *StringLiteral) Span() token.Span {
+	return sl.Token.Span
+}
+
 func (sl *StringLiteral) String() string {
 	return sl.Token.Literal
 }
 
+// ------------------------------------- InterpolatedString -------------------------------------
+
+// InterpolatedString is a string with embedded expressions, such as
+// "Hello, ${name}!". Parts alternate between the text, as StringLiterals, and
+// the expressions; empty text is left out.
+type InterpolatedString struct {
+	Token token.Token // token.STRING_HEAD token
+	Parts []Expression
+	Tail  token.Token // token.STRING_TAIL token
+}
+
+func (is *InterpolatedString) expressionNode() {}
+
+func (is *InterpolatedString) TokenLiteral() string {
+	return is.Token.Literal
+}
+
+func (is *InterpolatedString) Span() token.Span {
+	return is.Token.Span.Join(is.Tail.Span)
+}
+
+func (is *InterpolatedString) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(`"`)
+	for _, part := range is.Parts {
+		if text, ok := part.(*StringLiteral); ok {
+			out.WriteString(text.Value)
+			continue
+		}
+		out.WriteString("${")
+		out.WriteString(part.String())
+		out.WriteString("}")
+	}
+	out.WriteString(`"`)
+
+	return out.String()
+}
+
 // ------------------------------------- ArrayLiteral -------------------------------------
 
 type ArrayLiteral struct {
 	Token    token.Token // token.LBRACKET token
 	Elements []Expression
+	RBracket token.Token // token.RBRACKET token
 }
 
 func (al *ArrayLiteral) expressionNode() {}
@@ -372,6 +670,10 @@ func (al *ArrayLiteral) TokenLiteral() string {
 	return al.Token.Literal
 }
 
+func (al *ArrayLiteral) Span() token.Span {
+	return al.Token.Span.Join(al.RBracket.Span)
+}
+
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -390,9 +692,10 @@ func (al *ArrayLiteral) String() string {
 // ------------------------------------- IndexExpression -------------------------------------
 
 type IndexExpression struct {
-	Token token.Token // token.LBRACKET token
-	Left  Expression
-	Index Expression
+	Token    token.Token // token.LBRACKET token
+	Left     Expression
+	Index    Expression
+	RBracket token.Token // token.RBRACKET token
 }
 
 func (ie *IndexExpression) expressionNode() {}
@@ -401,6 +704,10 @@ func (ie *IndexExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *IndexExpression) Span() token.Span {
+	return joinSpans(ie.Token.Span.Join(ie.RBracket.Span), ie.Left)
+}
+
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -413,11 +720,52 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// ------------------------------------- SliceExpression -------------------------------------
+
+// SliceExpression is left[start:end]. Start and End are nil when the bound is
+// left out, as in s[:3] and s[1:].
+type SliceExpression struct {
+	Token    token.Token // token.LBRACKET token
+	Left     Expression
+	Start    Expression
+	End      Expression
+	RBracket token.Token // token.RBRACKET token
+}
+
+func (se *SliceExpression) expressionNode() {}
+
+func (se *SliceExpression) TokenLiteral() string {
+	return se.Token.Literal
+}
+
+func (se *SliceExpression) Span() token.Span {
+	return joinSpans(se.Token.Span.Join(se.RBracket.Span), se.Left)
+}
+
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
 // ------------------------------------- HashLiteral -------------------------------------
 
 type HashLiteral struct {
-	Token token.Token // the '{' token
-	Pairs map[Expression]Expression
+	Token  token.Token // the '{' token
+	Pairs  map[Expression]Expression
+	RBrace token.Token // the '}' token
 }
 
 func (hl *HashLiteral) expressionNode() {
@@ -428,6 +776,10 @@ func (hl *HashLiteral) TokenLiteral() string {
 	return hl.Token.Literal
 }
 
+func (hl *HashLiteral) Span() token.Span {
+	return hl.Token.Span.Join(hl.RBrace.Span)
+}
+
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
@@ -457,6 +809,10 @@ func (ie *IncrementExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *IncrementExpression) Span() token.Span {
+	return joinSpans(ie.Token.Span, ie.Left)
+}
+
 func (ie *IncrementExpression) String() string {
 	var out bytes.Buffer
 
@@ -470,6 +826,7 @@ func (ie *IncrementExpression) String() string {
 
 type WhileExpression struct {
 	Token     token.Token // token.WHILE token
+	Label     *Identifier // Set for labeled loops: `outer: while ...`
 	Condition Expression
 	Body      *BlockStatement
 }
@@ -480,9 +837,16 @@ func (we *WhileExpression) TokenLiteral() string {
 	return we.Token.Literal
 }
 
+func (we *WhileExpression) Span() token.Span {
+	return joinSpans(we.Token.Span, we.Label, we.Body)
+}
+
 func (we *WhileExpression) String() string {
 	var out bytes.Buffer
 
+	if we.Label != nil {
+		out.WriteString(we.Label.String() + ": ")
+	}
 	out.WriteString(we.TokenLiteral() + " ")
 	out.WriteString(we.Condition.String())
 	out.WriteString(" {")
@@ -496,6 +860,7 @@ func (we *WhileExpression) String() string {
 
 type ForExpression struct {
 	Token        token.Token // token.FOR token
+	Label        *Identifier // Set for labeled loops: `outer: for ...`
 	LetStatement *LetStatement
 	Condition    Expression
 	Increment    Expression
@@ -508,9 +873,16 @@ func (fe *ForExpression) TokenLiteral() string {
 	return fe.Token.Literal
 }
 
+func (fe *ForExpression) Span() token.Span {
+	return joinSpans(fe.Token.Span, fe.Label, fe.Body)
+}
+
 func (fe *ForExpression) String() string {
 	var out bytes.Buffer
 
+	if fe.Label != nil {
+		out.WriteString(fe.Label.String() + ": ")
+	}
 	out.WriteString(fe.TokenLiteral() + " ")
 	out.WriteString(fe.LetStatement.String())
 	out.WriteString("; ")
@@ -524,6 +896,73 @@ func (fe *ForExpression) String() string {
 	return out.String()
 }
 
+// ------------------------------------- ForInExpression -------------------------------------
+
+// ForInExpression is `for (value in iterable) { ... }`, or with two names
+// `for (key, value in iterable) { ... }`.
+type ForInExpression struct {
+	Token    token.Token // token.FOR token
+	Label    *Identifier // Set for labeled loops: `outer: for ...`
+	Key      *Identifier // Index or hash key; nil when only a value is bound
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fe *ForInExpression) expressionNode() {}
+
+func (fe *ForInExpression) TokenLiteral() string {
+	return fe.Token.Literal
+}
+
+func (fe *ForInExpression) Span() token.Span {
+	return joinSpans(fe.Token.Span, fe.Label, fe.Body)
+}
+
+func (fe *ForInExpression) String() string {
+	var out bytes.Buffer
+
+	if fe.Label != nil {
+		out.WriteString(fe.Label.String() + ": ")
+	}
+	out.WriteString(fe.TokenLiteral() + " (")
+	if fe.Key != nil {
+		out.WriteString(fe.Key.String() + ", ")
+	}
+	out.WriteString(fe.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fe.Iterable.String())
+	out.WriteString(") {")
+	out.WriteString(fe.Body.String())
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// ------------------------------------- RangeExpression -------------------------------------
+
+// RangeExpression is `start..end`, or `start..=end` when End is included.
+type RangeExpression struct {
+	Token     token.Token // The '..' or '..=' token
+	Start     Expression
+	End       Expression
+	Inclusive bool
+}
+
+func (re *RangeExpression) expressionNode() {}
+
+func (re *RangeExpression) TokenLiteral() string {
+	return re.Token.Literal
+}
+
+func (re *RangeExpression) Span() token.Span {
+	return joinSpans(re.Token.Span, re.Start, re.End)
+}
+
+func (re *RangeExpression) String() string {
+	return "(" + re.Start.String() + re.Token.Literal + re.End.String() + ")"
+}
+
 // ------------------------------------- AssignmentExpression -------------------------------------
 type AssignmentExpression struct {
 	Token token.Token // The '=' token
@@ -533,6 +972,10 @@ type AssignmentExpression struct {
 
 func (ae *AssignmentExpression) expressionNode()      {}
 func (ae *AssignmentExpression) TokenLiteral() string { return ae.Token.Literal }
+
+func (ae *AssignmentExpression) Span() token.Span {
+	return joinSpans(ae.Token.Span, ae.Left, ae.Right)
+}
 func (ae *AssignmentExpression) String() string {
 	var out bytes.Buffer
 
@@ -556,6 +999,10 @@ func (pre *PointerReferenceExpression) TokenLiteral() string {
 	return pre.Token.Literal
 }
 
+func (pre *PointerReferenceExpression) Span() token.Span {
+	return joinSpans(pre.Token.Span, pre.Right)
+}
+
 func (pre *PointerReferenceExpression) String() string {
 	var out bytes.Buffer
 
@@ -578,6 +1025,10 @@ func (pde *PointerDereferenceExpression) TokenLiteral() string {
 	return pde.Token.Literal
 }
 
+func (pde *PointerDereferenceExpression) Span() token.Span {
+	return joinSpans(pde.Token.Span, pde.Right)
+}
+
 func (pde *PointerDereferenceExpression) String() string {
 	var out bytes.Buffer
 
@@ -0,0 +1,52 @@
+package ast
+
+// MarkTailCalls sets Tail on the calls in fn's body whose result fn returns
+// as is: the value of a return statement, and the last expression of the
+// body, looking through if and else branches. Nothing is left for fn to do
+// after such a call, so an interpreter may run it in place of fn rather than
+// nested inside it. Calls inside a try are never marked, as the try still has
+// to handle their errors; nested function literals are marked when they are
+// parsed.
+func MarkTailCalls(fn *FunctionLiteral) {
+	markTailBlock(fn.Body, true)
+}
+
+// markTailBlock marks the tail calls of block, whose value is the value of
+// the function when tail is set.
+func markTailBlock(block *BlockStatement, tail bool) {
+	if block == nil {
+		return
+	}
+	for i, statement := range block.Statements {
+		markTailStatement(statement, tail && i == len(block.Statements)-1)
+	}
+}
+
+func markTailStatement(statement Statement, tail bool) {
+	switch statement := statement.(type) {
+	case *ReturnStatement:
+		markTailExpression(statement.ReturnValue, true)
+	case *ExpressionStatement:
+		markTailExpression(statement.Expression, tail)
+	case *LetStatement:
+		markTailExpression(statement.Value, false)
+	}
+}
+
+// markTailExpression marks exp as a tail call if it is a call and tail is
+// set, and looks for return statements in the blocks it runs.
+func markTailExpression(exp Expression, tail bool) {
+	switch exp := exp.(type) {
+	case *CallExpression:
+		exp.Tail = tail
+	case *IfExpression:
+		markTailBlock(exp.Consequence, tail)
+		markTailBlock(exp.Alternative, tail)
+	case *WhileExpression:
+		markTailBlock(exp.Body, false)
+	case *ForExpression:
+		markTailBlock(exp.Body, false)
+	case *ForInExpression:
+		markTailBlock(exp.Body, false)
+	}
+}
@@ -0,0 +1,299 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), operandCount)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(def.Name)
+	for _, operand := range operands {
+		fmt.Fprintf(&out, " %d", operand)
+	}
+
+	return out.String()
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+	OpNull
+	OpTrue
+	OpFalse
+
+	// Operators
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
+	OpEqual
+	OpNotEqual
+	OpLessThan
+	OpGreaterThan
+	OpLessEqual
+	OpGreaterEqual
+	OpMinus
+	OpPlus
+	OpBang
+	OpBitNot
+	OpIncrement
+
+	// Control flow
+	OpJump
+	OpJumpNotTruthy
+
+	// Variables
+	OpGetGlobal
+	OpSetGlobal
+	OpDefineGlobal
+	OpNewGlobalCell
+	OpGetLocal
+	OpSetLocal
+	OpGetCell
+	OpSetCell
+	OpDefineCell
+	OpNewCell
+	OpGetFree
+	OpSetFree
+
+	// Data structures
+	OpArray
+	OpInterpolate
+	OpHash
+	OpIndex
+	OpSlice
+	OpSetIndex
+	OpRange
+
+	// Iteration
+	OpIterator
+	OpIterNext
+
+	// Functions
+	OpClosure
+	OpCall
+	OpTailCall
+	OpReturnValue
+
+	// Pointers
+	OpAddress
+	OpAddressIndex
+	OpDeref
+	OpSetDeref
+
+	// Errors
+	OpError
+	OpTry
+	OpEndTry
+	OpThrow
+)
+
+// Operand scopes used by OpAddress.
+const (
+	ScopeGlobal = iota
+	ScopeLocal
+	ScopeFree
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+	OpNull:     {"OpNull", []int{}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpPow:          {"OpPow", []int{}},
+	OpBitAnd:       {"OpBitAnd", []int{}},
+	OpBitOr:        {"OpBitOr", []int{}},
+	OpBitXor:       {"OpBitXor", []int{}},
+	OpShiftLeft:    {"OpShiftLeft", []int{}},
+	OpShiftRight:   {"OpShiftRight", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+	OpMinus:        {"OpMinus", []int{}},
+	OpPlus:         {"OpPlus", []int{}},
+	OpBang:         {"OpBang", []int{}},
+	OpBitNot:       {"OpBitNot", []int{}},
+	OpIncrement:    {"OpIncrement", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+
+	// Define operands: slot index, mutable flag
+	OpGetGlobal:     {"OpGetGlobal", []int{2}},
+	OpSetGlobal:     {"OpSetGlobal", []int{2}},
+	OpDefineGlobal:  {"OpDefineGlobal", []int{2, 1}},
+	OpNewGlobalCell: {"OpNewGlobalCell", []int{2}},
+	OpGetLocal:      {"OpGetLocal", []int{1}},
+	OpSetLocal:      {"OpSetLocal", []int{1}},
+	OpGetCell:       {"OpGetCell", []int{1}},
+	OpSetCell:       {"OpSetCell", []int{1}},
+	OpDefineCell:    {"OpDefineCell", []int{1, 1}},
+	OpNewCell:       {"OpNewCell", []int{1}},
+	OpGetFree:       {"OpGetFree", []int{1}},
+	OpSetFree:       {"OpSetFree", []int{1}},
+
+	OpArray:       {"OpArray", []int{2}},
+	OpInterpolate: {"OpInterpolate", []int{2}},
+	OpHash:        {"OpHash", []int{2}},
+	OpIndex:       {"OpIndex", []int{}},
+	OpSlice:       {"OpSlice", []int{}},
+	OpSetIndex:    {"OpSetIndex", []int{}},
+
+	// Range operands: inclusive flag
+	OpRange: {"OpRange", []int{1}},
+
+	// OpIterator operands: whether the loop binds keys as well as values.
+	// OpIterNext pushes the next key and value, or jumps to its operand once
+	// the iterator below them is exhausted
+	OpIterator: {"OpIterator", []int{1}},
+	OpIterNext: {"OpIterNext", []int{2}},
+
+	// Closure operands: function constant index, number of free variables
+	OpClosure:     {"OpClosure", []int{2, 1}},
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+
+	// OpTailCall operands: number of arguments. It calls a closure in place
+	// of the current frame, which returns the result as is; anything else is
+	// called as by OpCall
+	OpTailCall: {"OpTailCall", []int{1}},
+
+	// Address operands: scope, slot index, constant index of the variable name
+	OpAddress:      {"OpAddress", []int{1, 2, 2}},
+	OpAddressIndex: {"OpAddressIndex", []int{}},
+	OpDeref:        {"OpDeref", []int{}},
+	OpSetDeref:     {"OpSetDeref", []int{}},
+
+	// Error operands: constant index of the message
+	OpError: {"OpError", []int{2}},
+
+	// OpTry operands: position of the handler that receives errors raised
+	// before the matching OpEndTry
+	OpTry:    {"OpTry", []int{2}},
+	OpEndTry: {"OpEndTry", []int{}},
+	OpThrow:  {"OpThrow", []int{}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// Make encodes an instruction. Operands are written big-endian using the
+// widths from the opcode's definition.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction and returns them along
+// with the number of bytes read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
@@ -0,0 +1,133 @@
+package compiler
+
+import (
+	"ember_lang/ember_lang/ast"
+)
+
+// capturedNames returns the names that must be stored in cells when they are
+// declared as locals of fn: every name mentioned inside a nested function
+// literal, and every name whose address is taken with &. The set is
+// conservative; boxing a local that is never captured only costs an
+// allocation.
+func capturedNames(fn *ast.FunctionLiteral) map[string]bool {
+	captured := make(map[string]bool)
+	collectCaptured(fn.Body, false, captured)
+	return captured
+}
+
+// capturedInProgram returns the top-level names that closures or pointers
+// refer to. Those declared in a block are boxed like captured locals.
+func capturedInProgram(program *ast.Program) map[string]bool {
+	captured := make(map[string]bool)
+	for _, statement := range program.Statements {
+		collectCaptured(statement, false, captured)
+	}
+	return captured
+}
+
+// addressedVariable returns the variable whose storage &target points into:
+// x for &x, &x[1] and &x[1]["k"].
+func addressedVariable(target ast.Expression) (*ast.Identifier, bool) {
+	for {
+		switch node := target.(type) {
+		case *ast.Identifier:
+			return node, true
+		case *ast.IndexExpression:
+			target = node.Left
+		default:
+			return nil, false
+		}
+	}
+}
+
+func collectCaptured(node ast.Node, nested bool, captured map[string]bool) {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		if node == nil {
+			return
+		}
+		for _, statement := range node.Statements {
+			collectCaptured(statement, nested, captured)
+		}
+	case *ast.ExpressionStatement:
+		collectCaptured(node.Expression, nested, captured)
+	case *ast.LetStatement:
+		collectCaptured(node.Value, nested, captured)
+	case *ast.ReturnStatement:
+		collectCaptured(node.ReturnValue, nested, captured)
+	case *ast.ThrowStatement:
+		collectCaptured(node.Value, nested, captured)
+	case *ast.Identifier:
+		if nested {
+			captured[node.Value] = true
+		}
+	case *ast.PrefixExpression:
+		collectCaptured(node.Right, nested, captured)
+	case *ast.InfixExpression:
+		collectCaptured(node.Left, nested, captured)
+		collectCaptured(node.Right, nested, captured)
+	case *ast.IfExpression:
+		collectCaptured(node.Condition, nested, captured)
+		collectCaptured(node.Consequence, nested, captured)
+		collectCaptured(node.Alternative, nested, captured)
+	case *ast.TryExpression:
+		collectCaptured(node.Body, nested, captured)
+		collectCaptured(node.Catch, nested, captured)
+		collectCaptured(node.Finally, nested, captured)
+	case *ast.FunctionLiteral:
+		collectCaptured(node.Body, true, captured)
+	case *ast.CallExpression:
+		collectCaptured(node.Function, nested, captured)
+		for _, argument := range node.Arguments {
+			collectCaptured(argument, nested, captured)
+		}
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			collectCaptured(element, nested, captured)
+		}
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			collectCaptured(part, nested, captured)
+		}
+	case *ast.HashLiteral:
+		for key, value := range node.Pairs {
+			collectCaptured(key, nested, captured)
+			collectCaptured(value, nested, captured)
+		}
+	case *ast.IndexExpression:
+		collectCaptured(node.Left, nested, captured)
+		collectCaptured(node.Index, nested, captured)
+	case *ast.SliceExpression:
+		collectCaptured(node.Left, nested, captured)
+		collectCaptured(node.Start, nested, captured)
+		collectCaptured(node.End, nested, captured)
+	case *ast.IncrementExpression:
+		collectCaptured(node.Left, nested, captured)
+	case *ast.WhileExpression:
+		collectCaptured(node.Condition, nested, captured)
+		collectCaptured(node.Body, nested, captured)
+	case *ast.ForExpression:
+		if node.LetStatement != nil {
+			collectCaptured(node.LetStatement, nested, captured)
+		}
+		collectCaptured(node.Condition, nested, captured)
+		collectCaptured(node.Increment, nested, captured)
+		collectCaptured(node.Body, nested, captured)
+	case *ast.ForInExpression:
+		collectCaptured(node.Iterable, nested, captured)
+		collectCaptured(node.Body, nested, captured)
+	case *ast.RangeExpression:
+		collectCaptured(node.Start, nested, captured)
+		collectCaptured(node.End, nested, captured)
+	case *ast.AssignmentExpression:
+		collectCaptured(node.Left, nested, captured)
+		collectCaptured(node.Right, nested, captured)
+	case *ast.PointerReferenceExpression:
+		if identifier, ok := addressedVariable(node.Right); ok {
+			captured[identifier.Value] = true
+		}
+		collectCaptured(node.Right, nested, captured)
+	case *ast.PointerDereferenceExpression:
+		collectCaptured(node.Right, nested, captured)
+	}
+}
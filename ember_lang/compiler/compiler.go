@@ -0,0 +1,1232 @@
+package compiler
+
+import (
+	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/code"
+	"ember_lang/ember_lang/evaluator"
+	"ember_lang/ember_lang/object"
+	"ember_lang/ember_lang/token"
+	"fmt"
+	"sort"
+)
+
+type Bytecode struct {
+	Instructions code.Instructions
+	Spans        []token.Span
+	Constants    []object.Object
+	GlobalNames  []string
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+type CompilationScope struct {
+	instructions        code.Instructions
+	spans               []token.Span
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	loops []*loopScope // Loops being compiled, innermost last
+	tries []*tryScope  // Try blocks whose handler is active, innermost last
+}
+
+// loopScope collects the jumps emitted for break and continue inside a loop,
+// to be pointed at their targets once the loop is compiled.
+type loopScope struct {
+	label     string
+	iterator  bool // A for-in loop, which keeps its iterator on the stack
+	breaks    []int
+	continues []int
+}
+
+// tryScope is a try block, or a catch clause guarded by a finally clause,
+// that return, break and continue must leave by removing its handler and
+// running its finally clause.
+type tryScope struct {
+	finally *ast.BlockStatement
+	loops   int // Number of loops around the try block
+}
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	span token.Span // Source of the node being compiled
+}
+
+func New() *Compiler {
+	return NewWithState(NewSymbolTable(), []object.Object{})
+}
+
+// NewWithState creates a compiler that keeps defining globals and constants
+// on top of an earlier compilation, as the REPL does between lines.
+func NewWithState(symbolTable *SymbolTable, constants []object.Object) *Compiler {
+	return &Compiler{
+		constants:   constants,
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{{}},
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Spans:        c.scopes[c.scopeIndex].spans,
+		Constants:    c.constants,
+		GlobalNames:  c.symbolTable.Global().Names(),
+	}
+}
+
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+func (c *Compiler) Constants() []object.Object {
+	return c.constants
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	if span := node.Span(); span.IsValid() {
+		previous := c.span
+		c.span = span
+		defer func() { c.span = previous }()
+	}
+
+	switch node := node.(type) {
+	// Statements
+	case *ast.Program:
+		c.symbolTable.captured = capturedInProgram(node)
+		if err := c.compileStatements(node.Statements); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.ExpressionStatement:
+		if err := c.compileExpression(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.LetStatement:
+		return c.compileLetStatement(node)
+
+	case *ast.ReturnStatement:
+		if err := c.compileExpression(node.ReturnValue); err != nil {
+			return err
+		}
+		if _, err := c.exitTries(c.scopes[c.scopeIndex].tries, 0); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.ThrowStatement:
+		if err := c.compileExpression(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.BreakStatement:
+		loop, err := c.exitLoops(node.Label)
+		if err != nil {
+			return err
+		}
+		if loop == nil {
+			return fmt.Errorf("break outside of a loop")
+		}
+		loop.breaks = append(loop.breaks, c.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		loop, err := c.exitLoops(node.Label)
+		if err != nil {
+			return err
+		}
+		if loop == nil {
+			return fmt.Errorf("continue outside of a loop")
+		}
+		loop.continues = append(loop.continues, c.emit(code.OpJump, 9999))
+
+	// Expressions
+	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			c.emit(code.OpConstant, c.addConstant(&object.BigInt{Value: node.Big}))
+		} else {
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+		}
+
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			if err := c.compileExpression(element); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			if err := c.compileExpression(part); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpInterpolate, len(node.Parts))
+
+	case *ast.HashLiteral:
+		return c.compileHashLiteral(node)
+
+	case *ast.PrefixExpression:
+		if err := c.compileExpression(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		case "+":
+			c.emit(code.OpPlus)
+		case "~":
+			c.emit(code.OpBitNot)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		return c.compileInfixExpression(node)
+
+	case *ast.IndexExpression:
+		if err := c.compileExpression(node.Left); err != nil {
+			return err
+		}
+		if err := c.compileExpression(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.SliceExpression:
+		if err := c.compileExpression(node.Left); err != nil {
+			return err
+		}
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+			if err := c.compileExpression(bound); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSlice)
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+
+	case *ast.TryExpression:
+		return c.compileTryExpression(node)
+
+	case *ast.Identifier:
+		c.loadIdentifier(node.Value)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node)
+
+	case *ast.CallExpression:
+		if err := c.compileExpression(node.Function); err != nil {
+			return err
+		}
+		for _, argument := range node.Arguments {
+			if err := c.compileExpression(argument); err != nil {
+				return err
+			}
+		}
+		if node.Tail {
+			c.emit(code.OpTailCall, len(node.Arguments))
+		} else {
+			c.emit(code.OpCall, len(node.Arguments))
+		}
+
+	case *ast.IncrementExpression:
+		return c.compileIncrementExpression(node)
+
+	case *ast.WhileExpression:
+		return c.compileWhileExpression(node)
+
+	case *ast.ForExpression:
+		return c.compileForExpression(node)
+
+	case *ast.ForInExpression:
+		return c.compileForInExpression(node)
+
+	case *ast.RangeExpression:
+		if err := c.compileExpression(node.Start); err != nil {
+			return err
+		}
+		if err := c.compileExpression(node.End); err != nil {
+			return err
+		}
+		inclusive := 0
+		if node.Inclusive {
+			inclusive = 1
+		}
+		c.emit(code.OpRange, inclusive)
+
+	case *ast.AssignmentExpression:
+		return c.compileAssignmentExpression(node)
+
+	case *ast.PointerReferenceExpression:
+		return c.compilePointerReferenceExpression(node)
+
+	case *ast.PointerDereferenceExpression:
+		if err := c.compileExpression(node.Right); err != nil {
+			return err
+		}
+		c.emit(code.OpDeref)
+
+	default:
+		return fmt.Errorf("cannot compile node %T", node)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileExpression(expression ast.Expression) error {
+	if expression == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+	return c.Compile(expression)
+}
+
+// compileStatements compiles a statement list so that it leaves the value of
+// its last statement on the stack, like evalBlockStatement returns it.
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	if len(statements) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	for i, statement := range statements {
+		if err := c.Compile(statement); err != nil {
+			return err
+		}
+
+		if i != len(statements)-1 {
+			continue
+		}
+
+		switch statement := statement.(type) {
+		case *ast.ExpressionStatement:
+			c.removeLastPop()
+		case *ast.LetStatement:
+			symbol, _ := c.symbolTable.Resolve(statement.Name.Value)
+			c.loadSymbol(symbol)
+		case *ast.ReturnStatement, *ast.ThrowStatement, *ast.BreakStatement, *ast.ContinueStatement:
+			// Control never falls through these
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileBlockStatement(block *ast.BlockStatement) error {
+	if block == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	return c.compileStatements(block.Statements)
+}
+
+func (c *Compiler) compileLetStatement(node *ast.LetStatement) error {
+	// Functions are bound before their body is compiled so they can refer
+	// to themselves recursively.
+	if _, ok := node.Value.(*ast.FunctionLiteral); ok {
+		symbol := c.defineName(node.Name.Value, node.Name.Mutable)
+		if err := c.compileExpression(node.Value); err != nil {
+			return err
+		}
+		c.defineSymbol(symbol)
+		return nil
+	}
+
+	if err := c.compileExpression(node.Value); err != nil {
+		return err
+	}
+	symbol := c.defineName(node.Name.Value, node.Name.Mutable)
+	c.defineSymbol(symbol)
+
+	return nil
+}
+
+// defineName binds name in the current scope. A captured variable declared
+// in a block gets a fresh cell each time the block runs, so closures made in
+// different iterations of a loop do not share it.
+func (c *Compiler) defineName(name string, mutable bool) Symbol {
+	_, redefined := c.symbolTable.store[name]
+	symbol := c.symbolTable.Define(name, mutable)
+	if !redefined {
+		c.newCell(symbol)
+	}
+	return symbol
+}
+
+func (c *Compiler) newCell(s Symbol) {
+	if !c.symbolTable.block || !s.Boxed {
+		return
+	}
+
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpNewGlobalCell, s.Index)
+	case LocalScope:
+		c.emit(code.OpNewCell, s.Index)
+	}
+}
+
+func (c *Compiler) compileHashLiteral(node *ast.HashLiteral) error {
+	keys := []ast.Expression{}
+	for key := range node.Pairs {
+		keys = append(keys, key)
+	}
+
+	// Go maps iterate in random order; sort for deterministic bytecode
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	for _, key := range keys {
+		if err := c.compileExpression(key); err != nil {
+			return err
+		}
+		if err := c.compileExpression(node.Pairs[key]); err != nil {
+			return err
+		}
+	}
+
+	c.emit(code.OpHash, len(node.Pairs)*2)
+
+	return nil
+}
+
+func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
+	if node.Operator == "&&" || node.Operator == "||" {
+		return c.compileLogicalExpression(node)
+	}
+
+	if err := c.compileExpression(node.Left); err != nil {
+		return err
+	}
+	if err := c.compileExpression(node.Right); err != nil {
+		return err
+	}
+
+	switch node.Operator {
+	case "+":
+		c.emit(code.OpAdd)
+	case "-":
+		c.emit(code.OpSub)
+	case "*":
+		c.emit(code.OpMul)
+	case "/":
+		c.emit(code.OpDiv)
+	case "%":
+		c.emit(code.OpMod)
+	case "**":
+		c.emit(code.OpPow)
+	case "&":
+		c.emit(code.OpBitAnd)
+	case "|":
+		c.emit(code.OpBitOr)
+	case "^":
+		c.emit(code.OpBitXor)
+	case "<<":
+		c.emit(code.OpShiftLeft)
+	case ">>":
+		c.emit(code.OpShiftRight)
+	case "==":
+		c.emit(code.OpEqual)
+	case "!=":
+		c.emit(code.OpNotEqual)
+	case "<":
+		c.emit(code.OpLessThan)
+	case ">":
+		c.emit(code.OpGreaterThan)
+	case "<=":
+		c.emit(code.OpLessEqual)
+	case ">=":
+		c.emit(code.OpGreaterEqual)
+	default:
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+
+	return nil
+}
+
+// compileLogicalExpression compiles && and || so the right operand only runs
+// when the left one does not decide the result.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	if err := c.compileExpression(node.Left); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if node.Operator == "&&" {
+		if err := c.compileTruthiness(node.Right); err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.emit(code.OpFalse)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+		return nil
+	}
+
+	c.emit(code.OpTrue)
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	if err := c.compileTruthiness(node.Right); err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+// compileTruthiness pushes the truthiness of expression as a boolean.
+func (c *Compiler) compileTruthiness(expression ast.Expression) error {
+	if err := c.compileExpression(expression); err != nil {
+		return err
+	}
+
+	// !!x is TRUE exactly when x is truthy
+	c.emit(code.OpBang)
+	c.emit(code.OpBang)
+
+	return nil
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.compileExpression(node.Condition); err != nil {
+		return err
+	}
+
+	// Emit an `OpJumpNotTruthy` with a bogus value
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlockStatement(node.Consequence); err != nil {
+		return err
+	}
+
+	// Emit an `OpJump` with a bogus value
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else {
+		if err := c.compileBlockStatement(node.Alternative); err != nil {
+			return err
+		}
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+// compileTryExpression compiles a try expression as
+//
+//	OpTry catch; body; OpEndTry; finally; OpJump end
+//	catch:   OpTry rethrow; bind the error; catch body; OpEndTry; finally; OpJump end
+//	rethrow: finally; OpThrow
+//	end:
+//
+// where the handler around the catch body and the rethrow code are only
+// emitted with a finally clause, and the catch code only with a catch clause.
+// Handlers receive the caught error on the stack.
+func (c *Compiler) compileTryExpression(node *ast.TryExpression) error {
+	tryPos := c.emit(code.OpTry, 9999)
+
+	c.enterTry(node.Finally)
+	err := c.compileBlockStatement(node.Body)
+	c.leaveTry()
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpEndTry)
+	if err := c.compileFinally(node.Finally); err != nil {
+		return err
+	}
+	jumps := []int{c.emit(code.OpJump, 9999)}
+
+	c.changeOperand(tryPos, len(c.currentInstructions()))
+
+	if node.Catch != nil {
+		if node.Finally != nil {
+			tryPos = c.emit(code.OpTry, 9999)
+			c.enterTry(node.Finally)
+		}
+
+		if err := c.compileCatchClause(node); err != nil {
+			return err
+		}
+
+		if node.Finally != nil {
+			c.leaveTry()
+			c.emit(code.OpEndTry)
+			if err := c.compileFinally(node.Finally); err != nil {
+				return err
+			}
+			jumps = append(jumps, c.emit(code.OpJump, 9999))
+			c.changeOperand(tryPos, len(c.currentInstructions()))
+		}
+	}
+
+	if node.Finally != nil {
+		// An error is leaving the try expression: run the finally clause on
+		// the way out
+		if err := c.compileFinally(node.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	end := len(c.currentInstructions())
+	for _, position := range jumps {
+		c.changeOperand(position, end)
+	}
+
+	return nil
+}
+
+// compileCatchClause binds the caught error on top of the stack and compiles
+// the catch body. The error variable lives in the scope of the clause.
+func (c *Compiler) compileCatchClause(node *ast.TryExpression) error {
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	if node.Parameter != nil {
+		c.defineSymbol(c.defineName(node.Parameter.Value, false))
+	} else {
+		c.emit(code.OpPop)
+	}
+
+	return c.compileBlockStatement(node.Catch)
+}
+
+// compileFinally compiles a finally clause for its effects, discarding its
+// value.
+func (c *Compiler) compileFinally(finally *ast.BlockStatement) error {
+	if finally == nil {
+		return nil
+	}
+	if err := c.compileBlockStatement(finally); err != nil {
+		return err
+	}
+	c.emit(code.OpPop)
+	return nil
+}
+
+func (c *Compiler) enterTry(finally *ast.BlockStatement) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = append(scope.tries, &tryScope{finally: finally, loops: len(scope.loops)})
+}
+
+func (c *Compiler) leaveTry() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+}
+
+// exitTries compiles the way out of the innermost of tries that lie inside
+// at least loops loops, for a return, break or continue that jumps past
+// them: each handler is removed and each finally clause run. It returns the
+// tries that are left.
+func (c *Compiler) exitTries(tries []*tryScope, loops int) ([]*tryScope, error) {
+	for len(tries) > 0 && tries[len(tries)-1].loops >= loops {
+		try := tries[len(tries)-1]
+		tries = tries[:len(tries)-1]
+
+		c.emit(code.OpEndTry)
+		if try.finally == nil {
+			continue
+		}
+
+		// The finally clause runs outside the try block and the loops in it
+		scope := &c.scopes[c.scopeIndex]
+		savedTries, savedLoops := scope.tries, scope.loops
+		scope.tries, scope.loops = tries, scope.loops[:try.loops]
+		err := c.compileFinally(try.finally)
+		scope = &c.scopes[c.scopeIndex]
+		scope.tries, scope.loops = savedTries, savedLoops
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tries, nil
+}
+
+func (c *Compiler) compileWhileExpression(node *ast.WhileExpression) error {
+	loopStart := len(c.currentInstructions())
+
+	if err := c.compileExpression(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	loop := c.enterLoop(node.Label)
+	if err := c.compileBlockStatement(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpPop)
+	c.emit(code.OpJump, loopStart)
+
+	loopEnd := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, loopEnd)
+	c.leaveLoop(loop, loopStart, loopEnd)
+
+	// Loops evaluate to null
+	c.emit(code.OpNull)
+
+	return nil
+}
+
+func (c *Compiler) compileForExpression(node *ast.ForExpression) error {
+	letStatement := node.LetStatement
+
+	// The loop variable lives in the scope of the loop header
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	// The loop variable is always mutable so the increment can update it
+	if err := c.compileExpression(letStatement.Value); err != nil {
+		return err
+	}
+	symbol := c.defineName(letStatement.Name.Value, true)
+	c.defineSymbol(symbol)
+
+	loopStart := len(c.currentInstructions())
+
+	if err := c.compileExpression(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	loop := c.enterLoop(node.Label)
+	if err := c.compileBlockStatement(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpPop)
+
+	incrementStart := len(c.currentInstructions())
+	if err := c.compileExpression(node.Increment); err != nil {
+		return err
+	}
+	c.defineSymbol(symbol)
+	c.emit(code.OpJump, loopStart)
+
+	loopEnd := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, loopEnd)
+	c.leaveLoop(loop, incrementStart, loopEnd)
+
+	// Loops evaluate to null
+	c.emit(code.OpNull)
+
+	return nil
+}
+
+func (c *Compiler) compileForInExpression(node *ast.ForInExpression) error {
+	if err := c.compileExpression(node.Iterable); err != nil {
+		return err
+	}
+	withKeys := 0
+	if node.Key != nil {
+		withKeys = 1
+	}
+	c.emit(code.OpIterator, withKeys)
+
+	// The loop variables are defined after the iterable so it still sees
+	// any outer variables of the same name
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	value := c.symbolTable.Define(node.Value.Value, false)
+	var key Symbol
+	if node.Key != nil {
+		key = c.symbolTable.Define(node.Key.Value, false)
+	}
+
+	loopStart := len(c.currentInstructions())
+	iterNextPos := c.emit(code.OpIterNext, 9999)
+
+	// Each iteration binds the loop variables afresh
+	c.newCell(value)
+	c.defineSymbol(value)
+	if node.Key != nil {
+		c.newCell(key)
+		c.defineSymbol(key)
+	} else {
+		c.emit(code.OpPop)
+	}
+
+	loop := c.enterLoop(node.Label)
+	loop.iterator = true
+	if err := c.compileBlockStatement(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpPop)
+	c.emit(code.OpJump, loopStart)
+
+	loopEnd := len(c.currentInstructions())
+	c.changeOperand(iterNextPos, loopEnd)
+	c.leaveLoop(loop, loopStart, loopEnd)
+
+	// Drop the iterator; loops evaluate to null
+	c.emit(code.OpPop)
+	c.emit(code.OpNull)
+
+	return nil
+}
+
+func (c *Compiler) enterLoop(label *ast.Identifier) *loopScope {
+	loop := &loopScope{}
+	if label != nil {
+		loop.label = label.Value
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, loop)
+
+	return loop
+}
+
+// leaveLoop points the loop's continue jumps at continueTarget and its break
+// jumps at breakTarget.
+func (c *Compiler) leaveLoop(loop *loopScope, continueTarget int, breakTarget int) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, position := range loop.continues {
+		c.changeOperand(position, continueTarget)
+	}
+	for _, position := range loop.breaks {
+		c.changeOperand(position, breakTarget)
+	}
+}
+
+// exitLoops returns the loop a break or continue with the given label applies
+// to, or nil if there is none in the current function. It pops the iterators
+// of the for-in loops nested inside that loop and leaves the try blocks in
+// it, innermost first, as the jump leaves them.
+func (c *Compiler) exitLoops(label *ast.Identifier) (*loopScope, error) {
+	loops := c.scopes[c.scopeIndex].loops
+	for i := len(loops) - 1; i >= 0; i-- {
+		if label != nil && loops[i].label != label.Value {
+			continue
+		}
+
+		tries := c.scopes[c.scopeIndex].tries
+		var err error
+		for inner := len(loops) - 1; inner > i; inner-- {
+			if tries, err = c.exitTries(tries, inner+1); err != nil {
+				return nil, err
+			}
+			if loops[inner].iterator {
+				c.emit(code.OpPop)
+			}
+		}
+		if _, err = c.exitTries(tries, i+1); err != nil {
+			return nil, err
+		}
+		return loops[i], nil
+	}
+	return nil, nil
+}
+
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	c.enterScope(capturedNames(node))
+
+	for _, parameter := range node.Parameters {
+		c.symbolTable.Define(parameter.Value, parameter.Mutable)
+	}
+
+	if err := c.compileBlockStatement(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpReturnValue)
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.Names()
+	cells := c.symbolTable.Cells()
+	instructions, spans := c.leaveScope()
+
+	captures := make([]object.Capture, len(freeSymbols))
+	for i, symbol := range freeSymbols {
+		if symbol.Scope == LocalScope && !symbol.Boxed {
+			return fmt.Errorf("free variable %s is not stored in a cell", symbol.Name)
+		}
+		captures[i] = object.Capture{
+			Name:   symbol.Name,
+			Local:  symbol.Scope == LocalScope,
+			Global: symbol.Scope == GlobalScope,
+			Index:  symbol.Index,
+		}
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		Spans:         spans,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		LocalNames:    localNames,
+		Cells:         cells,
+		Free:          captures,
+		Literal:       node,
+	}
+
+	c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+
+	return nil
+}
+
+func (c *Compiler) compileAssignmentExpression(node *ast.AssignmentExpression) error {
+	switch left := node.Left.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(left.Value)
+		if !ok || !symbol.Mutable {
+			c.emitError(left.Span(), "Cannot assign to immutable variable: %s", left.Value)
+			return nil
+		}
+
+		if err := c.compileExpression(node.Right); err != nil {
+			return err
+		}
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+
+	case *ast.PointerDereferenceExpression:
+		if err := c.compileExpression(left.Right); err != nil {
+			return err
+		}
+		if err := c.compileExpression(node.Right); err != nil {
+			return err
+		}
+		c.emit(code.OpSetDeref)
+
+	case *ast.IndexExpression:
+		if err := c.compileExpression(left.Left); err != nil {
+			return err
+		}
+
+		if identifier, ok := left.Left.(*ast.Identifier); ok {
+			if symbol, ok := c.symbolTable.Resolve(identifier.Value); !ok || !symbol.Mutable {
+				c.emitError(identifier.Span(), "Cannot assign to immutable variable: %s", identifier.Value)
+				return nil
+			}
+		}
+
+		if err := c.compileExpression(left.Index); err != nil {
+			return err
+		}
+		if err := c.compileExpression(node.Right); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
+
+	default:
+		c.emitError(c.span, "invalid assignment target")
+	}
+
+	return nil
+}
+
+// compileIncrementExpression compiles x++, storing the result back when x is
+// a variable.
+func (c *Compiler) compileIncrementExpression(node *ast.IncrementExpression) error {
+	if err := c.compileExpression(node.Left); err != nil {
+		return err
+	}
+	c.emit(code.OpIncrement)
+
+	identifier, ok := node.Left.(*ast.Identifier)
+	if !ok {
+		return nil
+	}
+
+	// Builtins are not variables; incrementing one fails at runtime
+	symbol, ok := c.symbolTable.Resolve(identifier.Value)
+	if !ok {
+		return nil
+	}
+	if !symbol.Mutable {
+		c.emitError(identifier.Span(), "Cannot assign to immutable variable: %s", identifier.Value)
+		return nil
+	}
+	c.storeSymbol(symbol)
+	c.loadSymbol(symbol)
+
+	return nil
+}
+
+func (c *Compiler) compilePointerReferenceExpression(node *ast.PointerReferenceExpression) error {
+	return c.compileAddress(node.Right)
+}
+
+// compileAddress pushes a pointer to the variable, array element or hash
+// entry that target names.
+func (c *Compiler) compileAddress(target ast.Expression) error {
+	switch target := target.(type) {
+	case *ast.Identifier:
+		return c.compileVariableAddress(target)
+
+	case *ast.IndexExpression:
+		// A dereferenced pointer is its own container
+		if deref, ok := target.Left.(*ast.PointerDereferenceExpression); ok {
+			if err := c.compileExpression(deref.Right); err != nil {
+				return err
+			}
+		} else if err := c.compileAddress(target.Left); err != nil {
+			return err
+		}
+
+		if err := c.compileExpression(target.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpAddressIndex)
+
+		return nil
+	}
+
+	c.emitError(c.span, "Cannot take address of non-identifier expression")
+	return nil
+}
+
+func (c *Compiler) compileVariableAddress(identifier *ast.Identifier) error {
+	symbol, ok := c.symbolTable.Resolve(identifier.Value)
+	if !ok {
+		if _, isBuiltin := evaluator.LookupBuiltin(identifier.Value); isBuiltin {
+			c.emitError(c.span, "Cannot take address of undefined variable: %s", identifier.Value)
+			return nil
+		}
+		symbol = c.symbolTable.Global().Define(identifier.Value, false)
+	}
+
+	name := c.addConstant(&object.String{Value: identifier.Value})
+
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpAddress, code.ScopeGlobal, symbol.Index, name)
+	case LocalScope:
+		if !symbol.Boxed {
+			return fmt.Errorf("address of %s taken but it is not stored in a cell", symbol.Name)
+		}
+		c.emit(code.OpAddress, code.ScopeLocal, symbol.Index, name)
+	case FreeScope:
+		c.emit(code.OpAddress, code.ScopeFree, symbol.Index, name)
+	}
+
+	return nil
+}
+
+// loadIdentifier pushes the value bound to name. User bindings shadow
+// builtins; names that are not defined yet resolve to a global slot that is
+// checked at runtime, since the evaluator also looks names up lazily.
+func (c *Compiler) loadIdentifier(name string) {
+	if symbol, ok := c.symbolTable.Resolve(name); ok {
+		c.loadSymbol(symbol)
+		return
+	}
+
+	if builtin, ok := evaluator.LookupBuiltin(name); ok {
+		c.emit(code.OpConstant, c.addConstant(builtin))
+		return
+	}
+
+	c.loadSymbol(c.symbolTable.Global().Define(name, false))
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		if s.Boxed {
+			c.emit(code.OpGetCell, s.Index)
+		} else {
+			c.emit(code.OpGetLocal, s.Index)
+		}
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	}
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		if s.Boxed {
+			c.emit(code.OpSetCell, s.Index)
+		} else {
+			c.emit(code.OpSetLocal, s.Index)
+		}
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+func (c *Compiler) defineSymbol(s Symbol) {
+	mutable := 0
+	if s.Mutable {
+		mutable = 1
+	}
+
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpDefineGlobal, s.Index, mutable)
+	case LocalScope:
+		if s.Boxed {
+			c.emit(code.OpDefineCell, s.Index, mutable)
+		} else {
+			c.emit(code.OpSetLocal, s.Index)
+		}
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// emitError compiles a runtime error attributed to span. Mutability is
+// resolved statically, but violating it must still only fail when the
+// offending code runs.
+func (c *Compiler) emitError(span token.Span, format string, a ...interface{}) {
+	previous := c.span
+	c.span = span
+	defer func() { c.span = previous }()
+
+	message := &object.String{Value: fmt.Sprintf(format, a...)}
+	c.emit(code.OpError, c.addConstant(message))
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	scope := &c.scopes[c.scopeIndex]
+	posNewInstruction := len(scope.instructions)
+
+	scope.instructions = append(scope.instructions, ins...)
+	for range ins {
+		scope.spans = append(scope.spans, c.span)
+	}
+
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) removeLastPop() {
+	scope := &c.scopes[c.scopeIndex]
+	if scope.lastInstruction.Opcode != code.OpPop {
+		return
+	}
+
+	position := scope.lastInstruction.Position
+	scope.instructions = scope.instructions[:position]
+	scope.spans = scope.spans[:position]
+	scope.lastInstruction = scope.previousInstruction
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) enterScope(captured map[string]bool) {
+	c.scopes = append(c.scopes, CompilationScope{})
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable, captured)
+}
+
+// enterBlock opens the scope of a block or loop header. Before Version2
+// blocks share the scope around them.
+func (c *Compiler) enterBlock() {
+	if c.symbolTable.Version() >= object.Version2 {
+		c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	}
+}
+
+func (c *Compiler) leaveBlock() {
+	if c.symbolTable.block {
+		c.symbolTable = c.symbolTable.Outer
+	}
+}
+
+func (c *Compiler) leaveScope() (code.Instructions, []token.Span) {
+	scope := c.scopes[c.scopeIndex]
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return scope.instructions, scope.spans
+}
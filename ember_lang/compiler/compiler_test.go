@@ -0,0 +1,333 @@
+package compiler
+
+import (
+	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/code"
+	"ember_lang/ember_lang/lexer"
+	"ember_lang/ember_lang/object"
+	"ember_lang/ember_lang/parser"
+	"testing"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		expected := code.Instructions{}
+		for _, ins := range tt.expectedInstructions {
+			expected = append(expected, ins...)
+		}
+
+		if bytecode.Instructions.String() != expected.String() {
+			t.Errorf("wrong instructions for %q.\nwant=\n%s\ngot=\n%s", tt.input, expected, bytecode.Instructions)
+		}
+
+		if len(bytecode.Spans) != len(bytecode.Instructions) {
+			t.Errorf("span table has wrong length. want=%d, got=%d", len(bytecode.Instructions), len(bytecode.Spans))
+		}
+
+		testConstants(t, tt.input, tt.expectedConstants, bytecode.Constants)
+	}
+}
+
+func testConstants(t *testing.T, input string, expected []interface{}, actual []object.Object) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("wrong number of constants for %q. want=%d, got=%d", input, len(expected), len(actual))
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Value != int64(constant) {
+				t.Errorf("constant %d wrong. want=%d, got=%s", i, constant, actual[i].Inspect())
+			}
+		case float64:
+			float, ok := actual[i].(*object.Float)
+			if !ok || float.Value != constant {
+				t.Errorf("constant %d wrong. want=%g, got=%s", i, constant, actual[i].Inspect())
+			}
+		case string:
+			str, ok := actual[i].(*object.String)
+			if !ok || str.Value != constant {
+				t.Errorf("constant %d wrong. want=%q, got=%s", i, constant, actual[i].Inspect())
+			}
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				t.Errorf("constant %d is not a function. got=%T", i, actual[i])
+				continue
+			}
+
+			instructions := code.Instructions{}
+			for _, ins := range constant {
+				instructions = append(instructions, ins...)
+			}
+			if fn.Instructions.String() != instructions.String() {
+				t.Errorf("constant %d has wrong instructions.\nwant=\n%s\ngot=\n%s", i, instructions, fn.Instructions)
+			}
+		}
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "1; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "1 * 2.5",
+			expectedConstants: []interface{}{1, 2.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "-1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let one = 1; let mut two = 2; one;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefineGlobal, 0, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDefineGlobal, 1, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []interface{}{1, "Cannot assign to immutable variable: x"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefineGlobal, 0, 0),
+				code.Make(code.OpError, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a) { fn(b) { a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(f) { f(1); f(2) }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestResolveFreeAndBoxed(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a", false)
+
+	outer := NewEnclosedSymbolTable(global, map[string]bool{"c": true})
+	c := outer.Define("c", true)
+	d := outer.Define("d", false)
+
+	if !c.Boxed || d.Boxed {
+		t.Errorf("wrong boxing. c=%v, d=%v", c.Boxed, d.Boxed)
+	}
+
+	inner := NewEnclosedSymbolTable(outer, nil)
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "c", Scope: FreeScope, Index: 0, Mutable: true},
+		{Name: "d", Scope: FreeScope, Index: 1},
+	}
+
+	for _, sym := range expected {
+		result, ok := inner.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	if len(inner.FreeSymbols) != 2 {
+		t.Errorf("wrong number of free symbols. got=%d", len(inner.FreeSymbols))
+	}
+
+	if _, ok := inner.Resolve("e"); ok {
+		t.Errorf("name e resolved, but was never defined")
+	}
+}
+
+func TestRedefineReusesSlot(t *testing.T) {
+	global := NewSymbolTable()
+	first := global.Define("x", false)
+	global.Define("y", false)
+	second := global.Define("x", true)
+
+	if first.Index != second.Index || !second.Mutable {
+		t.Errorf("redefinition got a new slot. first=%+v, second=%+v", first, second)
+	}
+}
+
+func TestBlockScopesShareFrameSlots(t *testing.T) {
+	global := NewSymbolTable()
+	fn := NewEnclosedSymbolTable(global, map[string]bool{"y": true})
+	x := fn.Define("x", false)
+
+	block := NewBlockSymbolTable(fn)
+	shadow := block.Define("x", true)
+	y := block.Define("y", false)
+
+	if shadow.Scope != LocalScope || shadow.Index != x.Index+1 {
+		t.Errorf("block variable did not get a new slot in the frame. got=%+v", shadow)
+	}
+	if !y.Boxed {
+		t.Errorf("captured block variable is not boxed. got=%+v", y)
+	}
+
+	if result, _ := block.Resolve("x"); result != shadow {
+		t.Errorf("block variable does not shadow outer one. got=%+v", result)
+	}
+	if result, _ := fn.Resolve("x"); result != x {
+		t.Errorf("block variable leaked into the function scope. got=%+v", result)
+	}
+	if _, ok := fn.Resolve("y"); ok {
+		t.Errorf("name y resolved outside its block")
+	}
+
+	if fn.numDefinitions != 3 || len(fn.Names()) != 3 {
+		t.Errorf("wrong number of locals. got=%d (%v)", fn.numDefinitions, fn.Names())
+	}
+	if cells := fn.Cells(); len(cells) != 1 || cells[0] != y.Index {
+		t.Errorf("wrong cells. got=%v", cells)
+	}
+}
+
+func TestCapturedGlobalBlockVariables(t *testing.T) {
+	global := NewSymbolTable()
+	global.captured = map[string]bool{"a": true, "b": true}
+	a := global.Define("a", false)
+
+	block := NewBlockSymbolTable(global)
+	b := block.Define("b", false)
+	c := block.Define("c", false)
+
+	if a.Boxed || !b.Boxed || c.Boxed || b.Scope != GlobalScope {
+		t.Errorf("wrong boxing. a=%+v, b=%+v, c=%+v", a, b, c)
+	}
+
+	fn := NewEnclosedSymbolTable(block, nil)
+	if result, _ := fn.Resolve("a"); result != a {
+		t.Errorf("global a is not read from its slot. got=%+v", result)
+	}
+	if result, _ := fn.Resolve("b"); result.Scope != FreeScope {
+		t.Errorf("block variable b is not captured. got=%+v", result)
+	}
+	if len(fn.FreeSymbols) != 1 || fn.FreeSymbols[0] != b {
+		t.Errorf("wrong free symbols. got=%+v", fn.FreeSymbols)
+	}
+	if cells := global.Cells(); len(cells) != 0 {
+		t.Errorf("globals were given local cells. got=%v", cells)
+	}
+}
@@ -0,0 +1,164 @@
+package compiler
+
+import "ember_lang/ember_lang/object"
+
+type SymbolScope string
+
+const (
+	GlobalScope SymbolScope = "GLOBAL"
+	LocalScope  SymbolScope = "LOCAL"
+	FreeScope   SymbolScope = "FREE"
+)
+
+type Symbol struct {
+	Name    string
+	Scope   SymbolScope
+	Index   int
+	Mutable bool
+	Boxed   bool // Block variable or local stored in a cell because a closure or pointer refers to it
+}
+
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+	names          []string
+
+	// Symbols from enclosing scopes referenced by this function, in the
+	// order their free slots were allocated.
+	FreeSymbols []Symbol
+
+	// Names that must be boxed when defined as locals in this scope.
+	captured map[string]bool
+
+	// Local slots holding a cell, in the order they were defined.
+	cells []int
+
+	// A block scope allocates its slots from the enclosing function or
+	// global scope, so its variables live in the same frame.
+	block bool
+
+	version object.LanguageVersion
+}
+
+func NewSymbolTable() *SymbolTable {
+	return NewVersionedSymbolTable(object.LatestVersion)
+}
+
+// NewVersionedSymbolTable creates a global symbol table that compiles
+// programs with the semantics of the given language version.
+func NewVersionedSymbolTable(version object.LanguageVersion) *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol), version: version}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable, captured map[string]bool) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	s.captured = captured
+	s.version = outer.version
+	return s
+}
+
+// NewBlockSymbolTable creates the scope of a block or loop header inside
+// outer. Names defined in it shadow outer ones until the block ends.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer, outer.captured)
+	s.block = true
+	return s
+}
+
+// Version returns the language version being compiled.
+func (s *SymbolTable) Version() object.LanguageVersion {
+	return s.version
+}
+
+// Define binds name in this scope. Redefining a name that already lives in
+// this scope reuses its slot, mirroring how the evaluator overwrites a
+// binding in the same environment.
+func (s *SymbolTable) Define(name string, mutable bool) Symbol {
+	frame := s.frame()
+
+	scope := GlobalScope
+	if frame.Outer != nil {
+		scope = LocalScope
+	}
+
+	if existing, ok := s.store[name]; ok && existing.Scope == scope {
+		existing.Mutable = mutable
+		s.store[name] = existing
+		return existing
+	}
+
+	symbol := Symbol{
+		Name:    name,
+		Scope:   scope,
+		Index:   frame.numDefinitions,
+		Mutable: mutable,
+		Boxed:   (scope == LocalScope || s.block) && s.captured[name],
+	}
+	s.store[name] = symbol
+	frame.names = append(frame.names, name)
+	frame.numDefinitions++
+	if symbol.Boxed && scope == LocalScope {
+		frame.cells = append(frame.cells, symbol.Index)
+	}
+
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	// Globals are read from their slot when used, except those declared in
+	// a block: a closure keeps the cell they had when it was made.
+	symbol, ok = s.Outer.Resolve(name)
+	if !ok || (symbol.Scope == GlobalScope && !symbol.Boxed) || s.block {
+		return symbol, ok
+	}
+
+	return s.defineFree(symbol), true
+}
+
+// Global returns the outermost symbol table.
+func (s *SymbolTable) Global() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
+
+// Names returns the defined names indexed by slot.
+func (s *SymbolTable) Names() []string {
+	return s.frame().names
+}
+
+// Cells returns the local slots that hold a cell.
+func (s *SymbolTable) Cells() []int {
+	return s.frame().cells
+}
+
+// frame returns the function or global scope whose slots this scope uses.
+func (s *SymbolTable) frame() *SymbolTable {
+	for s.block {
+		s = s.Outer
+	}
+	return s
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{
+		Name:    original.Name,
+		Scope:   FreeScope,
+		Index:   len(s.FreeSymbols) - 1,
+		Mutable: original.Mutable,
+	}
+	s.store[original.Name] = symbol
+
+	return symbol
+}
@@ -0,0 +1,295 @@
+package diagnostic
+
+import (
+	"ember_lang/ember_lang/token"
+	"fmt"
+	"strings"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// Diagnostic codes. Lexer codes start at E0001, parser codes at E0100 and
+// runtime codes at E0200.
+const (
+	IllegalCharacter   = "E0001"
+	UnterminatedString = "E0002"
+	InvalidNumber      = "E0003"
+	InvalidEscape      = "E0004"
+
+	UnexpectedToken   = "E0100"
+	ExpectedToken     = "E0101"
+	InvalidInteger    = "E0102"
+	InvalidAssignment = "E0103"
+	OutsideLoop       = "E0104"
+	UndefinedLabel    = "E0105"
+	InvalidFloat      = "E0106"
+
+	RuntimeError   = "E0200"
+	InternalError  = "E0201"
+	Cancelled      = "E0202"
+	BudgetExceeded = "E0203"
+)
+
+// Diagnostic is a problem found in a program, together with the span of
+// source it refers to and any notes or hints that help fix it.
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Message  string
+	Span     token.Span
+	Notes    []string
+	Hint     string
+	Trace    []Frame // Calls a runtime error passed through, innermost first
+	Omitted  int     // Calls left out of the middle of Trace
+}
+
+// Frame is a function call in the stack trace of a runtime error.
+type Frame struct {
+	Function  string     // Name of the function called
+	Arguments string     // Summary of the arguments it was called with
+	Call      token.Span // Where it was called from; invalid if not known
+}
+
+func (f Frame) String() string {
+	call := f.Function + "(" + f.Arguments + ")"
+	if !f.Call.IsValid() {
+		return call
+	}
+	return call + " called at " + f.Call.Start.String()
+}
+
+func New(code string, span token.Span, format string, a ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Span:     span,
+	}
+}
+
+// WithNote appends a note and returns d, so notes can be added inline.
+func (d *Diagnostic) WithNote(format string, a ...interface{}) *Diagnostic {
+	d.Notes = append(d.Notes, fmt.Sprintf(format, a...))
+	return d
+}
+
+// WithHint sets the hint and returns d.
+func (d *Diagnostic) WithHint(format string, a ...interface{}) *Diagnostic {
+	d.Hint = fmt.Sprintf(format, a...)
+	return d
+}
+
+// WithTrace sets the stack trace, which leaves out omitted calls between
+// its two halves, and returns d.
+func (d *Diagnostic) WithTrace(trace []Frame, omitted int) *Diagnostic {
+	d.Trace = trace
+	d.Omitted = omitted
+	return d
+}
+
+// Error formats the diagnostic on a single line, e.g. "3:5: message".
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s", d.Span.Start, d.Message)
+}
+
+// ------------------------------------- Rendering -------------------------------------
+
+const (
+	bold   = "\033[1m"
+	red    = "\033[1;31m"
+	yellow = "\033[1;33m"
+	blue   = "\033[1;34m"
+	cyan   = "\033[1;36m"
+	reset  = "\033[0m"
+)
+
+// Renderer prints diagnostics against the source they were found in.
+type Renderer struct {
+	Filename string // Shown in the location line; may be empty
+	Source   string
+	Color    bool // Emit ANSI colors
+}
+
+// Render formats d in the style of rustc:
+//
+//	error[E0101]: expected next token to be: RPAREN, got: SEMICOLON (;) instead.
+//	 --> main.em:1:14
+//	  |
+//	1 | let x = (1 + 2;
+//	  |              ^
+//	  = help: insert ")"
+//
+// A stack trace follows the source line, one call per line.
+func (r *Renderer) Render(d *Diagnostic) string {
+	var out strings.Builder
+
+	severity := d.Severity.String()
+	if d.Code != "" {
+		severity += "[" + d.Code + "]"
+	}
+	out.WriteString(r.paint(severityColor(d.Severity), severity))
+	out.WriteString(r.paint(bold, ": "+d.Message))
+	out.WriteString("\n")
+
+	if !d.Span.IsValid() {
+		r.writeTrailers(&out, d, "")
+		return out.String()
+	}
+
+	line := d.Span.Start.Line
+	gutter := strings.Repeat(" ", len(fmt.Sprint(line)))
+
+	out.WriteString(gutter + r.paint(blue, "--> ") + r.location(d.Span.Start) + "\n")
+
+	source, ok := sourceLine(r.Source, line)
+	if !ok {
+		r.writeTrailers(&out, d, gutter)
+		return out.String()
+	}
+
+	out.WriteString(gutter + r.paint(blue, " |") + "\n")
+	out.WriteString(r.paint(blue, fmt.Sprintf("%d |", line)) + " " + source + "\n")
+	out.WriteString(gutter + r.paint(blue, " |") + " " + r.underline(source, d) + "\n")
+
+	r.writeTrailers(&out, d, gutter)
+
+	return out.String()
+}
+
+// RenderAll renders every diagnostic, separated by blank lines.
+func (r *Renderer) RenderAll(diagnostics []*Diagnostic) string {
+	rendered := make([]string, len(diagnostics))
+	for i, d := range diagnostics {
+		rendered[i] = r.Render(d)
+	}
+	return strings.Join(rendered, "\n")
+}
+
+// Traces longer than maxTraceFrames show their innermost and outermost calls
+// only, as deep recursion would otherwise bury the message.
+const maxTraceFrames = 20
+
+func (r *Renderer) writeTrailers(out *strings.Builder, d *Diagnostic, gutter string) {
+	if len(d.Trace) > 0 {
+		out.WriteString(gutter + r.paint(blue, " = ") + r.paint(bold, "trace") + ":\n")
+	}
+
+	// The calls from skipFrom up to skipTo are not shown, besides any the
+	// trace already left out there
+	skipFrom, skipTo := len(d.Trace)/2, len(d.Trace)/2
+	if len(d.Trace) > maxTraceFrames {
+		skipFrom, skipTo = maxTraceFrames/2, len(d.Trace)-maxTraceFrames/2
+	}
+
+	for i, frame := range d.Trace {
+		if i == skipFrom && (skipTo > skipFrom || d.Omitted > 0) {
+			omitted := skipTo - skipFrom + d.Omitted
+			out.WriteString(gutter + "     " + r.paint(blue, fmt.Sprintf("... %d more calls ...", omitted)) + "\n")
+		}
+		if i >= skipFrom && i < skipTo {
+			continue
+		}
+		out.WriteString(gutter + "     at " + r.paint(cyan, frame.Function) + "(" + frame.Arguments + ")")
+		if frame.Call.IsValid() {
+			out.WriteString(" called at " + r.location(frame.Call.Start))
+		}
+		out.WriteString("\n")
+	}
+
+	for _, note := range d.Notes {
+		out.WriteString(gutter + r.paint(blue, " = ") + r.paint(bold, "note") + ": " + note + "\n")
+	}
+	if d.Hint != "" {
+		out.WriteString(gutter + r.paint(blue, " = ") + r.paint(bold, "help") + ": " + d.Hint + "\n")
+	}
+}
+
+// location formats a position, with the file name when there is one.
+func (r *Renderer) location(position token.Position) string {
+	if r.Filename != "" {
+		return r.Filename + ":" + position.String()
+	}
+	return position.String()
+}
+
+func (r *Renderer) paint(color string, text string) string {
+	if !r.Color {
+		return text
+	}
+	return color + text + reset
+}
+
+func severityColor(severity Severity) string {
+	switch severity {
+	case Error:
+		return red
+	case Warning:
+		return yellow
+	default:
+		return cyan
+	}
+}
+
+// underline returns the marker line placed under source. Spans that continue
+// past the line are underlined to its end. Columns count characters, not
+// bytes.
+func (r *Renderer) underline(line string, d *Diagnostic) string {
+	span := d.Span
+	source := []rune(line)
+
+	start := span.Start.Column - 1
+	if start > len(source) {
+		start = len(source)
+	}
+
+	end := len(source)
+	if span.End.Line == span.Start.Line {
+		end = span.End.Column - 1
+	}
+	if end > len(source) {
+		end = len(source)
+	}
+
+	width := end - start
+	if width < 1 {
+		width = 1
+	}
+
+	// Keep tabs so the markers line up with the source above
+	var padding strings.Builder
+	for _, ch := range source[:start] {
+		if ch == '\t' {
+			padding.WriteByte('\t')
+		} else {
+			padding.WriteByte(' ')
+		}
+	}
+
+	return padding.String() + r.paint(severityColor(d.Severity), "^"+strings.Repeat("~", width-1))
+}
+
+// sourceLine returns the 1-based line of source without its line break.
+func sourceLine(source string, line int) (string, bool) {
+	lines := strings.Split(source, "\n")
+	if line < 1 || line > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[line-1], "\r"), true
+}
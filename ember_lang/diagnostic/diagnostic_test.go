@@ -0,0 +1,159 @@
+package diagnostic
+
+import (
+	"ember_lang/ember_lang/token"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func span(line, startColumn, endColumn int) token.Span {
+	return token.Span{
+		Start: token.Position{Line: line, Column: startColumn},
+		End:   token.Position{Line: line, Column: endColumn},
+	}
+}
+
+func TestRender(t *testing.T) {
+	source := "let x = 5;\nlet y = (x + 1;\n"
+
+	tests := []struct {
+		diagnostic *Diagnostic
+		expected   string
+	}{
+		{
+			New(ExpectedToken, span(2, 15, 16), "expected next token to be: RPAREN, got: SEMICOLON (;) instead.").
+				WithHint("insert %q here", ")"),
+			"error[E0101]: expected next token to be: RPAREN, got: SEMICOLON (;) instead.\n" +
+				" --> main.em:2:15\n" +
+				"  |\n" +
+				"2 | let y = (x + 1;\n" +
+				"  |               ^\n" +
+				"  = help: insert \")\" here\n",
+		},
+		{
+			New(RuntimeError, span(1, 5, 10), "Cannot assign to immutable variable: x").
+				WithNote("x was declared here"),
+			"error[E0200]: Cannot assign to immutable variable: x\n" +
+				" --> main.em:1:5\n" +
+				"  |\n" +
+				"1 | let x = 5;\n" +
+				"  |     ^~~~~\n" +
+				"  = note: x was declared here\n",
+		},
+		{
+			New(RuntimeError, token.Span{}, "stack overflow"),
+			"error[E0200]: stack overflow\n",
+		},
+	}
+
+	renderer := &Renderer{Filename: "main.em", Source: source}
+
+	for _, tt := range tests {
+		rendered := renderer.Render(tt.diagnostic)
+		if rendered != tt.expected {
+			t.Errorf("wrong rendering.\nwant=\n%s\ngot=\n%s", tt.expected, rendered)
+		}
+	}
+}
+
+func TestRenderKeepsTabs(t *testing.T) {
+	renderer := &Renderer{Source: "\t\tfoo"}
+
+	rendered := renderer.Render(New(RuntimeError, span(1, 3, 6), "Identifier not found: foo"))
+	expected := "error[E0200]: Identifier not found: foo\n" +
+		" --> 1:3\n" +
+		"  |\n" +
+		"1 | \t\tfoo\n" +
+		"  | \t\t^~~\n"
+
+	if rendered != expected {
+		t.Errorf("wrong rendering.\nwant=%q\ngot=%q", expected, rendered)
+	}
+}
+
+func TestRenderCountsCharacters(t *testing.T) {
+	renderer := &Renderer{Source: `let größe = "名前" + x;`}
+
+	rendered := renderer.Render(New(RuntimeError, span(1, 20, 21), "Identifier not found: x"))
+	expected := "error[E0200]: Identifier not found: x\n" +
+		" --> 1:20\n" +
+		"  |\n" +
+		"1 | let größe = \"名前\" + x;\n" +
+		"  |                    ^\n"
+
+	if rendered != expected {
+		t.Errorf("wrong rendering.\nwant=%q\ngot=%q", expected, rendered)
+	}
+}
+
+func TestRenderMultilineSpan(t *testing.T) {
+	renderer := &Renderer{Source: "if (x) {\n  1\n}"}
+
+	d := New(RuntimeError, token.Span{
+		Start: token.Position{Line: 1, Column: 1},
+		End:   token.Position{Line: 3, Column: 2},
+	}, "boom")
+
+	expected := "error[E0200]: boom\n" +
+		" --> 1:1\n" +
+		"  |\n" +
+		"1 | if (x) {\n" +
+		"  | ^~~~~~~~\n"
+
+	if rendered := renderer.Render(d); rendered != expected {
+		t.Errorf("wrong rendering.\nwant=%q\ngot=%q", expected, rendered)
+	}
+}
+
+func TestRenderTrace(t *testing.T) {
+	renderer := &Renderer{Filename: "main.em", Source: "let f = fn(a) { a / 0 };\nf(1)"}
+
+	d := New(RuntimeError, span(1, 17, 22), "Division by zero: 1 / 0").
+		WithTrace([]Frame{
+			{Function: "f", Arguments: "1", Call: span(2, 1, 5)},
+			{Function: "<anonymous>", Arguments: `"a", [1, 2]`},
+		}, 0)
+
+	expected := "error[E0200]: Division by zero: 1 / 0\n" +
+		" --> main.em:1:17\n" +
+		"  |\n" +
+		"1 | let f = fn(a) { a / 0 };\n" +
+		"  |                 ^~~~~\n" +
+		"  = trace:\n" +
+		"      at f(1) called at main.em:2:1\n" +
+		"      at <anonymous>(\"a\", [1, 2])\n"
+
+	if rendered := renderer.Render(d); rendered != expected {
+		t.Errorf("wrong rendering.\nwant=%q\ngot=%q", expected, rendered)
+	}
+}
+
+func TestRenderLongTrace(t *testing.T) {
+	renderer := &Renderer{}
+
+	trace := make([]Frame, 100)
+	for i := range trace {
+		trace[i] = Frame{Function: "f", Arguments: strconv.Itoa(i)}
+	}
+	rendered := renderer.Render(New(RuntimeError, token.Span{}, "stack overflow").WithTrace(trace, 0))
+
+	lines := strings.Split(strings.TrimSuffix(rendered, "\n"), "\n")
+	if len(lines) != 2+maxTraceFrames+1 {
+		t.Fatalf("wrong number of lines. want=%d, got=%d:\n%s", 2+maxTraceFrames+1, len(lines), rendered)
+	}
+
+	if lines[2] != "     at f(0)" || lines[len(lines)-1] != "     at f(99)" {
+		t.Errorf("trace does not keep its innermost and outermost calls:\n%s", rendered)
+	}
+	if !strings.Contains(rendered, "... 80 more calls ...") {
+		t.Errorf("trace does not say how many calls were left out:\n%s", rendered)
+	}
+
+	// A trace that already left calls out shows them in its middle
+	rendered = renderer.Render(New(RuntimeError, token.Span{}, "stack overflow").WithTrace(trace[:4], 96))
+	expected := "     at f(0)\n     at f(1)\n     ... 96 more calls ...\n     at f(2)\n     at f(3)\n"
+	if !strings.HasSuffix(rendered, expected) {
+		t.Errorf("wrong rendering of omitted calls.\nwant suffix=%q\ngot=%q", expected, rendered)
+	}
+}
@@ -2,8 +2,10 @@ package evaluator
 
 import (
 	"ember_lang/ember_lang/object"
+	"ember_lang/ember_lang/token"
 	"fmt"
 	"math/rand"
+	"unicode/utf8"
 )
 
 var builtins map[string]*object.Builtin
@@ -13,7 +15,7 @@ func init() {
 		"print": {
 			Fn: func(args ...object.Object) object.Object {
 				for _, arg := range args {
-					fmt.Println(arg.Inspect())
+					fmt.Println(object.Display(arg))
 				}
 				return NULL
 			},
@@ -26,7 +28,7 @@ func init() {
 
 				switch arg := args[0].(type) {
 				case *object.String:
-					return &object.Integer{Value: int64(len(arg.Value))}
+					return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 				case *object.Array:
 					return &object.Integer{Value: int64(len(arg.Elements))}
 				default:
@@ -34,6 +36,24 @@ func init() {
 				}
 			},
 		},
+		"bytes": {
+			Fn: func(args ...object.Object) object.Object {
+				if len(args) != 1 {
+					return newError("Invalid number of arguments. Got: %d, Expected: 1", len(args))
+				}
+
+				str, ok := args[0].(*object.String)
+				if !ok {
+					return newError("Invalid argument to bytes. Got: %s, Expected: STRING", args[0].Type())
+				}
+
+				elements := make([]object.Object, len(str.Value))
+				for i := 0; i < len(str.Value); i++ {
+					elements[i] = &object.Integer{Value: int64(str.Value[i])}
+				}
+				return &object.Array{Elements: elements}
+			},
+		},
 		"push": {
 			Fn: func(args ...object.Object) object.Object {
 				if len(args) != 2 {
@@ -49,6 +69,31 @@ func init() {
 				return newArray
 			},
 		},
+		"delete": {
+			Fn: func(args ...object.Object) object.Object {
+				if len(args) != 2 {
+					return newError("Invalid number of arguments. Got: %d, Expected: 2", len(args))
+				}
+
+				hash, ok := args[0].(*object.Hash)
+				if !ok {
+					return newError("Invalid argument to delete. Got: %s, Expected: HASH", args[0].Type())
+				}
+
+				key, ok := args[1].(object.Hashable)
+				if !ok {
+					return newError("Unusable as hash key: %s", args[1].Type())
+				}
+
+				pairs := make(map[object.HashKey]object.HashPair, len(hash.Pairs))
+				for hashKey, pair := range hash.Pairs {
+					if hashKey != key.HashKey() {
+						pairs[hashKey] = pair
+					}
+				}
+				return &object.Hash{Pairs: pairs}
+			},
+		},
 		"concat": {
 			Fn: func(args ...object.Object) object.Object {
 				if len(args) != 2 {
@@ -86,6 +131,8 @@ func init() {
 					if !ok {
 						return newError("Invalid argument to map. Got: %s, Expected: FUNCTION", args[1].Type())
 					}
+				} else if callable, isCallable := args[1].(object.Callable); isCallable {
+					function = callable
 				} else {
 					function, ok = args[1].(*object.Function)
 					if !ok {
@@ -96,7 +143,7 @@ func init() {
 				newArray := &object.Array{Elements: make([]object.Object, 0, len(array.Elements))}
 
 				for _, elem := range array.Elements {
-					result := applyFunction(function, []object.Object{elem})
+					result := applyFunction(function, []object.Object{elem}, token.Span{})
 					if isError(result) {
 						return result
 					}
@@ -124,6 +171,8 @@ func init() {
 					if !ok {
 						return newError("Invalid argument to reduce. Got: %s, Expected: FUNCTION", args[1].Type())
 					}
+				} else if callable, isCallable := args[1].(object.Callable); isCallable {
+					function = callable
 				} else {
 					function, ok = args[1].(*object.Function)
 					if !ok {
@@ -131,107 +180,34 @@ func init() {
 					}
 				}
 
-				initialValue, ok := args[2].(*object.Integer)
-				if !ok {
-					return newError("Invalid argument to reduce. Got: %s, Expected: INTEGER", args[1].Type())
+				accumulator := args[2]
+				if !isNumber(accumulator) {
+					return newError("Invalid argument to reduce. Got: %s, Expected: INTEGER or FLOAT", accumulator.Type())
 				}
 
 				for index, elem := range array.Elements {
-					if elem.Type() != object.INTEGER_OBJ {
-						return newError("Invalid argument to reduce at index %d. Got: %s, Expected: INTEGER", index, elem.Type())
+					if !isNumber(elem) {
+						return newError("Invalid argument to reduce at index %d. Got: %s, Expected: INTEGER or FLOAT", index, elem.Type())
 					}
 
-					result := applyFunction(function, []object.Object{initialValue, elem})
+					result := applyFunction(function, []object.Object{accumulator, elem}, token.Span{})
 					if isError(result) {
 						return result
 					}
 
-					resultInt, ok := result.(*object.Integer)
-					if !ok {
-						return newError("Reduce function must return INTEGER, got: %s", result.Type())
+					if !isNumber(result) {
+						return newError("Reduce function must return INTEGER or FLOAT, got: %s", result.Type())
 					}
-					initialValue = resultInt
-				}
-
-				return initialValue
-			},
-		},
-		"add": {
-			Fn: func(args ...object.Object) object.Object {
-				if len(args) != 2 {
-					return newError("Invalid number of arguments. Got: %d, Expected: 2", len(args))
-				}
-
-				left, ok := args[0].(*object.Integer)
-				if !ok {
-					return newError("Invalid argument to add. Got: %s, Expected: INTEGER", args[0].Type())
-				}
-
-				right, ok := args[1].(*object.Integer)
-				if !ok {
-					return newError("Invalid argument to add. Got: %s, Expected: INTEGER", args[1].Type())
-				}
-
-				return &object.Integer{Value: left.Value + right.Value}
-			},
-		},
-		"sub": {
-			Fn: func(args ...object.Object) object.Object {
-				if len(args) != 2 {
-					return newError("Invalid number of arguments. Got: %d, Expected: 2", len(args))
-				}
-
-				left, ok := args[0].(*object.Integer)
-				if !ok {
-					return newError("Invalid argument to sub. Got: %s, Expected: INTEGER", args[0].Type())
-				}
-
-				right, ok := args[1].(*object.Integer)
-				if !ok {
-					return newError("Invalid argument to sub. Got: %s, Expected: INTEGER", args[1].Type())
-				}
-
-				return &object.Integer{Value: left.Value - right.Value}
-			},
-		},
-		"mul": {
-			Fn: func(args ...object.Object) object.Object {
-				if len(args) != 2 {
-					return newError("Invalid number of arguments. Got: %d, Expected: 2", len(args))
-				}
-
-				left, ok := args[0].(*object.Integer)
-				if !ok {
-					return newError("Invalid argument to mul. Got: %s, Expected: INTEGER", args[0].Type())
-				}
-
-				right, ok := args[1].(*object.Integer)
-				if !ok {
-					return newError("Invalid argument to mul. Got: %s, Expected: INTEGER", args[1].Type())
-				}
-
-				return &object.Integer{Value: left.Value * right.Value}
-			},
-		},
-		"div": {
-			Fn: func(args ...object.Object) object.Object {
-				if len(args) != 2 {
-					return newError("Invalid number of arguments. Got: %d, Expected: 2", len(args))
-				}
-
-				left, ok := args[0].(*object.Integer)
-				if !ok {
-					return newError("Invalid argument to div. Got: %s, Expected: INTEGER", args[0].Type())
-				}
-
-				right, ok := args[1].(*object.Integer)
-				if !ok {
-					return newError("Invalid argument to div. Got: %s, Expected: INTEGER", args[1].Type())
+					accumulator = result
 				}
 
-				return &object.Integer{Value: left.Value / right.Value}
+				return accumulator
 			},
 		},
+		"add": arithmeticBuiltin("add", "+"),
+		"sub": arithmeticBuiltin("sub", "-"),
+		"mul": arithmeticBuiltin("mul", "*"),
+		"div": arithmeticBuiltin("div", "/"),
 		"type": {
 			Fn: func(args ...object.Object) object.Object {
 				if len(args) != 1 {
@@ -239,8 +215,10 @@ func init() {
 				}
 
 				switch args[0].(type) {
-				case *object.Integer:
+				case *object.Integer, *object.BigInt:
 					return &object.String{Value: "INTEGER"}
+				case *object.Float:
+					return &object.String{Value: "FLOAT"}
 				case *object.String:
 					return &object.String{Value: "STRING"}
 				case *object.Boolean:
@@ -249,13 +227,41 @@ func init() {
 					return &object.String{Value: "ARRAY"}
 				case *object.Hash:
 					return &object.String{Value: "HASH"}
-				case *object.Function:
+				case *object.Range:
+					return &object.String{Value: "RANGE"}
+				case *object.Function, object.Callable:
 					return &object.String{Value: "FUNCTION"}
+				case *object.ErrorValue:
+					return &object.String{Value: "ERROR"}
 				default:
 					return newError("Invalid argument to type. Got: %s", args[0].Type())
 				}
 			},
 		},
+		"error": {
+			Fn: func(args ...object.Object) object.Object {
+				if len(args) != 1 {
+					return newError("Invalid number of arguments. Got: %d, Expected: 1", len(args))
+				}
+
+				message, ok := args[0].(*object.String)
+				if !ok {
+					return newError("Invalid argument to error. Got: %s, Expected: STRING", args[0].Type())
+				}
+
+				return &object.ErrorValue{Message: message.Value}
+			},
+		},
+		"is_error": {
+			Fn: func(args ...object.Object) object.Object {
+				if len(args) != 1 {
+					return newError("Invalid number of arguments. Got: %d, Expected: 1", len(args))
+				}
+
+				_, ok := args[0].(*object.ErrorValue)
+				return nativeBoolToBooleanObject(ok)
+			},
+		},
 		"rand": {
 			Fn: func(args ...object.Object) object.Object {
 				if len(args) > 1 {
@@ -282,3 +288,29 @@ func init() {
 		},
 	}
 }
+
+// arithmeticBuiltin returns the builtin form of an arithmetic operator, such
+// as add for +. It takes integers and floats and follows the operator's
+// promotion rules.
+func arithmeticBuiltin(name string, operator string) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("Invalid number of arguments. Got: %d, Expected: 2", len(args))
+			}
+
+			for _, arg := range args {
+				if !isNumber(arg) {
+					return newError("Invalid argument to %s. Got: %s, Expected: INTEGER or FLOAT", name, arg.Type())
+				}
+			}
+
+			return evalInfixExpression(operator, args[0], args[1])
+		},
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	_, ok := toFloat(obj)
+	return ok
+}
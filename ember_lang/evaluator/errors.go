@@ -0,0 +1,103 @@
+package evaluator
+
+import (
+	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/object"
+	"fmt"
+)
+
+func evalThrowStatement(node *ast.ThrowStatement, env *object.Environment) object.Object {
+	value := Eval(node.Value, env)
+	if isError(value) {
+		return value
+	}
+	return throw(value)
+}
+
+// throw returns the error that unwinds the program when value is thrown. A
+// thrown error value keeps its message and the place and calls it was first
+// thrown from; any other value becomes the message of a new error.
+func throw(value object.Object) *object.Error {
+	if value, ok := value.(*object.ErrorValue); ok {
+		return &object.Error{Message: value.Message, Span: value.Span, Stack: value.Stack}
+	}
+	return &object.Error{Message: object.Display(value)}
+}
+
+// catch returns the error value a catch clause receives for err.
+func catch(err *object.Error) *object.ErrorValue {
+	return &object.ErrorValue{Message: err.Message, Span: err.Span, Stack: err.Stack}
+}
+
+// catchable reports whether a try expression may intercept err. Errors in
+// the interpreter itself are not the program's to handle, and neither is
+// being stopped from outside.
+func catchable(err *object.Error) bool {
+	return !err.Internal && err.Halt == object.NotHalted
+}
+
+// evalTryExpression runs the body of a try, handing an error it raises to the
+// catch clause, and then runs the finally clause whatever happened. A finally
+// clause that fails, returns, breaks or continues overrides the outcome of
+// the body and catch clause; otherwise its value is discarded.
+func evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(node.Body, env)
+
+	if err, ok := result.(*object.Error); ok {
+		if !catchable(err) {
+			return err
+		}
+
+		if node.Catch != nil {
+			scope := blockScope(env)
+			if node.Parameter != nil {
+				scope.Set(node.Parameter.Value, catch(err), false)
+			}
+			result = Eval(node.Catch, scope)
+		}
+	}
+
+	if node.Finally != nil {
+		finally := Eval(node.Finally, env)
+		switch finally.(type) {
+		case *object.Error, *object.ReturnValue, *object.Break, *object.Continue:
+			return finally
+		}
+	}
+
+	return result
+}
+
+// evalErrorValueIndexExpression returns the field of an error value named by
+// index: its message, the line it was thrown from, or its stack, which lists
+// where it was thrown and then the calls it was thrown in, with the middle of
+// a deep stack left out.
+func evalErrorValueIndexExpression(value object.Object, index object.Object) object.Object {
+	errorValue := value.(*object.ErrorValue)
+
+	switch index.(*object.String).Value {
+	case "message":
+		return &object.String{Value: errorValue.Message}
+	case "line":
+		if !errorValue.Span.IsValid() {
+			return NULL
+		}
+		return &object.Integer{Value: int64(errorValue.Span.Start.Line)}
+	case "stack":
+		elements := []object.Object{}
+		if errorValue.Span.IsValid() {
+			elements = append(elements, &object.String{Value: "at " + errorValue.Span.Start.String()})
+		}
+		frames := errorValue.Stack.Frames()
+		for i, frame := range frames {
+			if i == len(frames)/2 && errorValue.Stack.Omitted() > 0 {
+				omitted := fmt.Sprintf("... %d more calls ...", errorValue.Stack.Omitted())
+				elements = append(elements, &object.String{Value: omitted})
+			}
+			elements = append(elements, &object.String{Value: frame.String()})
+		}
+		return &object.Array{Elements: elements}
+	default:
+		return NULL
+	}
+}
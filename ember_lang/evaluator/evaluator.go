@@ -1,9 +1,16 @@
 package evaluator
 
 import (
+	"cmp"
+	"context"
 	"ember_lang/ember_lang/ast"
 	"ember_lang/ember_lang/object"
+	"ember_lang/ember_lang/token"
 	"fmt"
+	"math"
+	"math/big"
+	"runtime/debug"
+	"strings"
 )
 
 var (
@@ -12,7 +19,42 @@ var (
 	FALSE = &object.Boolean{Value: false}
 )
 
+// Eval evaluates node in env. Errors that do not know where they happened yet
+// are attributed to node, so every error carries the span of the innermost
+// expression that produced it.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if err := env.Budget().Step(); err != nil {
+		err.Span = node.Span()
+		return err
+	}
+
+	result := eval(node, env)
+
+	if err := integerOverflow(result, env.IntegerMode()); err != nil {
+		result = err
+	}
+
+	if err, ok := result.(*object.Error); ok && !err.Span.IsValid() {
+		err.Span = node.Span()
+	}
+
+	return result
+}
+
+// EvalContext evaluates node in env like Eval, but stops with an error once
+// ctx is done or the evaluation exceeds limits: "Execution cancelled" when ctx
+// is cancelled, and "Budget exceeded" when the program takes too many steps
+// or runs past its deadline or the deadline of ctx. These errors cannot be
+// caught by the program, and their Halt field tells them apart.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits) object.Object {
+	budget := env.Budget()
+	budget.Start(ctx, limits)
+	defer budget.Stop()
+
+	return Eval(node, env)
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
@@ -20,13 +62,19 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
 	case *ast.BlockStatement:
-		return evalBlockStatement(node.Statements, env)
+		return evalBlockStatement(node.Statements, blockScope(env))
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.BreakStatement:
+		return &object.Break{Label: labelName(node.Label)}
+	case *ast.ContinueStatement:
+		return &object.Continue{Label: labelName(node.Label)}
+	case *ast.ThrowStatement:
+		return evalThrowStatement(node, env)
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
 		if isError(val) {
@@ -37,9 +85,16 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 	// Expressions
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInt{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.ArrayLiteral:
@@ -57,6 +112,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -76,14 +135,18 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return &object.Function{Name: node.Name, Parameters: params, Body: body, Env: env}
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
 		if isError(function) {
@@ -95,17 +158,40 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
-	case *ast.IncrementExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
-			return left
+		// The function being evaluated makes this call itself; see
+		// applyFunction
+		if fn, ok := function.(*object.Function); ok && node.Tail && len(args) >= len(fn.Parameters) {
+			return &object.TailCall{Function: fn, Arguments: args, Call: node.Span()}
+		}
+
+		// Functions a builtin calls back are shown as called from here
+		if _, ok := function.(*object.Builtin); ok {
+			calls := env.CallStack()
+			previous := calls.EnterBuiltin(node.Span())
+			result := applyFunction(function, args, node.Span())
+			calls.LeaveBuiltin(previous)
+			return result
 		}
-		return evalIncrementExpression(left)
+
+		return applyFunction(function, args, node.Span())
+	case *ast.IncrementExpression:
+		return evalIncrementAssignment(node, env)
 	case *ast.WhileExpression:
 		return evalWhileExpression(node, env)
 	case *ast.ForExpression:
 		return evalForExpression(node, env)
+	case *ast.ForInExpression:
+		return evalForInExpression(node, env)
+	case *ast.RangeExpression:
+		start := Eval(node.Start, env)
+		if isError(start) {
+			return start
+		}
+		end := Eval(node.End, env)
+		if isError(end) {
+			return end
+		}
+		return evalRangeExpression(start, end, node.Inclusive)
 	case *ast.AssignmentExpression:
 		return evalAssignmentExpression(node, env)
 	case *ast.PointerReferenceExpression:
@@ -117,10 +203,24 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	return nil
 }
 
-func evalProgram(statements []ast.Statement, env *object.Environment) object.Object {
-	var result object.Object
+// evalProgram runs the top-level statements. A Go panic anywhere below is
+// turned into an internal error attributed to the statement being run, so a
+// bug in the interpreter does not take down the REPL.
+func evalProgram(statements []ast.Statement, env *object.Environment) (result object.Object) {
+	var current ast.Statement
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err := newInternalError(recovered)
+			if current != nil {
+				err.Span = current.Span()
+			}
+			result = err
+		}
+	}()
 
 	for _, statement := range statements {
+		current = statement
 		result = Eval(statement, env)
 
 		switch result := result.(type) {
@@ -143,7 +243,8 @@ func evalBlockStatement(statements []ast.Statement, env *object.Environment) obj
 		if result != nil {
 			resultType := result.Type()
 
-			if resultType == object.RETURN_VALUE_OBJ || resultType == object.ERROR_OBJ {
+			switch resultType {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
 				return result
 			}
 		}
@@ -152,32 +253,103 @@ func evalBlockStatement(statements []ast.Statement, env *object.Environment) obj
 	return result
 }
 
+// blockScope returns the environment a block or loop header runs in: a scope
+// of its own from Version2 on, the surrounding one before.
+func blockScope(env *object.Environment) *object.Environment {
+	if env.Version() < object.Version2 {
+		return env
+	}
+	return object.NewBlockEnvironment(env)
+}
+
 func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 
 	for _, exp := range exps {
 		evaluated := Eval(exp, env)
 		if isError(evaluated) {
-			return []object.Object{}
+			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
 	}
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction calls fn with args. call is the span of the call expression,
+// for stack traces, or invalid for calls made by builtins, which are shown as
+// made where the builtin was called.
+//
+// A call the function body makes in tail position comes back as an
+// *object.TailCall and is made here instead, in place of the finished call:
+// it takes over its frame of the call stack rather than nesting inside it, so
+// tail recursion runs in constant space however deep it goes.
+func applyFunction(fn object.Object, args []object.Object, call token.Span) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		if len(args) < len(fn.Parameters) {
+			return newError("Wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+
+		calls := fn.Env.CallStack()
+		if calls.Depth() >= calls.MaxDepth() {
+			return stackOverflow(calls.MaxDepth())
+		}
+		if !call.IsValid() {
+			call = calls.BuiltinCall()
+		}
+		calls.Push(fn.Name, args, call)
+		defer calls.Pop()
+
+		for {
+			extendedEnv := extendFunctionEnv(fn, args)
+			evaluated := unwrapReturnValue(Eval(fn.Body, extendedEnv))
+
+			if tail, ok := evaluated.(*object.TailCall); ok {
+				fn, args = tail.Function, tail.Arguments
+				calls.Pop()
+				calls.Push(fn.Name, args, tail.Call)
+				continue
+			}
+
+			// The innermost call an error leaves records the calls it was
+			// raised in
+			if err, ok := evaluated.(*object.Error); ok && err.Stack == nil {
+				err.Stack = calls.Trace()
+			}
+
+			return evaluated
+		}
 	case *object.Builtin:
 		return fn.Fn(args...)
+	case object.Callable:
+		return fn.Call(args...)
 	default:
 		return newError("Not a function: %s", fn.Type())
 	}
 }
 
+// stackOverflow returns the error for a call nested deeper than maxDepth.
+func stackOverflow(maxDepth int) *object.Error {
+	return newError("Stack overflow: maximum call depth of %d exceeded", maxDepth)
+}
+
+// stackPerCall bounds the Go stack a nested call takes in the evaluator, with
+// room to spare for calls made from inside loops, try blocks and nested
+// expressions.
+const stackPerCall = 32 << 10
+
+// ReserveStack raises the Go runtime's limit on goroutine stack size so that
+// maxDepth nested calls, up to object.MaxCallDepthLimit, fit in the evaluator.
+// The default limit runs out somewhere under 100000 calls, crashing the
+// interpreter before the depth limit can report a stack overflow. The limit
+// is process-wide and is never lowered.
+func ReserveStack(maxDepth int) {
+	size := min(maxDepth, object.MaxCallDepthLimit) * stackPerCall
+	if previous := debug.SetMaxStack(size); previous > size {
+		debug.SetMaxStack(previous)
+	}
+}
+
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
 	env := object.NewEnclosedEnvironment(fn.Env)
 
@@ -226,6 +398,24 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 	return &object.Hash{Pairs: pairs}
 }
 
+func evalInterpolatedString(node *ast.InterpolatedString, env *object.Environment) object.Object {
+	parts := evalExpressions(node.Parts, env)
+	if len(parts) == 1 && isError(parts[0]) {
+		return parts[0]
+	}
+	return interpolate(parts)
+}
+
+// interpolate joins the parts of an interpolated string, showing each value
+// as object.Display does.
+func interpolate(parts []object.Object) *object.String {
+	var out strings.Builder
+	for _, part := range parts {
+		out.WriteString(object.Display(part))
+	}
+	return &object.String{Value: out.String()}
+}
+
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
@@ -234,6 +424,8 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalMinusPrefixOperatorExpression(right)
 	case "+":
 		return evalPlusPrefixOperatorExpression(right)
+	case "~":
+		return evalBitwiseNotOperatorExpression(right)
 	default:
 		return newError("Unknown operator: %s%s", operator, right.Type())
 	}
@@ -253,6 +445,13 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+	if float, ok := right.(*object.Float); ok {
+		return &object.Float{Value: -float.Value}
+	}
+	if integer, ok := right.(*object.BigInt); ok {
+		return normalizeInteger(new(big.Int).Neg(integer.Value))
+	}
+
 	if right.Type() != object.INTEGER_OBJ {
 		return newError("Unknown operator: -%s", right.Type())
 	}
@@ -263,10 +462,20 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	}
 
 	value := integer.Value
+	if value == math.MinInt64 {
+		return normalizeInteger(new(big.Int).Neg(big.NewInt(value)))
+	}
 	return &object.Integer{Value: -value}
 }
 
 func evalPlusPrefixOperatorExpression(right object.Object) object.Object {
+	if float, ok := right.(*object.Float); ok {
+		return &object.Float{Value: float.Value}
+	}
+	if integer, ok := right.(*object.BigInt); ok {
+		return integer
+	}
+
 	if right.Type() != object.INTEGER_OBJ {
 		return newError("Unknown operator: +%s", right.Type())
 	}
@@ -280,8 +489,25 @@ func evalPlusPrefixOperatorExpression(right object.Object) object.Object {
 	return &object.Integer{Value: value}
 }
 
+func evalBitwiseNotOperatorExpression(right object.Object) object.Object {
+	if integer, ok := right.(*object.BigInt); ok {
+		return normalizeInteger(new(big.Int).Not(integer.Value))
+	}
+
+	integer, ok := right.(*object.Integer)
+	if !ok {
+		return newError("Unknown operator: ~%s", right.Type())
+	}
+
+	return &object.Integer{Value: ^integer.Value}
+}
+
 func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
 	switch {
+	case isFloatOperation(left, right):
+		return evalFloatInfixExpression(operator, left, right)
+	case isBigIntegerOperation(left, right):
+		return evalBigIntegerInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("Type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
@@ -299,33 +525,150 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
 	}
 }
 
-func evalIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
+// evalLogicalExpression evaluates && and ||. The right operand is only
+// evaluated when the left one does not decide the result.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
+// isFloatOperation reports whether an operator applies to two numbers of
+// which at least one is a float. The integer is then promoted to a float.
+func isFloatOperation(left object.Object, right object.Object) bool {
+	return isNumber(left) && isNumber(right) &&
+		(left.Type() == object.FLOAT_OBJ || right.Type() == object.FLOAT_OBJ)
+}
+
+// toFloat returns the value of an integer or float as a float64, for
+// arithmetic. Comparisons use compareNumbers, which does not round.
+func toFloat(obj object.Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *object.Float:
+		return obj.Value, true
+	case *object.Integer:
+		return float64(obj.Value), true
+	case *object.BigInt:
+		value, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return value, true
+	default:
+		return 0, false
+	}
+}
+
+func evalFloatInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal, _ := toFloat(left)
+	rightVal, _ := toFloat(right)
 
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return &object.Float{Value: leftVal + rightVal}
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return &object.Float{Value: leftVal - rightVal}
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return &object.Float{Value: leftVal * rightVal}
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return newError("Division by zero: %s / %s", left.Inspect(), right.Inspect())
+		}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("Division by zero: %s %% %s", left.Inspect(), right.Inspect())
+		}
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
+	case "==", "!=", "<", ">", "<=", ">=":
+		return evalNumberComparison(operator, left, right)
+	default:
+		return newError("Unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalNumberComparison compares an integer and a float, or two floats, by
+// their exact values, so that comparisons agree with hash keys. A NaN is
+// unequal to and unordered with everything.
+func evalNumberComparison(operator string, left object.Object, right object.Object) object.Object {
+	order, ordered := compareNumbers(left, right)
+
+	switch operator {
 	case "==":
-		return nativeBoolToBooleanObject(leftVal == rightVal)
+		return nativeBoolToBooleanObject(ordered && order == 0)
 	case "!=":
-		return nativeBoolToBooleanObject(leftVal != rightVal)
+		return nativeBoolToBooleanObject(!ordered || order != 0)
 	case "<":
-		return nativeBoolToBooleanObject(leftVal < rightVal)
+		return nativeBoolToBooleanObject(ordered && order < 0)
 	case ">":
-		return nativeBoolToBooleanObject(leftVal > rightVal)
+		return nativeBoolToBooleanObject(ordered && order > 0)
 	case "<=":
-		return nativeBoolToBooleanObject(leftVal <= rightVal)
-	case ">=":
-		return nativeBoolToBooleanObject(leftVal >= rightVal)
+		return nativeBoolToBooleanObject(ordered && order <= 0)
 	default:
-		return newError("Unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return nativeBoolToBooleanObject(ordered && order >= 0)
+	}
+}
+
+// maxExactFloatInteger is the largest magnitude up to which every integer
+// is exactly a float64.
+const maxExactFloatInteger = 1 << 53
+
+// compareNumbers returns -1, 0 or +1 as left is less than, equal to or
+// greater than right, and false if either is NaN. Integers too large to be a
+// float64 exactly are compared as big.Floats rather than rounded.
+func compareNumbers(left object.Object, right object.Object) (int, bool) {
+	leftVal, leftExact := exactFloat(left)
+	rightVal, rightExact := exactFloat(right)
+	if leftExact && rightExact {
+		if math.IsNaN(leftVal) || math.IsNaN(rightVal) {
+			return 0, false
+		}
+		return cmp.Compare(leftVal, rightVal), true
+	}
+
+	if (leftExact && math.IsNaN(leftVal)) || (rightExact && math.IsNaN(rightVal)) {
+		return 0, false
+	}
+	return toBigFloat(left).Cmp(toBigFloat(right)), true
+}
+
+// exactFloat returns the value of a float, or of an integer that a float64
+// holds exactly.
+func exactFloat(obj object.Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *object.Float:
+		return obj.Value, true
+	case *object.Integer:
+		if obj.Value >= -maxExactFloatInteger && obj.Value <= maxExactFloatInteger {
+			return float64(obj.Value), true
+		}
+	}
+	return 0, false
+}
+
+// toBigFloat returns the exact value of an integer or a float that is not NaN.
+func toBigFloat(obj object.Object) *big.Float {
+	switch obj := obj.(type) {
+	case *object.Float:
+		return new(big.Float).SetFloat64(obj.Value)
+	case *object.Integer:
+		return new(big.Float).SetInt64(obj.Value)
+	default:
+		return new(big.Float).SetInt(obj.(*object.BigInt).Value)
 	}
 }
 
@@ -333,8 +676,12 @@ func evalIndexExpression(left object.Object, index object.Object) object.Object
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.ERROR_VALUE_OBJ && index.Type() == object.STRING_OBJ:
+		return evalErrorValueIndexExpression(left, index)
 	case left.Type() == object.POINTER_OBJ:
 		return evalPointerIndexExpression(left, index)
 	default:
@@ -357,6 +704,24 @@ func evalArrayIndexExpression(array object.Object, index object.Object) object.O
 	return arrayObject.Elements[indexValue]
 }
 
+// evalStringIndexExpression returns the character at index as a string.
+// Like array indices, string indices count characters (code points), and
+// negative ones count from the end.
+func evalStringIndexExpression(str object.Object, index object.Object) object.Object {
+	characters := []rune(str.(*object.String).Value)
+	indexValue := index.(*object.Integer).Value
+
+	if indexValue < 0 {
+		indexValue = int64(len(characters)) + indexValue
+	}
+
+	if indexValue < 0 || indexValue >= int64(len(characters)) {
+		return NULL
+	}
+
+	return &object.String{Value: string(characters[indexValue])}
+}
+
 func evalHashIndexExpression(hash object.Object, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
@@ -374,27 +739,47 @@ func evalHashIndexExpression(hash object.Object, index object.Object) object.Obj
 }
 
 func evalPointerIndexExpression(pointer object.Object, index object.Object) object.Object {
-	pointerObject := pointer.(*object.Pointer)
+	value := dereference(pointer.(*object.Pointer))
+	if isError(value) {
+		return value
+	}
 
-	switch pointerObject.Value.(type) {
+	switch value.(type) {
 	case *object.Array:
-		return evalArrayIndexExpression(pointerObject.Value, index)
+		return evalArrayIndexExpression(value, index)
+	case *object.String:
+		return evalStringIndexExpression(value, index)
 	case *object.Hash:
-		return evalHashIndexExpression(pointerObject.Value, index)
+		return evalHashIndexExpression(value, index)
 	default:
-		return newError("Cannot index into type: %s", pointerObject.Value.Type())
+		return newError("Cannot index into type: %s", value.Type())
 	}
 }
 
+// evalStringInfixExpression concatenates and compares strings. Strings are
+// ordered lexicographically by code point.
 func evalStringInfixExpression(operator string, left object.Object, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("Unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
 
-	return &object.String{Value: leftVal + rightVal}
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	default:
+		return newError("Unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 func evalArrayInfixExpression(operator string, left object.Object, right object.Object) object.Object {
@@ -435,9 +820,49 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	return newError("Identifier not found: %s", node.Value)
 }
 
+// evalIncrementAssignment evaluates x++. Incrementing a variable stores the
+// result back where the variable was declared.
+func evalIncrementAssignment(node *ast.IncrementExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	result := evalIncrementExpression(left)
+	if isError(result) {
+		return result
+	}
+
+	if identifier, ok := node.Left.(*ast.Identifier); ok {
+		if _, declared := env.Get(identifier.Value); !declared {
+			return result
+		}
+		if !env.IsMutable(identifier.Value) {
+			return newErrorAt(identifier.Span(), "Cannot assign to immutable variable: %s", identifier.Value)
+		}
+		env.Assign(identifier.Value, result)
+	}
+
+	return result
+}
+
 func evalIncrementExpression(left object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	return &object.Integer{Value: leftVal + 1}
+	if float, ok := left.(*object.Float); ok {
+		return &object.Float{Value: float.Value + 1}
+	}
+	if _, ok := left.(*object.BigInt); ok {
+		return evalBigIntegerInfixExpression("+", left, &object.Integer{Value: 1})
+	}
+
+	integer, ok := left.(*object.Integer)
+	if !ok {
+		return newError("Unknown operator: %s++", left.Type())
+	}
+
+	if integer.Value == math.MaxInt64 {
+		return evalBigIntegerInfixExpression("+", left, &object.Integer{Value: 1})
+	}
+	return &object.Integer{Value: integer.Value + 1}
 }
 
 func evalWhileExpression(node *ast.WhileExpression, env *object.Environment) object.Object {
@@ -446,10 +871,12 @@ func evalWhileExpression(node *ast.WhileExpression, env *object.Environment) obj
 		return condition
 	}
 
+	label := labelName(node.Label)
+
 	for isTruthy(condition) {
 		result := Eval(node.Body, env)
-		if isError(result) {
-			return result
+		if stop, value := loopControl(result, label); stop {
+			return value
 		}
 
 		condition = Eval(node.Condition, env)
@@ -464,18 +891,30 @@ func evalWhileExpression(node *ast.WhileExpression, env *object.Environment) obj
 func evalForExpression(node *ast.ForExpression, env *object.Environment) object.Object {
 	letStatement := node.LetStatement
 
+	// The loop variable lives in the scope of the loop header
+	env = blockScope(env)
+
 	// Set the initial value of the loop variable
-	env.Set(letStatement.Name.Value, Eval(letStatement.Value, env), true)
+	initial := Eval(letStatement.Value, env)
+	if isError(initial) {
+		return initial
+	}
+	env.Set(letStatement.Name.Value, initial, true)
+
+	label := labelName(node.Label)
 
 	for {
 		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
 		if !isTruthy(condition) {
 			break
 		}
 
 		result := Eval(node.Body, env)
-		if isError(result) {
-			return result
+		if stop, value := loopControl(result, label); stop {
+			return value
 		}
 
 		increment := Eval(node.Increment, env)
@@ -489,12 +928,41 @@ func evalForExpression(node *ast.ForExpression, env *object.Environment) object.
 	return NULL
 }
 
+// loopControl decides what a loop does after one run of its body. It reports
+// whether the loop stops, and if so the value the loop yields: null for its
+// own break, or the return, error or outer break and continue to pass on.
+func loopControl(result object.Object, label string) (bool, object.Object) {
+	switch result := result.(type) {
+	case *object.Break:
+		if result.Label == "" || result.Label == label {
+			return true, NULL
+		}
+		return true, result
+	case *object.Continue:
+		if result.Label == "" || result.Label == label {
+			return false, nil
+		}
+		return true, result
+	case *object.ReturnValue, *object.Error:
+		return true, result
+	}
+
+	return false, nil
+}
+
+func labelName(label *ast.Identifier) string {
+	if label == nil {
+		return ""
+	}
+	return label.Value
+}
+
 func evalAssignmentExpression(node *ast.AssignmentExpression, env *object.Environment) object.Object {
 	// Assignment to a variable
 	if identifier, ok := node.Left.(*ast.Identifier); ok {
 		// Check if the variable is mutable
 		if !env.IsMutable(identifier.Value) {
-			return newError("(line %d) Cannot assign to immutable variable: %s", identifier.Token.LineNumber, identifier.Value)
+			return newErrorAt(identifier.Span(), "Cannot assign to immutable variable: %s", identifier.Value)
 		}
 
 		// Evaluate the right-hand side of the assignment
@@ -503,8 +971,8 @@ func evalAssignmentExpression(node *ast.AssignmentExpression, env *object.Enviro
 			return right
 		}
 
-		// Set the value of the variable
-		env.Set(identifier.Value, right, true)
+		// Set the value of the variable where it was declared
+		env.Assign(identifier.Value, right)
 
 		// Return the value of the assignment
 		return right
@@ -521,12 +989,12 @@ func evalAssignmentExpression(node *ast.AssignmentExpression, env *object.Enviro
 		// Check if it's a pointer
 		pointer, ok := pointerObj.(*object.Pointer)
 		if !ok {
-			return newError("(line %d) Cannot dereference non-pointer value: %s", node.Token.LineNumber, pointerObj.Type())
+			return newError("Cannot dereference non-pointer value: %s", pointerObj.Type())
 		}
 
 		// Check if the variable is mutable
-		if !env.IsMutable(pointer.Name) {
-			return newError("(line %d) Cannot assign to immutable variable: %s", node.Token.LineNumber, pointer.Name)
+		if !pointer.Cell.Mutable {
+			return newError("Cannot assign to immutable variable: %s", pointer.Name)
 		}
 
 		// Evaluate the right-hand side of the assignment
@@ -535,13 +1003,8 @@ func evalAssignmentExpression(node *ast.AssignmentExpression, env *object.Enviro
 			return right
 		}
 
-		// Set the value of the variable
-		env.Set(pointer.Name, right, true)
-
-		// Update the pointer's value
-		pointer.Value = right
-
-		return right
+		// Store the value in the variable, element or entry it points to
+		return storeThroughPointer(pointer, right)
 	}
 
 	// Assignment to an Index Expression
@@ -556,7 +1019,7 @@ func evalAssignmentExpression(node *ast.AssignmentExpression, env *object.Enviro
 		// Check if the variable is mutable
 		if identifier, ok := indexExpression.Left.(*ast.Identifier); ok {
 			if !env.IsMutable(identifier.Value) {
-				return newError("(line %d) Cannot assign to immutable variable: %s", identifier.Token.LineNumber, identifier.Value)
+				return newErrorAt(identifier.Span(), "Cannot assign to immutable variable: %s", identifier.Value)
 			}
 		}
 
@@ -572,81 +1035,48 @@ func evalAssignmentExpression(node *ast.AssignmentExpression, env *object.Enviro
 			return right
 		}
 
-		// Assign the value to the index of the array or map
-		switch left := left.(type) {
-		// Array Assignment
-		case *object.Array:
-			indexValue, ok := index.(*object.Integer)
-			if !ok {
-				return newError("(line %d) Array index must be an integer", node.Token.LineNumber)
-			}
-
-			idx := indexValue.Value
-			// Support negative indices (like Python)
-			if idx < 0 {
-				idx = int64(len(left.Elements)) + idx
-			}
-
-			// Check bounds
-			if idx < 0 || idx >= int64(len(left.Elements)) {
-				return newError("(line %d) Array index out of bounds: %d", node.Token.LineNumber, idx)
-			}
-
-			left.Elements[idx] = right
-			return right
-
-		// Map Assignment
-		case *object.Hash:
-			key, ok := index.(object.Hashable)
-			if !ok {
-				return newError("(line %d) Unusable as hash key: %s", node.Token.LineNumber, index.Type())
-			}
-			left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: right}
-			return right
-
-		default:
-			return newError("(line %d) Cannot index into type: %s", node.Token.LineNumber, left.Type())
-		}
+		return evalIndexAssignment(left, index, right)
 	}
 
-	return newError("(line %d) invalid assignment target", node.Token.LineNumber)
+	return newError("invalid assignment target")
 }
 
-func evalPointerReferenceExpression(node *ast.PointerReferenceExpression, env *object.Environment) object.Object {
-	// We can only take the address of identifiers
-	if identifier, ok := node.Right.(*ast.Identifier); ok {
-		// Check if the variable exists
-		val, exists := env.Get(identifier.Value)
-		if !exists {
-			return newError("Cannot take address of undefined variable: %s", identifier.Value)
+func evalIndexAssignment(left object.Object, index object.Object, right object.Object) object.Object {
+	// Assign the value to the index of the array or map
+	switch left := left.(type) {
+	// Array Assignment
+	case *object.Array:
+		indexValue, ok := index.(*object.Integer)
+		if !ok {
+			return newError("Array index must be an integer")
 		}
 
-		// Create a pointer to the variable
-		return &object.Pointer{
-			Name:  identifier.Value,
-			Value: val,
+		idx := indexValue.Value
+		// Support negative indices (like Python)
+		if idx < 0 {
+			idx = int64(len(left.Elements)) + idx
 		}
-	}
 
-	return newError("(line %d) Cannot take address of non-identifier expression", node.Token.LineNumber)
-}
+		// Check bounds
+		if idx < 0 || idx >= int64(len(left.Elements)) {
+			return newError("Array index out of bounds: %d", idx)
+		}
 
-func evalPointerDereferenceExpression(node *ast.PointerDereferenceExpression, env *object.Environment) object.Object {
-	right := Eval(node.Right, env)
-	if isError(right) {
+		left.Elements[idx] = right
 		return right
-	}
 
-	if pointer, ok := right.(*object.Pointer); ok {
-		// Get the current value from the environment
-		val, exists := env.Get(pointer.Name)
-		if !exists {
-			return newError("Pointer references undefined variable: %s", pointer.Name)
+	// Map Assignment
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("Unusable as hash key: %s", index.Type())
 		}
-		return val
-	}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: right}
+		return right
 
-	return newError("(line %d) Cannot dereference non-pointer value: %s", node.Token.LineNumber, right.Type())
+	default:
+		return newError("Cannot index into type: %s", left.Type())
+	}
 }
 
 func isTruthy(obj object.Object) bool {
@@ -664,6 +1094,17 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// newInternalError wraps a recovered Go panic.
+func newInternalError(recovered interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf("Internal error: %v", recovered), Internal: true}
+}
+
+// newErrorAt creates an error attributed to span rather than to the node
+// being evaluated.
+func newErrorAt(span token.Span, format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Span: span}
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
@@ -1,9 +1,12 @@
 package evaluator
 
 import (
+	"context"
 	"ember_lang/ember_lang/object"
+	"math"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -26,6 +29,34 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"7 % -3", 1},
+		{"2 + 10 % 4 * 3", 8},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 3", -8},
+		{"5 ** 0", 1},
+		{"0 ** 0", 1},
+		{"12 & 10", 8},
+		{"12 | 10", 14},
+		{"12 ^ 10", 6},
+		{"~5", -6},
+		{"~-1", 0},
+		{"1 << 10", 1024},
+		{"1024 >> 3", 128},
+		{"-16 >> 2", -4},
+		{"1 | 2 ^ 3 & 4", 3},
+		{"1 << 2 + 1", 8},
+		{"let x = 6; x & 3 ^ 1", 3},
+		{"0xFF", 255},
+		{"0XfF", 255},
+		{"0b1010", 10},
+		{"0o755", 493},
+		{"1_000_000", 1000000},
+		{"0xFF_FF", 65535},
+		{"0b1111_0000 & 0xF0", 240},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -33,6 +64,165 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"2 ** 100", "1267650600228229401496703205376"},
+		{"1 << 64", "18446744073709551616"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"let mut x = 9223372036854775807; x++; x", "9223372036854775808"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"~(2 ** 64)", "-18446744073709551617"},
+		{"let f = fn(n) { if (n < 2) { return n; } let mut a = 0; let mut b = 1; for (let i = 1; i < n; i++) { let c = a + b; a = b; b = c; } b }; f(100)", "354224848179261915075"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Type() != object.BIGINT_OBJ || evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. expected=%s, got=%s (%s)",
+				tt.input, tt.expected, evaluated.Inspect(), evaluated.Type())
+		}
+	}
+
+	// Results that fit in 64 bits again are plain integers
+	demoted := []struct {
+		input    string
+		expected int64
+	}{
+		{"(2 ** 64) - (2 ** 64) + 5", 5},
+		{"(2 ** 70) / (2 ** 60)", 1024},
+		{"9223372036854775807 + 1 - 1", 9223372036854775807},
+		{"99999999999999999999 % 7", 1},
+		{"(2 ** 64) >> 60", 16},
+		{`{(2 ** 64): 1, 1: 2}[2 ** 32 * 2 ** 32]`, 1},
+		{`let mut n = 0; for (k in {(2 ** 65): 1, 3: 1, (-(2 ** 65)): 1}) { n = n * 10 + k / (2 ** 64); } n`, -2*100 + 0*10 + 2},
+	}
+
+	for _, tt := range demoted {
+		evaluated := testEval(tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+
+	booleans := []struct {
+		input    string
+		expected bool
+	}{
+		{"2 ** 64 > 9223372036854775807", true},
+		{"2 ** 64 == 2 ** 32 * 2 ** 32", true},
+		{"-(2 ** 64) < 1", true},
+		{"2 ** 64 == 18446744073709551616.0", true},
+	}
+
+	for _, tt := range booleans {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestCheckedIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "Integer overflow: 9223372036854775808 does not fit in 64 bits"},
+		{"let f = fn(n) { n * 4294967296 }; f(4294967296)", "Integer overflow: 18446744073709551616 does not fit in 64 bits"},
+		{"99999999999999999999", "Integer overflow: 99999999999999999999 does not fit in 64 bits"},
+		{"mul(4294967296, 4294967296)", "Integer overflow: 18446744073709551616 does not fit in 64 bits"},
+		{"let mut x = 9223372036854775807; x++", "Integer overflow: 9223372036854775808 does not fit in 64 bits"},
+		{"-9223372036854775809", "Integer overflow: -9223372036854775809 does not fit in 64 bits"},
+		{"-9223372036854775808 - 1", "Integer overflow: -9223372036854775809 does not fit in 64 bits"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetIntegerMode(object.CheckedIntegers)
+		evaluated := testEvalIn(tt.input, env)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+
+	env := object.NewEnvironment()
+	env.SetIntegerMode(object.CheckedIntegers)
+	testIntegerObject(t, testEvalIn("9223372036854775806 + 1", env), 9223372036854775807)
+	testIntegerObject(t, testEvalIn("-9223372036854775808", env), math.MinInt64)
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{"1e-9", 1e-9},
+		{"-2.5", -2.5},
+		{"+2.5", 2.5},
+		{"1.5 + 2.25", 3.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"10 - 2.5", 7.5},
+		{"2.5 * 4", 10},
+		{"7 / 2.0", 3.5},
+		{"7.5 % 2", 1.5},
+		{"2 ** 0.5 ** 2", 1.189207115002721},
+		{"2.0 ** -1", 0.5},
+		{"let mut x = 1.5; x++; x", 2.5},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+
+	// Integers are compared with floats exactly, as hash keys are, not
+	// rounded to the nearest float
+	comparisons := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 == 1.0", true},
+		{"2 < 2.5", true},
+		{"9007199254740993 == 9007199254740992.0", false},
+		{"9007199254740993 != 9007199254740992.0", true},
+		{"9007199254740993 > 9007199254740992.0", true},
+		{"9007199254740992.0 < 9007199254740993", true},
+		{"9007199254740992 == 9007199254740992.0", true},
+		{"{9007199254740993: 1}[9007199254740992.0]", nil},
+		{"{9007199254740992: 1}[9007199254740992.0]", 1},
+		{"99999999999999999999 == 1e20", false},
+		{"100000000000000000000 == 1e20", true},
+		{"100000000000000000001 > 1e20", true},
+		{"100000000000000000001 >= 1e20", true},
+		{"1e20 <= 99999999999999999999", false},
+		{"-9223372036854775808 == -9223372036854775808.0", true},
+		{"let nan = (-1.0) ** 0.5; nan == nan", false},
+		{"let nan = (-1.0) ** 0.5; nan != nan", true},
+		{"let nan = (-1.0) ** 0.5; nan < 99999999999999999999 || nan >= 99999999999999999999", false},
+	}
+	for _, tt := range comparisons {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -63,6 +253,23 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(10 > 10) == true", false},
 		{"(10 == 10) == true", true},
 		{"(10 != 10) == false", true},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"0.1 + 0.2 == 0.3", false},
+		{"2.5 <= 2.5", true},
+		{"-0.0 == 0.0", true},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{`"apple" < "banana"`, true},
+		{`"apple" < "app"`, false},
+		{`"Zebra" < "apple"`, true},
+		{`"b" > "a"`, true},
+		{`"ab" <= "ab"`, true},
+		{`"ä" >= "z"`, true},
+		{`"" < "a"`, true},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -167,6 +374,46 @@ func TestErrorHandling(t *testing.T) {
 			"-true",
 			"Unknown operator: -BOOLEAN",
 		},
+		{
+			"~true",
+			"Unknown operator: ~BOOLEAN",
+		},
+		{
+			"true & false",
+			"Unknown operator: BOOLEAN & BOOLEAN",
+		},
+		{
+			`"a" % "b"`,
+			"Unknown operator: STRING % STRING",
+		},
+		{
+			`"a" < 1`,
+			"Type mismatch: STRING < INTEGER",
+		},
+		{
+			`"abc"["a"]`,
+			"Index operator not supported: STRING STRING",
+		},
+		{
+			`"abc"[1:"b"]`,
+			"Slice bounds must be integers, got: STRING",
+		},
+		{
+			`{"a": 1}[0:1]`,
+			"Slice operator not supported: HASH",
+		},
+		{
+			"5 % 0",
+			"Division by zero: 5 % 0",
+		},
+		{
+			"2 ** -1",
+			"Negative exponent: 2 ** -1",
+		},
+		{
+			"1 << -1",
+			"Negative shift count: 1 << -1",
+		},
 		{
 			"true + false;",
 			"Unknown operator: BOOLEAN + BOOLEAN",
@@ -202,6 +449,130 @@ func TestErrorHandling(t *testing.T) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"Unusable as hash key: FUNCTION",
 		},
+		{
+			"5 / 0",
+			"Division by zero: 5 / 0",
+		},
+		{
+			"div(7, 0)",
+			"Division by zero: 7 / 0",
+		},
+		{
+			"(2 ** 64) / 0",
+			"Division by zero: 18446744073709551616 / 0",
+		},
+		{
+			"2 ** (2 ** 64)",
+			"Integer too large: 2 ** 18446744073709551616",
+		},
+		{
+			"1 << 100000000",
+			"Integer too large: 1 << 100000000",
+		},
+		{
+			"0..(2 ** 64)",
+			"Range bounds must fit in 64 bits: 0..18446744073709551616",
+		},
+		{
+			"1.5 / 0",
+			"Division by zero: 1.5 / 0",
+		},
+		{
+			"1 % 0.0",
+			"Division by zero: 1 % 0.0",
+		},
+		{
+			"1.5 & 1",
+			"Unknown operator: FLOAT & INTEGER",
+		},
+		{
+			"1.5 + true",
+			"Type mismatch: FLOAT + BOOLEAN",
+		},
+		{
+			"~1.5",
+			"Unknown operator: ~FLOAT",
+		},
+		{
+			`add(1.5, "a")`,
+			"Invalid argument to add. Got: STRING, Expected: INTEGER or FLOAT",
+		},
+		{
+			`reduce([1, "a"], add, 0)`,
+			"Invalid argument to reduce at index 1. Got: STRING, Expected: INTEGER or FLOAT",
+		},
+		{
+			`reduce([1], add, "a")`,
+			"Invalid argument to reduce. Got: STRING, Expected: INTEGER or FLOAT",
+		},
+		{
+			`reduce([1, 0], div, 10)`,
+			"Division by zero: 10 / 0",
+		},
+		{
+			"len(1 / 0)",
+			"Division by zero: 1 / 0",
+		},
+		{
+			"for (x in 5) { }",
+			"Not iterable: INTEGER",
+		},
+		{
+			"\"a\"..3",
+			"Range bounds must be integers: STRING..INTEGER",
+		},
+		{
+			"1..=true",
+			"Range bounds must be integers: INTEGER..=BOOLEAN",
+		},
+		{
+			"for (x in [1]) { x = 2; }",
+			"Cannot assign to immutable variable: x",
+		},
+		{
+			"let add = fn(x, y) { x + y }; add(1);",
+			"Wrong number of arguments: want=2, got=1",
+		},
+		{
+			"map([1, 2], fn(x, y) { x })",
+			"Wrong number of arguments: want=2, got=1",
+		},
+		{
+			"let mut b = true; b++",
+			"Unknown operator: BOOLEAN++",
+		},
+		{
+			`let mut s = "a"; s++`,
+			"Unknown operator: STRING++",
+		},
+		{
+			"let i = 0; i++",
+			"Cannot assign to immutable variable: i",
+		},
+		{
+			"let i = 0; let f = fn() { i++ }; f()",
+			"Cannot assign to immutable variable: i",
+		},
+		{
+			`throw "boom"; 5`,
+			"boom",
+		},
+		{
+			`let f = fn() { throw error("bad input") }; f() + 1`,
+			"bad input",
+		},
+		{
+			`try { 1 / 0 } catch (e) { throw "rethrown: " + e["message"] }`,
+			"rethrown: Division by zero: 1 / 0",
+		},
+		{
+			`try { 1 } finally { throw "from finally" }`,
+			"from finally",
+		},
+		{
+			`error(1)`,
+			"Invalid argument to error. Got: INTEGER, Expected: STRING",
+		},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -285,6 +656,64 @@ func TestClosures(t *testing.T) {
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestClosureAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		// A closure counter keeps counting across calls
+		{`
+			let makeCounter = fn() {
+				let mut count = 0;
+				fn() { count = count + 1; count }
+			};
+			let counter = makeCounter();
+			counter();
+			counter();
+			counter();
+		`, 3},
+		// Each counter has its own variable
+		{`
+			let makeCounter = fn() {
+				let mut count = 0;
+				fn() { count++ }
+			};
+			let a = makeCounter();
+			let b = makeCounter();
+			a(); a(); b();
+			a() * 10 + b();
+		`, 32},
+		// A function updates a global
+		{"let mut total = 0; let add = fn(n) { total = total + n; }; add(2); add(3); total", 5},
+		// Nested functions update the variable of the function that declared it
+		{`
+			let outer = fn() {
+				let mut x = 1;
+				let middle = fn() {
+					let inner = fn() { x = x * 10; };
+					inner();
+					x = x + 2;
+				};
+				middle();
+				middle();
+				x
+			};
+			outer();
+		`, 122},
+		// A parameter of the same name shadows the outer variable
+		{"let mut x = 1; let f = fn(x) { let mut y = x; y = y + 1; y }; f(5) + x", 7},
+		// let inside a function still declares a local
+		{"let mut x = 1; let f = fn() { let mut x = 5; x = 6; x }; f() + x", 7},
+		// Pointer writes and ++ go to the declaring scope too
+		{"let mut x = 1; let f = fn() { let p = &x; *p = 9; }; f(); x", 9},
+		{"let mut x = 1; let f = fn() { x++; x++; }; f(); x", 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 	evaluated := testEval(input)
@@ -310,6 +739,24 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestStringInterpolation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "Ann"; let age = 41; "Hello, ${name}, you are ${age + 1}"`, "Hello, Ann, you are 42"},
+		{`"${1.5} ${2 ** 70} ${true} ${[1, "a"]} ${if (false) { 1 }}"`, "1.5 1180591620717411303424 true [1, a] null"},
+		{`let greet = fn(who) { "hi ${who}" }; "${greet("${1 + 1}")}!"`, "hi 2!"},
+		{`let mut n = 0; "${n++}${n++}${n}"`, "122"},
+		{`"${ {"k": "v"}["k"] }"`, "v"},
+		{`"cost: \${price}"`, "cost: ${price}"},
+	}
+
+	for _, tt := range tests {
+		testStringObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestBuiltinLenFunction(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -319,6 +766,9 @@ func TestBuiltinLenFunction(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("größe")`, 5},
+		{`len("名前")`, 2},
+		{`len("\u{1F600}")`, 1},
 		// Array
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
@@ -368,6 +818,54 @@ func TestBuiltinPushFunction(t *testing.T) {
 	}
 }
 
+func TestBuiltinBytesFunction(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []int64
+	}{
+		{`bytes("")`, []int64{}},
+		{`bytes("hi")`, []int64{104, 105}},
+		{`bytes("é")`, []int64{0xC3, 0xA9}},
+		{`bytes("名前")`, []int64{0xE5, 0x90, 0x8D, 0xE5, 0x89, 0x8D}},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		result, ok := evaluated.(*object.Array)
+		if !ok {
+			t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+		}
+		if len(result.Elements) != len(tt.expected) {
+			t.Fatalf("array has wrong num of elements. got=%d",
+				len(result.Elements))
+		}
+		for i, expected := range tt.expected {
+			testIntegerObject(t, result.Elements[i], expected)
+		}
+	}
+
+	errObj, ok := testEval("bytes(1)").(*object.Error)
+	if !ok || errObj.Message != "Invalid argument to bytes. Got: INTEGER, Expected: STRING" {
+		t.Errorf("wrong error. got=%s", testEval("bytes(1)").Inspect())
+	}
+}
+
+func TestBuiltinDeleteFunction(t *testing.T) {
+	input := `let h = {"a": 1, "b": 2}; let d = delete(h, "a"); [d["a"], d["b"], h["a"]]`
+
+	result, ok := testEval(input).(*object.Array)
+	if !ok || len(result.Elements) != 3 {
+		t.Fatalf("wrong result. got=%s", testEval(input).Inspect())
+	}
+	testNullObject(t, result.Elements[0])
+	testIntegerObject(t, result.Elements[1], 2)
+	testIntegerObject(t, result.Elements[2], 1)
+
+	errObj, ok := testEval("delete([1], 0)").(*object.Error)
+	if !ok || errObj.Message != "Invalid argument to delete. Got: ARRAY, Expected: HASH" {
+		t.Errorf("wrong error. got=%s", testEval("delete([1], 0)").Inspect())
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	evaluated := testEval(input)
@@ -519,6 +1017,22 @@ func TestReduceFunction(t *testing.T) {
 
 		testIntegerObject(t, evaluated, tt.expected)
 	}
+
+	floatTests := []struct {
+		input    string
+		expected float64
+	}{
+		{`reduce([0.5, 1.5], add, 0)`, 2},
+		{`reduce([1, 2], add, 0.5)`, 3.5},
+		{`reduce([2, 4], div, 1.0)`, 0.125},
+		{`reduce([1.5, 2], mul, 2)`, 6},
+		{`reduce([1, 2], fn(acc, x) { acc - x * 0.5 }, 0)`, -1.5},
+	}
+	for _, tt := range floatTests {
+		evaluated := testEval(tt.input)
+
+		testFloatObject(t, evaluated, tt.expected)
+	}
 }
 
 func TestBuiltinStackingFunctions(t *testing.T) {
@@ -535,6 +1049,60 @@ func TestBuiltinStackingFunctions(t *testing.T) {
 	}
 }
 
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"abc"[0]`, "a"},
+		{`"abc"[2]`, "c"},
+		{`"abc"[-1]`, "c"},
+		{`"größe"[2]`, "ö"},
+		{`"名前"[-2]`, "名"},
+		{`"abc"[3]`, nil},
+		{`"abc"[-4]`, nil},
+		{`""[0]`, nil},
+		{`let s = "abc"; let p = &s; p[1]`, "b"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if expected, ok := tt.expected.(string); ok {
+			testStringObject(t, evaluated, expected)
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[:2]`, "he"},
+		{`"hello"[3:]`, "lo"},
+		{`"hello"[:]`, "hello"},
+		{`"hello"[-3:-1]`, "ll"},
+		{`"hello"[-10:10]`, "hello"},
+		{`"hello"[4:2]`, ""},
+		{`"größe名前"[3:6]`, "ße名"},
+		{`[1, 2, 3, 4][1:3]`, "[2, 3]"},
+		{`[1, 2, 3, 4][:-1]`, "[1, 2, 3]"},
+		{`[1, 2, 3, 4][2:]`, "[3, 4]"},
+		{`[1, 2, 3][5:]`, "[]"},
+		{`[1, 2, 3][0:2 ** 70]`, "[1, 2, 3]"},
+		{`let mut a = [1, 2, 3]; let b = a[:2]; a[0] = 9; b`, "[1, 2]"},
+		{`let a = [1, 2, 3]; let p = &a; p[1:]`, "[2, 3]"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong slice for %q. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestHashLiterals(t *testing.T) {
 	input := `let two = "two";
 	{
@@ -605,6 +1173,18 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{1: 5}[1.0]`,
+			5,
+		},
+		{
+			`{-2.0: 5}[-2]`,
+			5,
+		},
+		{
+			`{1: 5}[1.5]`,
+			nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -642,19 +1222,216 @@ func TestOrEqualOperator(t *testing.T) {
 	}
 }
 
-func TestFibo(t *testing.T) {
+func TestBreakAndContinue(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected int64
 	}{
-		{`
-			let fib = fn(n) {
-				if (n <= 1) {
-					return n;
-				}
-				return fib(n - 1) + fib(n - 2);
-			};
-			fib(10);
+		{"let mut i = 0; while (true) { i = i + 1; if (i == 5) { break; } } i", 5},
+		{"let mut sum = 0; for (let i = 0; i < 10; i++) { if (i % 2 == 0) { continue; } sum = sum + i; } sum", 25},
+		{"let mut i = 0; let mut s = 0; while (i < 5) { i = i + 1; if (i == 3) { continue; } s = s + i; } s", 12},
+		{"let mut n = 0; while (true) { if (n < 3) { n = n + 1; } else { break; } } n", 3},
+		{"let mut c = 0; for (let i = 0; i < 3; i++) { while (true) { c = c + 1; break; } } c", 3},
+		{"let mut c = 0; outer: for (let i = 0; i < 5; i++) { for (let j = 0; j < 5; j++) { if (j == 2) { continue outer; } if (i == 3) { break outer; } c = c + 1; } } c", 6},
+		{"let mut c = 0; outer: while (true) { while (true) { c = c + 1; if (c == 4) { break outer; } continue outer; } } c", 4},
+		{"let f = fn() { let mut i = 0; while (true) { i = i + 1; if (i == 3) { return i * 10; } } }; f()", 30},
+		{"let f = fn() { for (let i = 0; i < 10; i++) { if (i == 4) { return i; } } return 99; }; f()", 4},
+		{"let f = fn() { for (let i = 0; i < 3; i++) { let g = fn() { return 1; }; g(); } return 7; }; f()", 7},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	testNullObject(t, testEval("while (true) { break; }"))
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { 1 } catch (e) { 2 }`, "1"},
+		{`try { throw "boom"; 1 } catch (e) { e["message"] }`, "boom"},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "Division by zero: 1 / 0"},
+		{`try { [1][nope] } catch { "caught" }`, "caught"},
+		{`try { throw 42 } catch (e) { e["message"] }`, "42"},
+		{`try { throw error("bad") } catch (e) { e }`, "error: bad"},
+		{"let f = fn() {\n  throw \"x\"\n};\ntry { f() } catch (e) { e[\"line\"] }", "2"},
+		{"try {\n  throw \"x\"\n} catch (e) { e[\"stack\"] }", "[at 2:3]"},
+		{`try { throw "x" } catch (e) { e["nothing"] }`, "null"},
+		{`let f = fn(x) { if (x < 0) { throw "negative" } x }; map([1, -1], fn(x) { try { f(x) } catch { 0 } })`, "[1, 0]"},
+		{`try { map([1], fn(x) { throw "in map" }) } catch (e) { e["message"] }`, "in map"},
+		{`try { try { throw "inner" } catch (e) { throw e["message"] + "!" } } catch (e) { e["message"] }`, "inner!"},
+		{`let e = 1; try { throw "x" } catch (e) { e["message"] }; e`, "1"},
+
+		// finally runs whatever happens
+		{`let mut log = []; try { log = push(log, 1) } finally { log = push(log, 2) }; log`, "[1, 2]"},
+		{`let mut log = []; try { throw "x" } catch { log = push(log, 1) } finally { log = push(log, 2) }; log`, "[1, 2]"},
+		{`let mut log = []; try { try { throw "x" } finally { log = push(log, 1) } } catch (e) { log = push(log, e["message"]) }; log`, "[1, x]"},
+		{`let mut log = []; let f = fn() { try { return 1 } finally { log = push(log, 2) } }; [f(), log]`, "[1, [2]]"},
+		{`let mut n = 0; for (i in 0..5) { try { if (i == 3) { break } n = n + i } finally { n = n + 100 } }; n`, "403"},
+		{`let mut n = 0; for (i in 0..3) { try { continue } finally { n = n + 1 } }; n`, "3"},
+		{`let mut log = []; outer: for (a in [1, 2]) { try { for (b in [1, 2]) { try { if (b == 2) { continue outer } } finally { log = push(log, b) } } } finally { log = push(log, "o") } }; log`, "[1, 2, o, 1, 2, o]"},
+
+		// The value of a finally clause is discarded, unless it leaves
+		{`try { 1 } finally { 2 }`, "1"},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, "2"},
+		{`let f = fn() { try { throw "x" } finally { return 2 } }; f()`, "2"},
+		{`let mut n = 0; while (true) { try { throw "x" } finally { break } }; n`, "0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`error("bad")`, "error: bad"},
+		{`error("bad")["message"]`, "bad"},
+		{`error("bad")["line"]`, "null"},
+		{`error("bad")["stack"]`, "[]"},
+		{`is_error(error("bad"))`, "true"},
+		{`is_error(try { throw "x" } catch (e) { e })`, "true"},
+		{`is_error("bad")`, "false"},
+		{`let check = fn(x) { if (x < 0) { return error("negative") } x }; let r = check(-1); if (is_error(r)) { r["message"] } else { r }`, "negative"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestForInExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let mut s = 0; for (x in [1, 2, 3]) { s = s + x; } s", 6},
+		{"let mut s = 0; for (i, x in [10, 20, 30]) { s = s + i * x; } s", 80},
+		{"let mut s = 0; for (k, v in {\"a\": 1, \"b\": 2}) { s = s + v; } s", 3},
+		{"let mut s = 0; for (i, c in \"héllo\") { s = s + i; } s", 10},
+		{"let mut s = 0; for (i in 0..5) { s = s + i; } s", 10},
+		{"let mut s = 0; for (i in 0..=5) { s = s + i; } s", 15},
+		{"let mut s = 0; for (i in 5..0) { s = s + 1; } s", 0},
+		{"let mut s = 0; for (i in 3..3) { s = s + 1; } s", 0},
+		{"let mut s = 0; for (i in 3..=3) { s = s + i; } s", 3},
+		{"let mut s = 0; for (i, v in 10..13) { s = s + i; } s", 3},
+		{"let mut s = 0; for (i in -2..2) { s = s + i; } s", -2},
+		{"let mut s = 0; for (i in 0..100) { if (i % 2 == 1) { continue; } if (i > 6) { break; } s = s + i; } s", 12},
+		{"let f = fn(xs) { for (x in xs) { if (x > 2) { return x; } } return -1; }; f([1, 5, 3])", 5},
+		{"let mut n = 0; outer: for (i in 0..3) { for (j in 0..3) { if (j > i) { continue outer; } n = n + 1; } } n", 6},
+		{"let mut calls = {\"n\": 0}; let xs = fn() { calls[\"n\"] = calls[\"n\"] + 1; [1, 2, 3] }; for (x in xs()) { } calls[\"n\"]", 1},
+		{"let xs = [1, 2]; let mut s = 0; for (xs in xs) { s = s + xs; } s", 3},
+		{"let mut out = \"\"; for (k in {\"b\": 1, \"a\": 2, \"c\": 3}) { out = out + k; } out", "abc"},
+		{"let mut out = \"\"; for (k, v in {2: \"x\", true: \"y\", 1: \"z\", \"a\": \"w\", false: \"v\"}) { out = out + v; } out", "vyzxw"},
+		{"let mut out = \"\"; for (c in \"abc\") { out = c + out; } out", "cba"},
+		{"type(1..=2)", "RANGE"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestRangeExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"0..3", "0..3"},
+		{"let n = 4; 1..=n * 2", "1..=8"},
+		{"5..-5", "5..-5"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		if _, ok := evaluated.(*object.Range); !ok {
+			t.Errorf("object is not Range. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong range. expected=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || false", false},
+		{"false || true", true},
+		{"true || false", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"true || false && false", true},
+		{"5 && 0", true},
+		{"[] && {}", true},
+		{"if (false) { 1 } || true", true},
+		{"if (false) { 1 } && true", false},
+		// The right operand is not evaluated once the left decides the result
+		{"false && undefinedVariable", false},
+		{"true || undefinedVariable", true},
+		{`let mut calls = {"n": 0}; let f = fn() { calls["n"] = calls["n"] + 1; true }; false && f(); true || f(); calls["n"] == 0`, true},
+		{`let mut calls = {"n": 0}; let f = fn() { calls["n"] = calls["n"] + 1; true }; true && f(); false || f(); calls["n"] == 2`, true},
+		// Pointers
+		{"let mut x = 5; let p = &x; *p == 5 && x == 5", true},
+		{"let x = 5; true && &x", true},
+		{"let x = 5; let b = true; b&&&x", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+
+	errorTests := []string{
+		"true && undefinedVariable",
+		"false || 5 + true",
+	}
+	for _, input := range errorTests {
+		if evaluated := testEval(input); !isError(evaluated) {
+			t.Errorf("expected error for %q. got=%s", input, evaluated.Inspect())
+		}
+	}
+}
+
+func TestFibo(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`
+			let fib = fn(n) {
+				if (n <= 1) {
+					return n;
+				}
+				return fib(n - 1) + fib(n - 2);
+			};
+			fib(10);
 		`, 55},
 	}
 
@@ -724,6 +1501,9 @@ func TestIncrementExpression(t *testing.T) {
 		{`let mut i = 0; i = i++; return i;`, 1},
 		{`let mut i = 0; i = i++; i = i++; return i;`, 2},
 		{`let mut i = 0; i = i++; i = i++; i = i++; return i;`, 3},
+		{`let mut i = 0; i++; i++; return i;`, 2},
+		{`let mut i = 5; let j = i++; return i * 10 + j;`, 66},
+		{`let mut i = 0; while (i < 3) { i++; } return i;`, 3},
 	}
 
 	for _, tt := range tests {
@@ -753,6 +1533,8 @@ func TestWhileExpression(t *testing.T) {
 
 }
 
+// TestForExpression runs under Version1, where the variables of a loop body
+// stay visible after the loop.
 func TestForExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -795,10 +1577,77 @@ func TestForExpression(t *testing.T) {
 			}
 			return x;
 		`, 5},
+		{`
+			for (let i = 0; i < 3; i++) { }
+			return i;
+		`, 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalVersion(tt.input, object.Version1)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestBlockScoping(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 5; if (true) { let x = 10; } x", 5},
+		{"let x = 5; if (true) { let x = 10; x }", 10},
+		{"let x = 5; if (false) { } else { let x = 10; } x", 5},
+		{"if (true) { let y = 1; } y", "Identifier not found: y"},
+		{"for (let i = 0; i < 3; i++) { } i", "Identifier not found: i"},
+		{"for (let i = 0; i < 3; i++) { let x = i; } x", "Identifier not found: x"},
+		{"for (x in [1, 2]) { } x", "Identifier not found: x"},
+		{"let mut n = 0; while (n < 3) { let m = n + 1; n = m; } m", "Identifier not found: m"},
+		// Assignment updates the variable where it was declared
+		{"let mut total = 0; for (let i = 0; i < 4; i++) { total = total + i; } total", 6},
+		{"let mut n = 0; while (n < 3) { if (true) { n = n + 1; } } n", 3},
+		{"let mut x = 1; if (true) { let mut x = 2; x = 3; } x", 1},
+		{"let mut x = 1; let p = &x; if (true) { *p = 2; } x", 2},
+		// The loop header sees outer variables of the same name
+		{"let i = 10; let mut s = 0; for (let i = i - 2; i < 10; i++) { s = s + i; } s + i", 27},
+		{"let x = [1, 2]; let mut s = 0; for (x in x) { s = s + x; } s", 3},
+		// Closures keep the scope of the iteration that made them
+		{"let mut fs = []; for (i in 0..3) { let j = i * 2; fs = push(fs, fn() { j }); } fs[1]()", 2},
+		{"let mut fs = []; for (i in 0..3) { fs = push(fs, fn() { i }); } fs[2]()", 2},
+		{"let f = fn() { let mut a = 1; if (true) { let mut a = 2; a = 3; } a }; f()", 1},
+		{"let f = fn() { let mut a = 1; if (true) { a = 2; } a }; f()", 2},
 	}
 
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message for %q. expected=%q, got=%q", tt.input, expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestLegacyBlockScoping(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 5; if (true) { let x = 10; } x", 10},
+		{"if (true) { let y = 1; } y", 1},
+		{"for (x in [1, 2]) { } x", 2},
+		{"let mut total = 0; for (let i = 0; i < 4; i++) { total = total + i; } total", 6},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalVersion(tt.input, object.Version1)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -809,6 +1658,9 @@ func TestBuiltinTypeFunction(t *testing.T) {
 		expected string
 	}{
 		{`type(1)`, "INTEGER"},
+		{`type(1.5)`, "FLOAT"},
+		{`type(2 ** 64)`, "INTEGER"},
+		{`type(1 + 0.5)`, "FLOAT"},
 		{`type("hello")`, "STRING"},
 		{`type(true)`, "BOOLEAN"},
 		{`type([1, 2, 3])`, "ARRAY"},
@@ -817,6 +1669,7 @@ func TestBuiltinTypeFunction(t *testing.T) {
 		{`type(1 + 2)`, "INTEGER"},
 		{`type("hello" + " world")`, "STRING"},
 		{`type([1, 2, 3] + [4, 5, 6])`, "ARRAY"},
+		{`type(error("x"))`, "ERROR"},
 	}
 
 	for _, tt := range tests {
@@ -833,6 +1686,7 @@ func TestAssignmentExpression(t *testing.T) {
 	}{
 		{`let mut x = 10; x = 5; return x;`, 5},
 		{`let mut x = 10; x = 5; let mut x = 6; return x;`, 6},
+		{`let mut x = 1; let mut y = 2; x = y = 3; return x + y;`, 6},
 	}
 
 	for _, tt := range tests {
@@ -841,6 +1695,24 @@ func TestAssignmentExpression(t *testing.T) {
 	}
 }
 
+func TestAssignmentOfLogicalExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`let mut x = true; x = true && false; x`, false},
+		{`let mut x = false; x = false || true; x`, true},
+		{`let mut found = false; let y = true; found = found || y; found`, true},
+		{`let mut x = false; x = 1 < 2; x`, true},
+		{`let mut x = 0; (x = 1) == 1`, true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
 // Add this test function to test mutability in assignments
 func TestMutabilityInAssignments(t *testing.T) {
 	tests := []struct {
@@ -852,12 +1724,12 @@ func TestMutabilityInAssignments(t *testing.T) {
 		// Mutable variables can be reassigned
 		{"let mut x = 5; x = 10; return x;", 10, false, ""},
 		// // Immutable variables cannot be reassigned
-		{"let x = 5; x = 10; return x;", nil, true, "(line 1) Cannot assign to immutable variable: x"},
+		{"let x = 5; x = 10; return x;", nil, true, "Cannot assign to immutable variable: x"},
 		// // Nested scopes respect mutability
 		{"let mut x = 5; if (true) { x = 20; }; return x;", 20, false, ""},
-		{"let x = 5; if (true) { x = 20; }; return x;", nil, true, "(line 1) Cannot assign to immutable variable: x"},
+		{"let x = 5; if (true) { x = 20; }; return x;", nil, true, "Cannot assign to immutable variable: x"},
 		// // Function parameters are immutable by default
-		{"let f = fn(x) { x = 20; return x; }; f(5);", nil, true, "(line 1) Cannot assign to immutable variable: x"},
+		{"let f = fn(x) { x = 20; return x; }; f(5);", nil, true, "Cannot assign to immutable variable: x"},
 		// // Loop variables can be mutable
 		{"let mut sum = 0; for (let i = 0; i < 5; i++) { sum = sum + i; }; return sum;", 10, false, ""},
 		// // Complex example with multiple variables
@@ -889,7 +1761,7 @@ func TestMutabilityInAssignments(t *testing.T) {
 		{`
 			let numbers = [1, 2, 3];
 			numbers[0] = 10;
-		`, nil, true, "(line 3) Cannot assign to immutable variable: numbers"},
+		`, nil, true, "Cannot assign to immutable variable: numbers"},
 		{`
 			let mut numbers = [1, 2, 3];
 			numbers[0] = 10;
@@ -899,7 +1771,7 @@ func TestMutabilityInAssignments(t *testing.T) {
 			let mapping = {"a": 1, "b": 2, "c": 3};
 			mapping["a"] = 10;
 			return mapping["a"];
-		`, nil, true, "(line 3) Cannot assign to immutable variable: mapping"},
+		`, nil, true, "Cannot assign to immutable variable: mapping"},
 		{`
 			let mut mapping = {"a": 1, "b": 2, "c": 3};
 			mapping["a"] = 10;
@@ -931,6 +1803,287 @@ func TestMutabilityInAssignments(t *testing.T) {
 	}
 }
 
+func TestErrorSpans(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedStart string
+		expectedEnd   string
+	}{
+		{"5 + true;", "1:1", "1:9"},
+		{"let a = 1;\n  foobar", "2:3", "2:9"},
+		{"let x = 5;\nx = 10;", "2:1", "2:2"},
+		{"let f = fn(a) {\n  a + true\n};\nf(1)", "2:3", "2:11"},
+		{"[1, 2][\"a\"]", "1:1", "1:12"},
+		{"len(1, 2)", "1:1", "1:10"},
+		{"let x = 1;\nlet y = x + 10 / (x - 1);", "2:13", "2:24"},
+		{"let f = fn(a, b) { a };\nf(1)", "2:1", "2:5"},
+		{"let x = 1;\n  throw \"x\";", "2:3", "2:12"},
+		{"let e = try { throw \"x\" } catch (e) { e };\nthrow e", "1:15", "1:24"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("Expected error for input: %q, got %T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Span.Start.String() != tt.expectedStart || errObj.Span.End.String() != tt.expectedEnd {
+			t.Errorf("Wrong error span for %q. expected=%s-%s, got=%s",
+				tt.input, tt.expectedStart, tt.expectedEnd, errObj.Span)
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"1 / 0", nil},
+		{"let f = fn(a) {\n  a / 0\n};\nf(1)", []string{"f(1) called at 4:1"}},
+		{"let inner = fn(s, xs) { s + 1 };\nlet outer = fn(n) { [inner(\"a\", [n])] };\nouter(2)",
+			[]string{`inner("a", [2]) called at 2:22`, "outer(2) called at 3:1"}},
+		{"let fact = fn(n) { if (n == 0) { nope } n * fact(n - 1) };\nfact(2)",
+			[]string{"fact(0) called at 1:45", "fact(1) called at 1:45", "fact(2) called at 2:1"}},
+		{"let apply = fn(f) { f(1) };\napply(fn(x) { x + true })",
+			[]string{"<anonymous>(1) called at 1:21"}},
+		{"let mut a = [1];\na[0] = a;\nlet f = fn(x) { x + true };\nf(a)",
+			[]string{"f([[[[[[[[[[[[[[[[[[[[[...) called at 4:1"}},
+		{"let g = fn(x) { throw \"bad\" };\nmap([1], fn(x) { g(x) })",
+			[]string{"g(1) called at 2:18"}},
+		{"let r = map([1, 2], fn(x) { x / 0 })", []string{"<anonymous>(1) called at 1:9"}},
+		{"let f = fn(xs) { reduce(xs, fn(a, x) { [map([x], fn(y) { y / 0 })] }, 0) };\nf([1])",
+			[]string{"<anonymous>(1) called at 1:41", "<anonymous>(0, 1) called at 1:18", "f([1]) called at 2:1"}},
+		{"let f = fn() { throw \"bad\" };\nlet e = try { f() } catch (e) { e };\nlet g = fn() { throw e };\ng()",
+			[]string{"f() called at 2:15"}},
+
+		// A tail call takes over the frame of the call it is made from
+		{"let even = fn(n) { if (n == 0) { nope } odd(n - 1) };\nlet odd = fn(n) { even(n - 1) };\neven(4)",
+			[]string{"even(0) called at 2:19"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("Expected error for input: %q, got %T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		frames := errObj.Stack.Frames()
+		trace := make([]string, len(frames))
+		for i, frame := range frames {
+			trace[i] = frame.String()
+		}
+		if strings.Join(trace, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("Wrong stack trace for %q.\nexpected=%q\ngot=     %q", tt.input, tt.expected, trace)
+		}
+	}
+
+	caught := testEval("let f = fn(n) {\n  throw \"bad\"\n};\ntry { f(1) } catch (e) { e[\"stack\"] }")
+	if caught.Inspect() != "[at 2:3, f(1) called at 4:7]" {
+		t.Errorf("Wrong stack of caught error. got=%s", caught.Inspect())
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	input := "let f = fn(n) { 1 + f(n + 1) };\nf(0)"
+
+	env := object.NewVersionedEnvironment(object.LatestVersion)
+	env.SetMaxCallDepth(50)
+	evaluated := testEvalIn(input, env)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("Expected error, got %T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "Stack overflow: maximum call depth of 50 exceeded" {
+		t.Errorf("Wrong error message. got=%q", errObj.Message)
+	}
+	if errObj.Stack.Depth() != 50 {
+		t.Fatalf("Wrong stack trace depth. expected=50, got=%d", errObj.Stack.Depth())
+	}
+	frames := errObj.Stack.Frames()
+	if len(frames) != 20 || errObj.Stack.Omitted() != 30 {
+		t.Fatalf("Wrong calls kept. expected=20 of 50, got=%d, omitted=%d", len(frames), errObj.Stack.Omitted())
+	}
+	if frames[0].String() != "f(49) called at 1:21" || frames[9].String() != "f(40) called at 1:21" ||
+		frames[10].String() != "f(9) called at 1:21" || frames[19].String() != "f(0) called at 2:1" {
+		t.Errorf("Wrong stack trace. got=%s ... %s, %s ... %s", frames[0], frames[9], frames[10], frames[19])
+	}
+
+	// The default limit stops runaway recursion, and the error can be caught
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(n) { 1 + f(n + 1) }; try { f(0) } catch (e) { e[\"message\"] }",
+			"Stack overflow: maximum call depth of 10000 exceeded"},
+		{"let even = fn(n) { 1 + odd(n + 1) }; let odd = fn(n) { 1 + even(n + 1) }; try { even(0) } catch { 1 }", 1},
+		{"let f = fn(n) { 1 + f(n + 1) }; try { f(0) } catch { 1 }; let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) } }; g(9999)", 0},
+		// Catching it costs no more with a large argument in every frame
+		{`let mut big = []; for (i in 0..20000) { big = push(big, i); };
+		  let f = fn(xs, n) { 1 + f(xs, n + 1) }; try { f(big, 0) } catch (e) { len(e["stack"]) }`, 22},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("Wrong result for %q. expected=%q, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestReserveStack(t *testing.T) {
+	if testing.Short() {
+		t.Skip("recursing to the deepest limit takes several seconds")
+	}
+
+	// Each call nests through loops, a try block and nested expressions,
+	// which needs more Go stack than the runtime allows by default
+	input := `let f = fn(n) {
+	  let mut r = 0;
+	  for (x in [1]) {
+	    while (true) {
+	      try {
+	        if (true) { r = [1 + {"k": (2 * (f(n + 1) + 0) - 1)}["k"]][0]; };
+	      } catch (e) { throw e; } finally { r = r; };
+	      break;
+	    };
+	  };
+	  r
+	};
+	f(0)`
+
+	ReserveStack(object.MaxCallDepthLimit)
+	env := object.NewVersionedEnvironment(object.LatestVersion)
+	env.SetMaxCallDepth(object.MaxCallDepthLimit)
+	evaluated := testEvalIn(input, env)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("Expected error, got %T (%+v)", evaluated, evaluated)
+	}
+	expected := StackOverflow(object.MaxCallDepthLimit).Message
+	if errObj.Message != expected {
+		t.Errorf("Wrong error message. expected=%q, got=%q", expected, errObj.Message)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	// Each input recurses far deeper than the call depth limit allows
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(1000000, 0)", 1000000},
+		{`let even = fn(n) { if (n == 0) { return 1; } odd(n - 1) };
+		  let odd = fn(n) { if (n == 0) { return 0; } even(n - 1) };
+		  even(100001)`, 0},
+		{"let f = fn(n) { while (true) { if (n == 0) { return 7; } return f(n - 1); } }; f(100000)", 7},
+		{"let f = fn(n, unused) { if (n == 0) { 3 } else { f(n - 1, n, n) } }; f(100000, 0)", 3},
+		{"let f = fn(n) { if (n == 0) { len(\"abc\") } else { f(n - 1) } }; f(100000)", 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	// A call inside a try still nests, as the try must see its errors
+	evaluated := testEval("let f = fn(n) { try { f(n + 1) } catch { n } }; f(0)")
+	testIntegerObject(t, evaluated, int64(object.DefaultMaxCallDepth-1))
+}
+
+func TestEvalContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelTimeout()
+
+	tests := []struct {
+		ctx      context.Context
+		input    string
+		limits   object.Limits
+		expected string
+		halt     object.HaltReason
+	}{
+		{context.Background(), "while (true) { }", object.Limits{MaxSteps: 1000},
+			"Budget exceeded: more than 1000 steps", object.BudgetExceeded},
+		{context.Background(), "let f = fn() { f() }; f()", object.Limits{MaxSteps: 100000},
+			"Budget exceeded: more than 100000 steps", object.BudgetExceeded},
+		{context.Background(), "while (true) { }", object.Limits{Deadline: time.Now().Add(10 * time.Millisecond)},
+			"Budget exceeded: deadline passed", object.BudgetExceeded},
+		{timeout, "while (true) { }", object.Limits{},
+			"Budget exceeded: deadline passed", object.BudgetExceeded},
+		{cancelled, "while (true) { }", object.Limits{},
+			"Execution cancelled", object.Cancelled},
+		{context.Background(), "try { while (true) { } } catch { 1 } finally { 2 }", object.Limits{MaxSteps: 1000},
+			"Budget exceeded: more than 1000 steps", object.BudgetExceeded},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		evaluated := testEvalContext(tt.ctx, tt.input, env, tt.limits)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("Expected error for %q, got %T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected || errObj.Halt != tt.halt {
+			t.Errorf("Wrong error for %q. expected=%q (%d), got=%q (%d)",
+				tt.input, tt.expected, tt.halt, errObj.Message, errObj.Halt)
+		}
+
+		// The limits only apply to the evaluation they were given to
+		testIntegerObject(t, testEvalIn("let mut i = 0; while (i < 2000) { i++ }; i", env), 2000)
+	}
+
+	evaluated := testEvalContext(context.Background(), "let mut i = 0; while (i < 10) { i++ }; i",
+		object.NewEnvironment(), object.Limits{MaxSteps: 1000})
+	testIntegerObject(t, evaluated, 10)
+}
+
+func TestInternalErrorRecovery(t *testing.T) {
+	builtins["explode"] = &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			panic("boom")
+		},
+	}
+	defer delete(builtins, "explode")
+
+	evaluated := testEval("let a = 1;\nexplode();\na")
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("Expected error, got %T (%+v)", evaluated, evaluated)
+	}
+
+	if !errObj.Internal || errObj.Message != "Internal error: boom" {
+		t.Errorf("Wrong error. got=%q (internal=%t)", errObj.Message, errObj.Internal)
+	}
+
+	if errObj.Span.Start.String() != "2:1" {
+		t.Errorf("Wrong error position. got=%s", errObj.Span.Start)
+	}
+
+	evaluated = testEval(`try { explode() } catch { "caught" }`)
+	if errObj, ok := evaluated.(*object.Error); !ok || !errObj.Internal {
+		t.Errorf("Internal error was caught. got=%s", evaluated.Inspect())
+	}
+}
+
 // Test environment mutability tracking
 func TestEnvironmentMutabilityTracking(t *testing.T) {
 	env := object.NewEnvironment()
@@ -995,6 +2148,33 @@ func TestEnvironmentMutabilityTracking(t *testing.T) {
 	}
 }
 
+func TestEnvironmentAssign(t *testing.T) {
+	global := object.NewEnvironment()
+	global.Set("x", &object.Integer{Value: 1}, true)
+
+	function := object.NewEnclosedEnvironment(global)
+	block := object.NewBlockEnvironment(function)
+
+	// Assigning updates the variable where it was declared
+	block.Assign("x", &object.Integer{Value: 2})
+	if x, _ := global.Get("x"); x.(*object.Integer).Value != 2 {
+		t.Errorf("Assign did not update the declaring scope. got=%s", x.Inspect())
+	}
+	global.Set("x", &object.Integer{Value: 3}, true)
+	if x, _ := block.Get("x"); x.(*object.Integer).Value != 3 {
+		t.Errorf("Assign created a new binding for x. got=%s", x.Inspect())
+	}
+
+	// A name declared nowhere is bound in the function, not the block
+	block.Assign("y", &object.Integer{Value: 4})
+	if _, ok := function.Get("y"); !ok {
+		t.Errorf("Assign did not bind y in the function scope")
+	}
+	if _, ok := global.Get("y"); ok {
+		t.Errorf("Assign bound y in the global scope")
+	}
+}
+
 // Test assignment in complex expressions
 func TestAssignmentInComplexExpressions(t *testing.T) {
 	tests := []struct {
@@ -1167,6 +2347,55 @@ func TestPointerOperations(t *testing.T) {
 	}
 }
 
+func TestPointersAcrossScopes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		// A parameter with the same name does not hide the pointee
+		{`let mut x = 1; let p = &x; let f = fn(x) { *p }; f(99)`, 1},
+		{`let mut x = 1; let p = &x; let f = fn() { let mut x = 50; *p = 2; x }; f() * 10 + x`, 502},
+		// A pointer keeps its variable alive after the scope ends
+		{`let f = fn() { let mut x = 1; &x }; let p = f(); *p = 5; *p`, 5},
+		{`let f = fn(n) { let mut x = n; &x }; let a = f(1); let b = f(2); *a = 10; *a + *b`, 12},
+		{`let f = fn() { if (true) { let mut y = 7; return &y; } }; *f()`, 7},
+		// Each call of a recursive function has its own variables
+		{`let fill = fn(p, n) { if (n == 0) { return 0; }; *p = *p + n; fill(p, n - 1) }; let mut t = 0; fill(&t, 4); t`, 10},
+		{`let f = fn(n) { let mut x = n; let p = &x; if (n > 0) { f(n - 1); }; *p }; f(3)`, 3},
+		// Closures share the pointee
+		{`let mut x = 1; let p = &x; let g = fn() { *p = *p + 1; }; g(); g(); x`, 3},
+		// Redefining a variable in the same scope keeps its storage
+		{`let mut x = 1; let p = &x; let mut x = 2; *p`, 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	// The pointer shows the current value of its variable
+	evaluated := testEval("let mut x = 1; let p = &x; x = 2; p")
+	if evaluated.Inspect() != "&x (2)" {
+		t.Errorf("wrong pointer. got=%s", evaluated.Inspect())
+	}
+
+	// A variable that holds a pointer to itself shows without recursing
+	cycles := []struct {
+		input    string
+		expected string
+	}{
+		{"let mut x = 0; let p = &x; x = p; x", "&x (&x)"},
+		{"let mut x = 0; let p = &x; x = p; **p", "&x (&x)"},
+		{`let mut x = 0; let p = &x; x = p; "${x}"`, "&x (&x)"},
+		{"let mut x = 0; let p = &x; x = [1, {\"p\": p}]; p", "&x ([1, {p: &x}])"},
+	}
+	for _, tt := range cycles {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestPointerErrors(t *testing.T) {
 	tests := []struct {
 		input           string
@@ -1193,6 +2422,15 @@ func TestPointerErrors(t *testing.T) {
 			`,
 			"Cannot dereference non-pointer value: INTEGER",
 		},
+		{
+			`
+			let x = 1;
+			let p = &x;
+			let f = fn() { let mut x = 5; *p = 3; };
+			f();
+			`,
+			"Cannot assign to immutable variable: x",
+		},
 	}
 
 	for _, tt := range tests {
@@ -1211,6 +2449,74 @@ func TestPointerErrors(t *testing.T) {
 	}
 }
 
+func TestElementPointers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`let mut arr = [1, 2, 3]; let p = &arr[1]; *p = 20; arr[1]`, 20},
+		{`let arr = [1, 2, 3]; let p = &arr[-1]; *p`, 3},
+		{`let mut config = {"port": 80}; let p = &config["port"]; *p = 8080; config["port"]`, 8080},
+		// Nested containers
+		{`let mut grid = [[1, 2], [3, 4]]; let p = &grid[1][0]; *p = 30; grid[1][0]`, 30},
+		{`let mut h = {"a": {"b": 1}}; let p = &h["a"]["b"]; *p = 2; h["a"]["b"]`, 2},
+		// Through another pointer
+		{`let mut arr = [1, 2]; let q = &arr; let p = &(*q)[0]; *p = 5; arr[0]`, 5},
+		{`let mut arr = [1, 2]; let q = &arr; let p = &q[1]; *p = 7; arr[1]`, 7},
+		// The element is looked up in the variable on every access
+		{`let mut arr = [1, 2]; let p = &arr[0]; arr = [9, 8]; *p`, 9},
+		{`let set = fn(p, v) { *p = v; }; let mut xs = [0, 0]; set(&xs[1], 4); xs[1]`, 4},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	inspected := []struct {
+		input    string
+		expected string
+	}{
+		{`let mut h = {"port": 80}; &h["port"]`, `&h["port"] (80)`},
+		{`let mut h = {"port": 80}; let p = &h["port"]; h = delete(h, "port"); p`, `&h["port"] (missing)`},
+		{`let grid = [[1, 2]]; &grid[0][1]`, `&grid[0][1] (2)`},
+	}
+
+	for _, tt := range inspected {
+		if evaluated := testEval(tt.input); evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong pointer for %q. expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestElementPointerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let arr = [1, 2]; let p = &arr[0]; *p = 5`, "Cannot assign to immutable variable: arr"},
+		{`let arr = [1]; &arr[3]`, "Array index out of bounds: 3"},
+		{`let h = {"a": 1}; &h["b"]`, "Cannot take address of missing hash key: b"},
+		{`let h = {"a": 1}; &h[[1]]`, "Unusable as hash key: ARRAY"},
+		{`let mut h = {"port": 80}; let p = &h["port"]; h = delete(h, "port"); *p`, "Pointer target no longer exists: h[\"port\"]"},
+		{`let mut h = {"port": 80}; let p = &h["port"]; h = delete(h, "port"); *p = 1`, "Pointer target no longer exists: h[\"port\"]"},
+		{`let mut a = [1, 2, 3]; let p = &a[2]; a = [1]; *p`, "Pointer target no longer exists: a[2]"},
+		{`let x = 5; &x[0]`, "Cannot index into type: INTEGER"},
+		{`let f = fn() { [1] }; &f()[0]`, "Cannot take address of non-identifier expression"},
+		{`let n = 5; &(*n)[0]`, "Cannot dereference non-pointer value: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q", tt.input)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message for %q. expected=%q, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
 func TestPointerArrayAssignment(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -1,6 +1,7 @@
 package evaluator
 
 import (
+	"context"
 	"testing"
 
 	"ember_lang/ember_lang/lexer"
@@ -9,14 +10,29 @@ import (
 )
 
 func testEval(input string) object.Object {
+	return testEvalVersion(input, object.LatestVersion)
+}
+
+func testEvalVersion(input string, version object.LanguageVersion) object.Object {
+	return testEvalIn(input, object.NewVersionedEnvironment(version))
+}
+
+func testEvalIn(input string, env *object.Environment) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
-	env := object.NewEnvironment()
 
 	return Eval(program, env)
 }
 
+func testEvalContext(ctx context.Context, input string, env *object.Environment, limits object.Limits) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	return EvalContext(ctx, program, env, limits)
+}
+
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	result, ok := obj.(*object.Integer)
 	if !ok {
@@ -31,6 +47,20 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got: %g, expected: %g",
+			result.Value, expected)
+		return false
+	}
+	return true
+}
+
 func testStringObject(t *testing.T, obj object.Object, expected string) bool {
 	result, ok := obj.(*object.String)
 	if !ok {
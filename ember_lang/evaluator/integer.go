@@ -0,0 +1,264 @@
+package evaluator
+
+import (
+	"ember_lang/ember_lang/object"
+	"math"
+	"math/big"
+)
+
+// Integer arithmetic is exact: a result that does not fit in 64 bits is
+// promoted to an *object.BigInt, and BigInt results that fit again are demoted
+// to an *object.Integer. Under object.CheckedIntegers a promoted result is
+// reported as an overflow error instead (see integerOverflow).
+
+// maxIntegerBits bounds the size of the results of << and **, so a typo such
+// as 1 << 10000000000 fails instead of exhausting memory.
+const maxIntegerBits = 1 << 24
+
+func evalIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal := left.(*object.Integer).Value
+	rightVal := right.(*object.Integer).Value
+
+	switch operator {
+	case "+":
+		if sum, ok := addInt64(leftVal, rightVal); ok {
+			return &object.Integer{Value: sum}
+		}
+	case "-":
+		if difference, ok := subInt64(leftVal, rightVal); ok {
+			return &object.Integer{Value: difference}
+		}
+	case "*":
+		if product, ok := mulInt64(leftVal, rightVal); ok {
+			return &object.Integer{Value: product}
+		}
+	case "/":
+		if rightVal == 0 {
+			return newError("Division by zero: %d / 0", leftVal)
+		}
+		if leftVal != math.MinInt64 || rightVal != -1 {
+			return &object.Integer{Value: leftVal / rightVal}
+		}
+	case "%":
+		if rightVal == 0 {
+			return newError("Division by zero: %d %% 0", leftVal)
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "**":
+		if rightVal < 0 {
+			return newError("Negative exponent: %d ** %d", leftVal, rightVal)
+		}
+		if power, ok := integerPower(leftVal, rightVal); ok {
+			return &object.Integer{Value: power}
+		}
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<":
+		if rightVal < 0 {
+			return newError("Negative shift count: %d %s %d", leftVal, operator, rightVal)
+		}
+		if shifted, ok := shiftLeftInt64(leftVal, rightVal); ok {
+			return &object.Integer{Value: shifted}
+		}
+	case ">>":
+		if rightVal < 0 {
+			return newError("Negative shift count: %d %s %d", leftVal, operator, rightVal)
+		}
+		return &object.Integer{Value: leftVal >> uint64(rightVal)}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	default:
+		return newError("Unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+
+	// The result overflowed 64 bits
+	return evalBigIntegerInfixExpression(operator, left, right)
+}
+
+// isBigIntegerOperation reports whether an operator applies to two integers
+// of which at least one is a BigInt.
+func isBigIntegerOperation(left object.Object, right object.Object) bool {
+	_, leftInteger := bigInteger(left)
+	_, rightInteger := bigInteger(right)
+	return leftInteger && rightInteger &&
+		(left.Type() == object.BIGINT_OBJ || right.Type() == object.BIGINT_OBJ)
+}
+
+func evalBigIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal, _ := bigInteger(left)
+	rightVal, _ := bigInteger(right)
+	result := new(big.Int)
+
+	switch operator {
+	case "+":
+		result.Add(leftVal, rightVal)
+	case "-":
+		result.Sub(leftVal, rightVal)
+	case "*":
+		result.Mul(leftVal, rightVal)
+	case "/":
+		if rightVal.Sign() == 0 {
+			return newError("Division by zero: %s / 0", leftVal)
+		}
+		result.Quo(leftVal, rightVal)
+	case "%":
+		if rightVal.Sign() == 0 {
+			return newError("Division by zero: %s %% 0", leftVal)
+		}
+		result.Rem(leftVal, rightVal)
+	case "**":
+		if rightVal.Sign() < 0 {
+			return newError("Negative exponent: %s ** %s", leftVal, rightVal)
+		}
+		if leftVal.CmpAbs(big.NewInt(1)) > 0 &&
+			(!rightVal.IsInt64() || rightVal.Int64() > maxIntegerBits/int64(leftVal.BitLen())) {
+			return newError("Integer too large: %s ** %s", leftVal, rightVal)
+		}
+		result.Exp(leftVal, rightVal, nil)
+	case "&":
+		result.And(leftVal, rightVal)
+	case "|":
+		result.Or(leftVal, rightVal)
+	case "^":
+		result.Xor(leftVal, rightVal)
+	case "<<":
+		if rightVal.Sign() < 0 {
+			return newError("Negative shift count: %s %s %s", leftVal, operator, rightVal)
+		}
+		if leftVal.Sign() != 0 && (!rightVal.IsInt64() || rightVal.Int64() > maxIntegerBits) {
+			return newError("Integer too large: %s %s %s", leftVal, operator, rightVal)
+		}
+		if leftVal.Sign() != 0 {
+			result.Lsh(leftVal, uint(rightVal.Int64()))
+		}
+	case ">>":
+		if rightVal.Sign() < 0 {
+			return newError("Negative shift count: %s %s %s", leftVal, operator, rightVal)
+		}
+		if !rightVal.IsInt64() || rightVal.Int64() > int64(leftVal.BitLen()) {
+			// Every bit is shifted out, leaving only the sign
+			result.SetInt64(int64(min(leftVal.Sign(), 0)))
+		} else {
+			result.Rsh(leftVal, uint(rightVal.Int64()))
+		}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) != 0)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) >= 0)
+	default:
+		return newError("Unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+
+	return normalizeInteger(result)
+}
+
+// bigInteger returns the value of an Integer or BigInt as a *big.Int. The
+// BigInt's own value is returned, so callers must not modify it.
+func bigInteger(obj object.Object) (*big.Int, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return big.NewInt(obj.Value), true
+	case *object.BigInt:
+		return obj.Value, true
+	default:
+		return nil, false
+	}
+}
+
+// normalizeInteger returns value as an Integer when it fits in 64 bits and
+// as a BigInt otherwise.
+func normalizeInteger(value *big.Int) object.Object {
+	if value.IsInt64() {
+		return &object.Integer{Value: value.Int64()}
+	}
+	return &object.BigInt{Value: value}
+}
+
+// integerOverflow returns the error for a result that needed a BigInt when
+// mode is object.CheckedIntegers, and nil otherwise.
+func integerOverflow(result object.Object, mode object.IntegerMode) *object.Error {
+	if mode != object.CheckedIntegers {
+		return nil
+	}
+
+	integer, ok := result.(*object.BigInt)
+	if !ok {
+		return nil
+	}
+	return newError("Integer overflow: %s does not fit in 64 bits", integer.Inspect())
+}
+
+func addInt64(left int64, right int64) (int64, bool) {
+	sum := left + right
+	return sum, (sum > left) == (right > 0)
+}
+
+func subInt64(left int64, right int64) (int64, bool) {
+	difference := left - right
+	return difference, (difference < left) == (right > 0)
+}
+
+func mulInt64(left int64, right int64) (int64, bool) {
+	if left == 0 || right == 0 {
+		return 0, true
+	}
+	product := left * right
+	if product/right != left || (right == -1 && left == math.MinInt64) {
+		return 0, false
+	}
+	return product, true
+}
+
+func shiftLeftInt64(value int64, count int64) (int64, bool) {
+	if value == 0 {
+		return 0, true
+	}
+	if count >= 63 {
+		return 0, false
+	}
+	shifted := value << uint64(count)
+	return shifted, shifted>>uint64(count) == value
+}
+
+// integerPower computes base ** exponent by repeated squaring, reporting
+// whether the result fits in 64 bits.
+func integerPower(base int64, exponent int64) (int64, bool) {
+	result := int64(1)
+	for exponent > 0 {
+		var ok bool
+		if exponent&1 == 1 {
+			if result, ok = mulInt64(result, base); !ok {
+				return 0, false
+			}
+		}
+		exponent >>= 1
+		if exponent > 0 {
+			if base, ok = mulInt64(base, base); !ok {
+				return 0, false
+			}
+		}
+	}
+	return result, true
+}
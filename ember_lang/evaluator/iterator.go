@@ -0,0 +1,184 @@
+package evaluator
+
+import (
+	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/object"
+	"sort"
+)
+
+func evalForInExpression(node *ast.ForInExpression, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	iterator := newIterator(iterable, node.Key != nil)
+	if isError(iterator) {
+		return iterator
+	}
+	next := iterator.(*object.Iterator).Next
+
+	label := labelName(node.Label)
+
+	for {
+		key, value, ok := next()
+		if !ok {
+			break
+		}
+
+		// Each iteration binds the loop variables in a fresh header scope
+		scope := blockScope(env)
+		if node.Key != nil {
+			scope.Set(node.Key.Value, key, false)
+		}
+		scope.Set(node.Value.Value, value, false)
+
+		result := Eval(node.Body, scope)
+		if stop, value := loopControl(result, label); stop {
+			return value
+		}
+	}
+
+	return NULL
+}
+
+func evalRangeExpression(start object.Object, end object.Object, inclusive bool) object.Object {
+	startInteger, startOk := start.(*object.Integer)
+	endInteger, endOk := end.(*object.Integer)
+
+	if !startOk || !endOk {
+		operator := ".."
+		if inclusive {
+			operator = "..="
+		}
+		if _, ok := bigInteger(start); ok {
+			if _, ok := bigInteger(end); ok {
+				return newError("Range bounds must fit in 64 bits: %s%s%s", start.Inspect(), operator, end.Inspect())
+			}
+		}
+		return newError("Range bounds must be integers: %s%s%s", start.Type(), operator, end.Type())
+	}
+
+	return &object.Range{Start: startInteger.Value, End: endInteger.Value, Inclusive: inclusive}
+}
+
+// newIterator returns an iterator over the elements of an array, the pairs
+// of a hash, the characters of a string or the integers of a range. Keys are
+// positions, except for hashes where they are the hash keys. A loop that
+// binds a single name gets the values, or the keys of a hash; withKeys tells
+// which kind of loop the iterator is for.
+func newIterator(iterable object.Object, withKeys bool) object.Object {
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		return sliceIterator(len(iterable.Elements), func(i int) (object.Object, object.Object) {
+			return &object.Integer{Value: int64(i)}, iterable.Elements[i]
+		})
+
+	case *object.Hash:
+		pairs := sortedPairs(iterable)
+		return sliceIterator(len(pairs), func(i int) (object.Object, object.Object) {
+			if !withKeys {
+				return nil, pairs[i].Key
+			}
+			return pairs[i].Key, pairs[i].Value
+		})
+
+	case *object.String:
+		characters := []rune(iterable.Value)
+		return sliceIterator(len(characters), func(i int) (object.Object, object.Object) {
+			return &object.Integer{Value: int64(i)}, &object.String{Value: string(characters[i])}
+		})
+
+	case *object.Range:
+		return rangeIterator(iterable)
+
+	default:
+		return newError("Not iterable: %s", iterable.Type())
+	}
+}
+
+// sliceIterator iterates over positions 0 to length-1, reading each element
+// only when the loop reaches it.
+func sliceIterator(length int, at func(int) (object.Object, object.Object)) *object.Iterator {
+	position := 0
+	return &object.Iterator{
+		Next: func() (object.Object, object.Object, bool) {
+			if position >= length {
+				return nil, nil, false
+			}
+			key, value := at(position)
+			position++
+			return key, value, true
+		},
+	}
+}
+
+func rangeIterator(r *object.Range) *object.Iterator {
+	current, position := r.Start, int64(0)
+	done := r.Start > r.End || (r.Start == r.End && !r.Inclusive)
+
+	return &object.Iterator{
+		Next: func() (object.Object, object.Object, bool) {
+			if done {
+				return nil, nil, false
+			}
+
+			key, value := &object.Integer{Value: position}, &object.Integer{Value: current}
+
+			// Stop at the end without stepping past it, which could overflow
+			if current == r.End || (current+1 == r.End && !r.Inclusive) {
+				done = true
+			}
+			current++
+			position++
+
+			return key, value, true
+		},
+	}
+}
+
+// sortedPairs returns the pairs of a hash ordered by key, so iteration does
+// not depend on Go's map order: booleans first, then floats, integers and strings.
+// Integers are ordered by value, whether or not they fit in 64 bits.
+func sortedPairs(hash *object.Hash) []object.HashPair {
+	pairs := make([]object.HashPair, 0, len(hash.Pairs))
+	for _, pair := range hash.Pairs {
+		pairs = append(pairs, pair)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i].Key, pairs[j].Key
+		if keyOrder(a) != keyOrder(b) {
+			return keyOrder(a) < keyOrder(b)
+		}
+		if isBigIntegerOperation(a, b) {
+			x, _ := bigInteger(a)
+			y, _ := bigInteger(b)
+			return x.Cmp(y) < 0
+		}
+
+		switch a := a.(type) {
+		case *object.Integer:
+			return a.Value < b.(*object.Integer).Value
+		case *object.Float:
+			return a.Value < b.(*object.Float).Value
+		case *object.String:
+			return a.Value < b.(*object.String).Value
+		case *object.Boolean:
+			return !a.Value && b.(*object.Boolean).Value
+		default:
+			return false
+		}
+	})
+
+	return pairs
+}
+
+// keyOrder groups hash keys by type for sortedPairs, counting a BigInt as an
+// integer.
+func keyOrder(key object.Object) object.ObjectType {
+	if key.Type() == object.BIGINT_OBJ {
+		return object.INTEGER_OBJ
+	}
+	return key.Type()
+}
@@ -0,0 +1,143 @@
+package evaluator
+
+import (
+	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/object"
+)
+
+func evalPointerReferenceExpression(node *ast.PointerReferenceExpression, env *object.Environment) object.Object {
+	return evalAddress(node.Right, env)
+}
+
+// evalAddress returns a pointer to the variable, array element or hash entry
+// that target names, such as x, arr[3], grid[1][2] or (*p)["port"].
+func evalAddress(target ast.Expression, env *object.Environment) object.Object {
+	switch target := target.(type) {
+	case *ast.Identifier:
+		// Check if the variable exists
+		cell, exists := env.Cell(target.Value)
+		if !exists {
+			return newError("Cannot take address of undefined variable: %s", target.Value)
+		}
+
+		// Create a pointer to the variable's cell
+		return &object.Pointer{Name: target.Value, Cell: cell}
+
+	case *ast.IndexExpression:
+		container := evalContainerAddress(target.Left, env)
+		if isError(container) {
+			return container
+		}
+
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+
+		return elementPointer(container.(*object.Pointer), index)
+	}
+
+	return newError("Cannot take address of non-identifier expression")
+}
+
+// evalContainerAddress returns a pointer to the container of an element
+// pointer. A dereferenced pointer is its own container.
+func evalContainerAddress(container ast.Expression, env *object.Environment) object.Object {
+	deref, ok := container.(*ast.PointerDereferenceExpression)
+	if !ok {
+		return evalAddress(container, env)
+	}
+
+	pointer := Eval(deref.Right, env)
+	if isError(pointer) {
+		return pointer
+	}
+	if _, ok := pointer.(*object.Pointer); !ok {
+		return newError("Cannot dereference non-pointer value: %s", pointer.Type())
+	}
+
+	return pointer
+}
+
+// elementPointer returns a pointer to the element or entry at index of the
+// container that pointer refers to. Indexing a pointer indexes its target,
+// as p[1] does.
+func elementPointer(pointer *object.Pointer, index object.Object) object.Object {
+	container := dereference(pointer)
+	if isError(container) {
+		return container
+	}
+
+	switch container := container.(type) {
+	case *object.Pointer:
+		return elementPointer(container, index)
+
+	case *object.Array:
+		position, ok := index.(*object.Integer)
+		if !ok {
+			return newError("Array index must be an integer")
+		}
+
+		// Negative indices count from the end, and keep pointing at the
+		// element they started at
+		idx := position.Value
+		if idx < 0 {
+			idx = int64(len(container.Elements)) + idx
+		}
+		if idx < 0 || idx >= int64(len(container.Elements)) {
+			return newError("Array index out of bounds: %d", position.Value)
+		}
+		index = &object.Integer{Value: idx}
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("Unusable as hash key: %s", index.Type())
+		}
+		if _, ok := container.Pairs[key.HashKey()]; !ok {
+			return newError("Cannot take address of missing hash key: %s", index.Inspect())
+		}
+
+	default:
+		return newError("Cannot index into type: %s", container.Type())
+	}
+
+	path := append(pointer.Path[:len(pointer.Path):len(pointer.Path)], index)
+	return &object.Pointer{Name: pointer.Name, Cell: pointer.Cell, Path: path}
+}
+
+func evalPointerDereferenceExpression(node *ast.PointerDereferenceExpression, env *object.Environment) object.Object {
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+
+	if pointer, ok := right.(*object.Pointer); ok {
+		return dereference(pointer)
+	}
+
+	return newError("Cannot dereference non-pointer value: %s", right.Type())
+}
+
+// dereference returns the value pointer refers to, or an error if its
+// element or entry no longer exists.
+func dereference(pointer *object.Pointer) object.Object {
+	value, ok := pointer.Load()
+	if ok {
+		return value
+	}
+
+	if len(pointer.Path) == 0 {
+		return newError("Pointer references undefined variable: %s", pointer.Name)
+	}
+	return newError("Pointer target no longer exists: %s", pointer.Target())
+}
+
+// storeThroughPointer writes value to what pointer refers to. The caller
+// checks that the variable is mutable.
+func storeThroughPointer(pointer *object.Pointer, value object.Object) object.Object {
+	if !pointer.Store(value) {
+		return newError("Pointer target no longer exists: %s", pointer.Target())
+	}
+	return value
+}
@@ -0,0 +1,112 @@
+package evaluator
+
+import (
+	"ember_lang/ember_lang/object"
+)
+
+// The functions below expose the evaluator's operator semantics and builtins
+// to the bytecode VM, so both engines agree on results and error messages.
+
+func EvalPrefix(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func EvalInfix(operator string, left object.Object, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+func EvalIndex(left object.Object, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// EvalSlice returns left[start:end]; a NULL bound was left out.
+func EvalSlice(left object.Object, start object.Object, end object.Object) object.Object {
+	return evalSlice(left, start, end)
+}
+
+func EvalIndexAssignment(left object.Object, index object.Object, right object.Object) object.Object {
+	return evalIndexAssignment(left, index, right)
+}
+
+func EvalIncrement(left object.Object) object.Object {
+	return evalIncrementExpression(left)
+}
+
+func EvalRange(start object.Object, end object.Object, inclusive bool) object.Object {
+	return evalRangeExpression(start, end, inclusive)
+}
+
+// Interpolate joins the values of the parts of an interpolated string.
+func Interpolate(parts []object.Object) *object.String {
+	return interpolate(parts)
+}
+
+// NewIterator returns an *object.Iterator for a for-in loop over iterable,
+// or an error if it cannot be iterated.
+func NewIterator(iterable object.Object, withKeys bool) object.Object {
+	return newIterator(iterable, withKeys)
+}
+
+// ElementPointer returns a pointer to the element or entry at index of what
+// base points to, for &x[i].
+func ElementPointer(base object.Object, index object.Object) object.Object {
+	pointer, ok := base.(*object.Pointer)
+	if !ok {
+		return newError("Cannot dereference non-pointer value: %s", base.Type())
+	}
+	return elementPointer(pointer, index)
+}
+
+func Dereference(pointer *object.Pointer) object.Object {
+	return dereference(pointer)
+}
+
+func StoreThroughPointer(pointer *object.Pointer, value object.Object) object.Object {
+	return storeThroughPointer(pointer, value)
+}
+
+// IntegerOverflow returns the error for a result that does not fit in 64 bits
+// under object.CheckedIntegers, and nil otherwise.
+func IntegerOverflow(result object.Object, mode object.IntegerMode) *object.Error {
+	return integerOverflow(result, mode)
+}
+
+// Throw returns the error raised by throwing value.
+func Throw(value object.Object) *object.Error {
+	return throw(value)
+}
+
+// Catch returns the value a catch clause binds for err, and false if err may
+// not be caught.
+func Catch(err *object.Error) (*object.ErrorValue, bool) {
+	if !catchable(err) {
+		return nil, false
+	}
+	return catch(err), true
+}
+
+// StackOverflow returns the error for a call nested deeper than maxDepth.
+func StackOverflow(maxDepth int) *object.Error {
+	return stackOverflow(maxDepth)
+}
+
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+func NativeBoolToBooleanObject(input bool) *object.Boolean {
+	return nativeBoolToBooleanObject(input)
+}
+
+func NewError(format string, a ...interface{}) *object.Error {
+	return newError(format, a...)
+}
+
+func NewInternalError(recovered interface{}) *object.Error {
+	return newInternalError(recovered)
+}
+
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
@@ -0,0 +1,95 @@
+package evaluator
+
+import (
+	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/object"
+)
+
+func evalSliceExpression(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	bounds := [2]object.Object{NULL, NULL}
+	for i, bound := range []ast.Expression{node.Start, node.End} {
+		if bound == nil {
+			continue
+		}
+		bounds[i] = Eval(bound, env)
+		if isError(bounds[i]) {
+			return bounds[i]
+		}
+	}
+
+	return evalSlice(left, bounds[0], bounds[1])
+}
+
+// evalSlice returns left[start:end] for a string or an array. A NULL bound
+// was left out and stands for the start or the end. Negative bounds count
+// from the end, and bounds outside the string or array are clamped to it, so
+// slicing never fails on a bad position: it returns what lies in range.
+func evalSlice(left object.Object, start object.Object, end object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.String:
+		characters := []rune(left.Value)
+		from, to, err := sliceBounds(start, end, len(characters))
+		if err != nil {
+			return err
+		}
+		return &object.String{Value: string(characters[from:to])}
+
+	case *object.Array:
+		from, to, err := sliceBounds(start, end, len(left.Elements))
+		if err != nil {
+			return err
+		}
+		elements := make([]object.Object, to-from)
+		copy(elements, left.Elements[from:to])
+		return &object.Array{Elements: elements}
+
+	case *object.Pointer:
+		value := dereference(left)
+		if isError(value) {
+			return value
+		}
+		return evalSlice(value, start, end)
+
+	default:
+		return newError("Slice operator not supported: %s", left.Type())
+	}
+}
+
+// sliceBounds resolves the bounds of a slice of length elements. An end
+// before the start gives an empty slice.
+func sliceBounds(start object.Object, end object.Object, length int) (int, int, *object.Error) {
+	from, err := sliceBound(start, 0, length)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := sliceBound(end, length, length)
+	if err != nil {
+		return 0, 0, err
+	}
+	return from, max(from, to), nil
+}
+
+func sliceBound(bound object.Object, omitted int, length int) (int, *object.Error) {
+	switch bound := bound.(type) {
+	case *object.Null:
+		return omitted, nil
+	case *object.Integer:
+		value := bound.Value
+		if value < 0 {
+			value += int64(length)
+		}
+		return int(min(max(value, 0), int64(length))), nil
+	case *object.BigInt:
+		if bound.Value.Sign() < 0 {
+			return 0, nil
+		}
+		return length, nil
+	default:
+		return 0, newError("Slice bounds must be integers, got: %s", bound.Type())
+	}
+}
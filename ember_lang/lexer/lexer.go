@@ -1,15 +1,29 @@
 package lexer
 
 import (
+	"ember_lang/ember_lang/diagnostic"
 	"ember_lang/ember_lang/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// Lexer reads UTF-8 source text. Offsets count bytes, while columns count
+// characters (code points), so they match what an editor shows.
 type Lexer struct {
 	input        string
 	position     int  // current pos in input
 	readPosition int  // current reading position
-	ch           byte // current char under examination
+	ch           rune // current char under examination
 	lineNumber   int  // current line number
+	lineStart    int  // offset of the first char of the current line
+
+	// interpolations holds, for each ${ of a string that is still open, how
+	// many braces have been opened inside it, so its closing } can be told
+	// apart from the } of a block or hash.
+	interpolations []int
+
+	diagnostics []*diagnostic.Diagnostic
 }
 
 func (l *Lexer) NextToken() token.Token {
@@ -17,6 +31,8 @@ func (l *Lexer) NextToken() token.Token {
 
 	l.skipWhitespace()
 
+	start := l.currentPosition()
+
 	switch l.ch {
 	case '=':
 		switch l.peekChar() {
@@ -25,7 +41,7 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch)}
 		default:
-			tok = newToken(token.ASSIGN, l.ch, l.lineNumber)
+			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
 		if l.peekChar() == '+' {
@@ -33,67 +49,133 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			tok = token.Token{Type: token.INCREMENT, Literal: string(ch) + string(l.ch)}
 		} else {
-			tok = newToken(token.PLUS, l.ch, l.lineNumber)
+			tok = newToken(token.PLUS, l.ch)
 		}
 	case '(':
-		tok = newToken(token.LPAREN, l.ch, l.lineNumber)
+		tok = newToken(token.LPAREN, l.ch)
 	case ')':
-		tok = newToken(token.RPAREN, l.ch, l.lineNumber)
+		tok = newToken(token.RPAREN, l.ch)
 	case '{':
-		tok = newToken(token.LBRACE, l.ch, l.lineNumber)
+		if depth := len(l.interpolations); depth > 0 {
+			l.interpolations[depth-1]++
+		}
+		tok = newToken(token.LBRACE, l.ch)
 	case '}':
-		tok = newToken(token.RBRACE, l.ch, l.lineNumber)
+		if depth := len(l.interpolations); depth > 0 {
+			if l.interpolations[depth-1] == 0 {
+				tok.Literal, tok.Type = l.readStringContinuation(start)
+				break
+			}
+			l.interpolations[depth-1]--
+		}
+		tok = newToken(token.RBRACE, l.ch)
 	case '[':
-		tok = newToken(token.LBRACKET, l.ch, l.lineNumber)
+		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
-		tok = newToken(token.RBRACKET, l.ch, l.lineNumber)
+		tok = newToken(token.RBRACKET, l.ch)
+	case '.':
+		if l.peekChar() == '.' {
+			l.readChar()
+			if l.peekChar() == '=' {
+				l.readChar()
+				tok = token.Token{Type: token.DOTDOTEQ, Literal: "..="}
+			} else {
+				tok = token.Token{Type: token.DOTDOT, Literal: ".."}
+			}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+			l.report(diagnostic.New(diagnostic.IllegalCharacter, l.spanFrom(start), "illegal character %q", l.ch).
+				WithHint("use .. or ..= for a range"))
+		}
 	case ',':
-		tok = newToken(token.COMMA, l.ch, l.lineNumber)
+		tok = newToken(token.COMMA, l.ch)
 	case ';':
-		tok = newToken(token.SEMICOLON, l.ch, l.lineNumber)
+		tok = newToken(token.SEMICOLON, l.ch)
 	case ':':
-		tok = newToken(token.COLON, l.ch, l.lineNumber)
+		tok = newToken(token.COLON, l.ch)
 	case '-':
-		tok = newToken(token.MINUS, l.ch, l.lineNumber)
+		tok = newToken(token.MINUS, l.ch)
 	case '/':
 		if l.peekChar() == '/' {
 			tok.Type = token.COMMENT
 			tok.Literal = l.readComment()
+			tok.Span = token.Span{Start: start, End: l.currentPosition()}
 			return tok
 		} else {
-			tok = newToken(token.SLASH, l.ch, l.lineNumber)
+			tok = newToken(token.SLASH, l.ch)
 		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch, l.lineNumber)
+		if l.peekChar() == '*' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.POWER, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
+	case '^':
+		tok = newToken(token.CARET, l.ch)
+	case '~':
+		tok = newToken(token.TILDE, l.ch)
 	case '<':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			tok = token.Token{Type: token.LTE, Literal: string(ch) + string(l.ch)}
+		} else if l.peekChar() == '<' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.SHL, Literal: string(ch) + string(l.ch)}
 		} else {
-			tok = newToken(token.LT, l.ch, l.lineNumber)
+			tok = newToken(token.LT, l.ch)
 		}
 	case '>':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			tok = token.Token{Type: token.GTE, Literal: string(ch) + string(l.ch)}
+		} else if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.SHR, Literal: string(ch) + string(l.ch)}
 		} else {
-			tok = newToken(token.GT, l.ch, l.lineNumber)
+			tok = newToken(token.GT, l.ch)
 		}
 	case '"':
+		if l.peekChar() == '"' && l.peekCharAt(2) == '"' {
+			tok.Type = token.STRING
+			tok.Literal = l.readMultilineString(start)
+		} else {
+			tok.Literal, tok.Type = l.readString(start)
+		}
+	case '`':
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok.Literal = l.readRawString(start)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			tok = token.Token{Type: token.NEQ, Literal: string(ch) + string(l.ch)}
 		} else {
-			tok = newToken(token.BANG, l.ch, l.lineNumber)
+			tok = newToken(token.BANG, l.ch)
 		}
 	case '&':
-		tok = newToken(token.AMPERSAND, l.ch, l.lineNumber)
+		if l.peekChar() == '&' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.AND, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.AMPERSAND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.OR, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.PIPE, l.ch)
+		}
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -101,23 +183,40 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdentifier(tok.Literal)
-			tok.LineNumber = l.lineNumber
+			tok.Span = token.Span{Start: start, End: l.currentPosition()}
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
-			tok.LineNumber = l.lineNumber
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Span = token.Span{Start: start, End: l.currentPosition()}
 			return tok
 		}
-		tok = newToken(token.ILLEGAL, l.ch, l.lineNumber)
+		tok = newToken(token.ILLEGAL, l.ch)
+		if l.ch == utf8.RuneError && l.readPosition-l.position == 1 {
+			tok.Literal = l.input[l.position:l.readPosition]
+			l.report(diagnostic.New(diagnostic.IllegalCharacter, l.spanFrom(start), "invalid UTF-8 byte %#x", l.input[l.position]).
+				WithNote("source files must be encoded in UTF-8"))
+		} else {
+			l.report(diagnostic.New(diagnostic.IllegalCharacter, l.spanFrom(start), "illegal character %q", l.ch))
+		}
 	}
 
 	// Move to next character
 	l.readChar()
 
+	tok.Span = token.Span{Start: start, End: l.currentPosition()}
+
 	return tok
 }
 
+// Diagnostics returns the problems found in the input read so far.
+func (l *Lexer) Diagnostics() []*diagnostic.Diagnostic {
+	return l.diagnostics
+}
+
+func (l *Lexer) report(d *diagnostic.Diagnostic) {
+	l.diagnostics = append(l.diagnostics, d)
+}
+
 func New(input string) *Lexer {
 	l := &Lexer{input: input, lineNumber: 1}
 	l.readChar()
@@ -125,75 +224,246 @@ func New(input string) *Lexer {
 }
 
 func (l *Lexer) readChar() {
+	// Leaving a newline starts the next line
+	if l.ch == '\n' {
+		l.lineNumber++
+		l.lineStart = l.readPosition
+	}
+
 	// Check if end of input
+	width := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 
 	// Update position
 	l.position = l.readPosition
 
 	// Move to next character
-	l.readPosition++
+	l.readPosition += width
 }
 
-func (l *Lexer) readString() string {
-	position := l.position + 1
-
-	for {
-		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
-		}
-	}
-
-	return l.input[position:l.position]
+func (l *Lexer) peekChar() rune {
+	return l.peekCharAt(1)
 }
 
-func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+// peekCharAt returns the char n places after the one under examination.
+func (l *Lexer) peekCharAt(n int) rune {
+	offset := l.readPosition
+	for ; n > 1 && offset < len(l.input); n-- {
+		_, width := utf8.DecodeRuneInString(l.input[offset:])
+		offset += width
+	}
+	if offset >= len(l.input) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	ch, _ := utf8.DecodeRuneInString(l.input[offset:])
+	return ch
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// isLetter reports whether ch may start an identifier: a Unicode letter or
+// an underscore.
+func isLetter(ch rune) bool {
+	return ch == '_' || 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
+// readIdentifier reads letters along with the combining marks that some
+// scripts place after them, as in नमस्ते.
 func (l *Lexer) readIdentifier() string {
 	position := l.position
-	for isLetter(l.ch) {
+	for isLetter(l.ch) || l.ch >= utf8.RuneSelf && unicode.IsMark(l.ch) {
 		l.readChar()
 	}
 	return l.input[position:l.position]
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer or a float such as 3.14, 1e-9 or 2.5E3. A dot
+// only starts a fraction when a digit follows it, so 1..3 stays a range.
+// Integers may also be written in hex (0xFF), binary (0b1010) or octal
+// (0o755), and any number may separate its digits with underscores
+// (1_000_000, 0x_FF). A decimal integer cannot start with 0, so 0755 is not
+// mistaken for octal. A malformed number is reported and read as an ILLEGAL
+// token.
+func (l *Lexer) readNumber() (string, token.TokenType) {
+	start := l.currentPosition()
 	position := l.position
-	for isDigit(l.ch) {
+
+	if l.ch == '0' {
+		if base, ok := numberBases[l.peekChar()]; ok {
+			l.readChar()
+			l.readChar()
+			for isLetter(l.ch) || isDigit(l.ch) {
+				l.readChar()
+			}
+
+			literal := l.input[position:l.position]
+			if !l.checkDigits(start, literal, literal[2:], base) {
+				return literal, token.ILLEGAL
+			}
+			return literal, token.INT
+		}
+	}
+
+	tokenType := token.TokenType(token.INT)
+
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if next == '+' || next == '-' {
+			next = l.peekCharAt(2)
+		}
+		if isDigit(next) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+
+	literal := l.input[position:l.position]
+	if !l.checkUnderscores(start, literal, literal, isDigit) {
+		return literal, token.ILLEGAL
+	}
+	if tokenType == token.INT && !l.checkLeadingZero(start, literal) {
+		return literal, token.ILLEGAL
+	}
+	return literal, tokenType
+}
+
+// checkLeadingZero reports whether the decimal integer literal does not
+// start with a 0 followed by more digits, and reports a diagnostic if it
+// does.
+func (l *Lexer) checkLeadingZero(start token.Position, literal string) bool {
+	if literal[0] != '0' || len(literal) == 1 {
+		return true
+	}
+
+	span := token.Span{Start: start, End: l.currentPosition()}
+	digits := strings.TrimLeft(literal, "0_")
+	if digits == "" {
+		digits = "0"
+	}
+
+	err := diagnostic.New(diagnostic.InvalidNumber, span, "decimal literal %q has a leading zero", literal)
+	if strings.Trim(digits, "01234567_") == "" {
+		err = err.WithHint("write %s for a decimal number, or 0o%s for an octal one", digits, digits)
+	} else {
+		err = err.WithHint("write %s", digits)
+	}
+	l.report(err)
+	return false
+}
+
+// numberBase describes the digits of an integer literal with a base prefix.
+type numberBase struct {
+	name   string
+	digits string
+}
+
+var numberBases = map[rune]numberBase{
+	'x': {"hexadecimal", "0123456789abcdefABCDEF"},
+	'X': {"hexadecimal", "0123456789abcdefABCDEF"},
+	'b': {"binary", "01"},
+	'B': {"binary", "01"},
+	'o': {"octal", "01234567"},
+	'O': {"octal", "01234567"},
+}
+
+// checkDigits reports whether the digits after the base prefix of literal
+// are valid in base, and reports a diagnostic if they are not.
+func (l *Lexer) checkDigits(start token.Position, literal string, digits string, base numberBase) bool {
+	span := token.Span{Start: start, End: l.currentPosition()}
+
+	if strings.Trim(digits, "_") == "" {
+		l.report(diagnostic.New(diagnostic.InvalidNumber, span, "%s literal %q has no digits", base.name, literal).
+			WithHint("add digits after %s", literal[:2]))
+		return false
+	}
+
+	for _, ch := range digits {
+		if ch != '_' && !strings.ContainsRune(base.digits, ch) {
+			l.report(diagnostic.New(diagnostic.InvalidNumber, span, "invalid digit %q in %s literal %q", ch, base.name, literal))
+			return false
+		}
+	}
+
+	// As in Go, an underscore may also separate the prefix from the digits
+	return l.checkUnderscores(start, literal, strings.TrimPrefix(digits, "_"), isHexDigit)
+}
+
+// checkUnderscores reports whether every underscore in digits sits between
+// two chars for which isDigit holds, and reports a diagnostic for literal if
+// one does not.
+func (l *Lexer) checkUnderscores(start token.Position, literal string, digits string, isDigit func(rune) bool) bool {
+	for i := 0; i < len(digits); i++ {
+		if digits[i] != '_' {
+			continue
+		}
+		if i == 0 || i == len(digits)-1 || !isDigit(rune(digits[i-1])) || !isDigit(rune(digits[i+1])) {
+			span := token.Span{Start: start, End: l.currentPosition()}
+			l.report(diagnostic.New(diagnostic.InvalidNumber, span, "misplaced '_' in number literal %q", literal).
+				WithHint("use a single _ between two digits"))
+			return false
+		}
+	}
+	return true
+}
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+// readDigits reads decimal digits and the underscores between them.
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
-		if l.ch == '\n' {
-			l.lineNumber++
-		}
 		l.readChar()
 	}
 }
 
-func newToken(tokenType token.TokenType, ch byte, lineNumber int) token.Token {
-	return token.Token{Type: tokenType, Literal: string(ch), LineNumber: lineNumber}
+func newToken(tokenType token.TokenType, ch rune) token.Token {
+	return token.Token{Type: tokenType, Literal: string(ch)}
+}
+
+// spanFrom returns the span from start to the end of the char under
+// examination.
+func (l *Lexer) spanFrom(start token.Position) token.Span {
+	end := start
+	end.Offset = min(l.readPosition, len(l.input))
+	end.Column += max(utf8.RuneCountInString(l.input[start.Offset:end.Offset]), 1)
+	return token.Span{Start: start, End: end}
+}
+
+// currentPosition returns the position of the char under examination. Past
+// the end of input it stays at the end.
+func (l *Lexer) currentPosition() token.Position {
+	offset := l.position
+	if offset > len(l.input) {
+		offset = len(l.input)
+	}
+	column := utf8.RuneCountInString(l.input[l.lineStart:offset]) + 1
+	return token.Position{Offset: offset, Line: l.lineNumber, Column: column}
 }
 
 // readComment skips over the characters until the end of the line
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"ember_lang/ember_lang/diagnostic"
 	"ember_lang/ember_lang/token"
 	"testing"
 )
@@ -309,3 +310,390 @@ func TestCommentTokens(t *testing.T) {
 		}
 	}
 }
+
+func TestLogicalOperatorTokens(t *testing.T) {
+	input := `a && b || c & d &&&e`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENTIFIER, "a"},
+		{token.AND, "&&"},
+		{token.IDENTIFIER, "b"},
+		{token.OR, "||"},
+		{token.IDENTIFIER, "c"},
+		{token.AMPERSAND, "&"},
+		{token.IDENTIFIER, "d"},
+		{token.AND, "&&"},
+		{token.AMPERSAND, "&"},
+		{token.IDENTIFIER, "e"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q (%q), got=%q (%q)",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestArithmeticAndBitwiseOperatorTokens(t *testing.T) {
+	input := `a % b ** c * *d & e | f ^ ~g << h >> i <= j`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENTIFIER, "a"},
+		{token.PERCENT, "%"},
+		{token.IDENTIFIER, "b"},
+		{token.POWER, "**"},
+		{token.IDENTIFIER, "c"},
+		{token.ASTERISK, "*"},
+		{token.ASTERISK, "*"},
+		{token.IDENTIFIER, "d"},
+		{token.AMPERSAND, "&"},
+		{token.IDENTIFIER, "e"},
+		{token.PIPE, "|"},
+		{token.IDENTIFIER, "f"},
+		{token.CARET, "^"},
+		{token.TILDE, "~"},
+		{token.IDENTIFIER, "g"},
+		{token.SHL, "<<"},
+		{token.IDENTIFIER, "h"},
+		{token.SHR, ">>"},
+		{token.IDENTIFIER, "i"},
+		{token.LTE, "<="},
+		{token.IDENTIFIER, "j"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q (%q), got=%q (%q)",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestRangeAndForInTokens(t *testing.T) {
+	input := `for (i, x in 0..n) { 1..=3 }`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.FOR, "for"},
+		{token.LPAREN, "("},
+		{token.IDENTIFIER, "i"},
+		{token.COMMA, ","},
+		{token.IDENTIFIER, "x"},
+		{token.IN, "in"},
+		{token.INT, "0"},
+		{token.DOTDOT, ".."},
+		{token.IDENTIFIER, "n"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.INT, "1"},
+		{token.DOTDOTEQ, "..="},
+		{token.INT, "3"},
+		{token.RBRACE, "}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q (%q), got=%q (%q)",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestNumberTokens(t *testing.T) {
+	input := `3.14 1e-9 2.5E3 7e+2 0.5..2 1.x 4e 10 0xFF 0B1010 0o755 1_000_000 1_000.5 0x1..3 0x_FF 0b_1 0 00.5`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E3"},
+		{token.FLOAT, "7e+2"},
+		{token.FLOAT, "0.5"},
+		{token.DOTDOT, ".."},
+		{token.INT, "2"},
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.IDENTIFIER, "x"},
+		{token.INT, "4"},
+		{token.IDENTIFIER, "e"},
+		{token.INT, "10"},
+		{token.INT, "0xFF"},
+		{token.INT, "0B1010"},
+		{token.INT, "0o755"},
+		{token.INT, "1_000_000"},
+		{token.FLOAT, "1_000.5"},
+		{token.INT, "0x1"},
+		{token.DOTDOT, ".."},
+		{token.INT, "3"},
+		{token.INT, "0x_FF"},
+		{token.INT, "0b_1"},
+		{token.INT, "0"},
+		{token.FLOAT, "00.5"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q (%q), got=%q (%q)",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestStringTokens(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"plain"`, "plain"},
+		{`"a\tb\nc"`, "a\tb\nc"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"\u{1F600}\u{e9}"`, "\U0001F600\u00e9"},
+		{`"nul\0"`, "nul\x00"},
+		{"`raw \\n \"x\"\nline`", "raw \\n \"x\"\nline"},
+		{"\"\"\"\n    one\n      two\n    \"\"\"", "one\n  two"},
+		{"\"\"\"\n\t\tkeep\n\n\t\t\\tend\n\t\"\"\"", "keep\n\n\tend"},
+		{"\"\"\"inline \\\"\"\" quotes\"\"\"", `inline """ quotes`},
+		{"\"\"\"\r\n  crlf\r\n  lines\r\n  \"\"\"", "crlf\nlines"},
+		{`""`, ""},
+		{`""""""`, ""},
+		{`"cost: \${price} $5"`, "cost: ${price} $5"},
+		{"`raw ${x}`", "raw ${x}"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != token.STRING || tok.Literal != tt.expected {
+			t.Errorf("wrong token for %q. expected=STRING (%q), got=%s (%q)", tt.input, tt.expected, tok.Type, tok.Literal)
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Errorf("string %q not read to its end. next token=%s (%q)", tt.input, next.Type, next.Literal)
+		}
+		if len(l.Diagnostics()) != 0 {
+			t.Errorf("unexpected diagnostics for %q: %v", tt.input, l.Diagnostics())
+		}
+	}
+}
+
+func TestInterpolatedStringTokens(t *testing.T) {
+	input := `"a${x + {"k": 1}["k"]}b${"c${y}"}"`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING_HEAD, "a"},
+		{token.IDENTIFIER, "x"},
+		{token.PLUS, "+"},
+		{token.LBRACE, "{"},
+		{token.STRING, "k"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "k"},
+		{token.RBRACKET, "]"},
+		{token.STRING_MIDDLE, "b"},
+		{token.STRING_HEAD, "c"},
+		{token.IDENTIFIER, "y"},
+		{token.STRING_TAIL, ""},
+		{token.STRING_TAIL, ""},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	if len(l.Diagnostics()) != 0 {
+		t.Errorf("unexpected diagnostics: %v", l.Diagnostics())
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	input := "let größe = 名前 + café + नमस्ते;"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENTIFIER, "größe", 5},
+		{token.ASSIGN, "=", 11},
+		{token.IDENTIFIER, "名前", 13},
+		{token.PLUS, "+", 16},
+		{token.IDENTIFIER, "café", 18},
+		{token.PLUS, "+", 23},
+		{token.IDENTIFIER, "नमस्ते", 25},
+		{token.SEMICOLON, ";", 31},
+		{token.EOF, "", 32},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%s (%q), got=%s (%q)",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+
+		if tok.Span.Start.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - column of %q wrong. expected=%d, got=%d",
+				i, tok.Literal, tt.expectedColumn, tok.Span.Start.Column)
+		}
+	}
+
+	if len(l.Diagnostics()) != 0 {
+		t.Errorf("unexpected diagnostics: %v", l.Diagnostics())
+	}
+}
+
+func TestTokenSpans(t *testing.T) {
+	input := "let x = 10;\nif (x == 10) {\n  \"a b\" x++ <= // note\n}"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedStart   token.Position
+		expectedEnd     token.Position
+	}{
+		{"let", token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 3, Line: 1, Column: 4}},
+		{"x", token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{"=", token.Position{Offset: 6, Line: 1, Column: 7}, token.Position{Offset: 7, Line: 1, Column: 8}},
+		{"10", token.Position{Offset: 8, Line: 1, Column: 9}, token.Position{Offset: 10, Line: 1, Column: 11}},
+		{";", token.Position{Offset: 10, Line: 1, Column: 11}, token.Position{Offset: 11, Line: 1, Column: 12}},
+		{"if", token.Position{Offset: 12, Line: 2, Column: 1}, token.Position{Offset: 14, Line: 2, Column: 3}},
+		{"(", token.Position{Offset: 15, Line: 2, Column: 4}, token.Position{Offset: 16, Line: 2, Column: 5}},
+		{"x", token.Position{Offset: 16, Line: 2, Column: 5}, token.Position{Offset: 17, Line: 2, Column: 6}},
+		{"==", token.Position{Offset: 18, Line: 2, Column: 7}, token.Position{Offset: 20, Line: 2, Column: 9}},
+		{"10", token.Position{Offset: 21, Line: 2, Column: 10}, token.Position{Offset: 23, Line: 2, Column: 12}},
+		{")", token.Position{Offset: 23, Line: 2, Column: 12}, token.Position{Offset: 24, Line: 2, Column: 13}},
+		{"{", token.Position{Offset: 25, Line: 2, Column: 14}, token.Position{Offset: 26, Line: 2, Column: 15}},
+		{"a b", token.Position{Offset: 29, Line: 3, Column: 3}, token.Position{Offset: 34, Line: 3, Column: 8}},
+		{"x", token.Position{Offset: 35, Line: 3, Column: 9}, token.Position{Offset: 36, Line: 3, Column: 10}},
+		{"++", token.Position{Offset: 36, Line: 3, Column: 10}, token.Position{Offset: 38, Line: 3, Column: 12}},
+		{"<=", token.Position{Offset: 39, Line: 3, Column: 13}, token.Position{Offset: 41, Line: 3, Column: 15}},
+		{" note", token.Position{Offset: 42, Line: 3, Column: 16}, token.Position{Offset: 49, Line: 3, Column: 23}},
+		{"}", token.Position{Offset: 50, Line: 4, Column: 1}, token.Position{Offset: 51, Line: 4, Column: 2}},
+		{"", token.Position{Offset: 51, Line: 4, Column: 2}, token.Position{Offset: 51, Line: 4, Column: 2}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Span.Start != tt.expectedStart {
+			t.Errorf("tests[%d] - start of %q wrong. expected=%+v, got=%+v",
+				i, tok.Literal, tt.expectedStart, tok.Span.Start)
+		}
+
+		if tok.Span.End != tt.expectedEnd {
+			t.Errorf("tests[%d] - end of %q wrong. expected=%+v, got=%+v",
+				i, tok.Literal, tt.expectedEnd, tok.Span.End)
+		}
+	}
+}
+
+func TestLexerDiagnostics(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedCode  string
+		expectedStart string
+	}{
+		{"let x = 5 @ 3;", diagnostic.IllegalCharacter, "1:11"},
+		{"let s = \"open\nend", diagnostic.UnterminatedString, "1:9"},
+		{"let x = 0x;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 0b_;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 1__0;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 1_;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 1_.5;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 0x__F;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 0x_;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 0755;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 09;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 00;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 0_1;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 0b102;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 0o8;", diagnostic.InvalidNumber, "1:9"},
+		{"let x = 0xFG;", diagnostic.InvalidNumber, "1:9"},
+		{"let s = `open\nend", diagnostic.UnterminatedString, "1:9"},
+		{"let s = \"\"\"\n  open\n", diagnostic.UnterminatedString, "1:9"},
+		{`let s = "a\qb";`, diagnostic.InvalidEscape, "1:11"},
+		{`let s = "\u{110000}";`, diagnostic.InvalidEscape, "1:10"},
+		{`let s = "\u41";`, diagnostic.InvalidEscape, "1:10"},
+		{"let s = \"\"\"\n  a\n  b \\\n  \"\"\";", diagnostic.InvalidEscape, "3:5"},
+		{`let s = "${x} open`, diagnostic.UnterminatedString, "1:13"},
+		{"let x = 1 \xff 2;", diagnostic.IllegalCharacter, "1:11"},
+		{"let 名前 = 1 € 2;", diagnostic.IllegalCharacter, "1:12"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+
+		diagnostics := l.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("wrong number of diagnostics for %q. got=%d", tt.input, len(diagnostics))
+		}
+
+		if diagnostics[0].Code != tt.expectedCode {
+			t.Errorf("wrong code for %q. expected=%s, got=%s", tt.input, tt.expectedCode, diagnostics[0].Code)
+		}
+
+		if diagnostics[0].Span.Start.String() != tt.expectedStart {
+			t.Errorf("wrong position for %q. expected=%s, got=%s", tt.input, tt.expectedStart, diagnostics[0].Span.Start)
+		}
+	}
+}
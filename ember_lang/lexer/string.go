@@ -0,0 +1,267 @@
+package lexer
+
+import (
+	"ember_lang/ember_lang/diagnostic"
+	"ember_lang/ember_lang/token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// readString reads a "..." string starting at the opening quote and returns
+// its value with escape sequences decoded. It stops on the closing quote, or
+// on the { of an interpolation, in which case the string continues after the
+// matching } (see readStringContinuation).
+func (l *Lexer) readString(start token.Position) (string, token.TokenType) {
+	text, interpolation := l.readStringText(start)
+	if interpolation {
+		return text, token.STRING_HEAD
+	}
+	return text, token.STRING
+}
+
+// readStringContinuation reads the rest of a string after the } that closes
+// one of its interpolations.
+func (l *Lexer) readStringContinuation(start token.Position) (string, token.TokenType) {
+	l.interpolations = l.interpolations[:len(l.interpolations)-1]
+
+	text, interpolation := l.readStringText(start)
+	if interpolation {
+		return text, token.STRING_MIDDLE
+	}
+	return text, token.STRING_TAIL
+}
+
+// readStringText reads the text after the current char up to the closing
+// quote or the next ${, whichever comes first, and reports whether an
+// interpolation starts there.
+func (l *Lexer) readStringText(start token.Position) (string, bool) {
+	contentStart := l.position + 1
+	end := l.findClosing(contentStart, `"`, true)
+	interpolation := l.findClosing(contentStart, "${", true)
+	if interpolation >= 0 && (end < 0 || interpolation < end) {
+		l.advanceTo(interpolation + 1)
+		l.interpolations = append(l.interpolations, 0)
+		return l.unescape(contentStart, interpolation), true
+	}
+
+	if end < 0 {
+		l.reportUnterminated(start, `"`)
+		return l.unescape(contentStart, len(l.input)), false
+	}
+
+	l.advanceTo(end)
+	return l.unescape(contentStart, end), false
+}
+
+// readRawString reads a `...` string, which may span lines and takes its
+// contents literally. It stops on the closing backtick.
+func (l *Lexer) readRawString(start token.Position) string {
+	contentStart := l.position + 1
+	end := l.findClosing(contentStart, "`", false)
+	if end < 0 {
+		l.reportUnterminated(start, "`")
+		return l.input[contentStart:]
+	}
+
+	l.advanceTo(end)
+	return l.input[contentStart:end]
+}
+
+// readMultilineString reads a """...""" string. A line break right after the
+// opening quotes and the line holding the closing quotes are dropped, and the
+// indentation shared by the remaining lines is stripped, so the string can be
+// indented along with the code around it. Escape sequences are decoded after
+// the indentation is stripped. It stops on the last closing quote.
+func (l *Lexer) readMultilineString(start token.Position) string {
+	contentStart := l.position + 3
+	end := l.findClosing(contentStart, `"""`, true)
+	if end < 0 {
+		l.reportUnterminated(start, `"""`)
+		end = len(l.input)
+	} else {
+		l.advanceTo(end + 2)
+	}
+
+	// Split the contents into lines, remembering where each one starts
+	var lines []int
+	for offset := contentStart; ; {
+		lines = append(lines, offset)
+		newline := strings.IndexByte(l.input[offset:end], '\n')
+		if newline < 0 {
+			break
+		}
+		offset += newline + 1
+	}
+	lineEnd := func(i int) int {
+		if i+1 == len(lines) {
+			return end
+		}
+		lineEnd := lines[i+1] - 1
+		if lineEnd > lines[i] && l.input[lineEnd-1] == '\r' {
+			lineEnd-- // Windows line break
+		}
+		return lineEnd
+	}
+	isBlank := func(i int) bool {
+		return strings.Trim(l.input[lines[i]:lineEnd(i)], " \t\r") == ""
+	}
+
+	first, last := 0, len(lines)-1
+	if last > 0 && isBlank(first) {
+		first++
+	}
+	if last >= first && last > 0 && isBlank(last) {
+		last--
+	}
+
+	indent := -1
+	for i := first; i <= last; i++ {
+		if isBlank(i) {
+			continue
+		}
+		line := l.input[lines[i]:lineEnd(i)]
+		width := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || width < indent {
+			indent = width
+		}
+	}
+
+	parts := make([]string, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		if isBlank(i) {
+			parts = append(parts, "")
+			continue
+		}
+		parts = append(parts, l.unescape(lines[i]+indent, lineEnd(i)))
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+// findClosing returns the offset of the first delimiter at or after from, or
+// -1 if the input ends first. With escapes, a backslash hides the char after
+// it.
+func (l *Lexer) findClosing(from int, delimiter string, escapes bool) int {
+	for i := from; i < len(l.input); i++ {
+		if escapes && l.input[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(l.input[i:], delimiter) {
+			return i
+		}
+	}
+	return -1
+}
+
+// advanceTo reads chars up to the one at offset, keeping line numbers in step.
+func (l *Lexer) advanceTo(offset int) {
+	for l.position < offset {
+		l.readChar()
+	}
+}
+
+func (l *Lexer) reportUnterminated(start token.Position, delimiter string) {
+	l.advanceTo(len(l.input))
+	span := token.Span{Start: start, End: l.currentPosition()}
+	l.report(diagnostic.New(diagnostic.UnterminatedString, span, "unterminated string literal").
+		WithHint("add a closing '%s'", delimiter))
+}
+
+// unescape returns the input between from and to with its escape sequences
+// decoded. Invalid escapes are reported and left out.
+func (l *Lexer) unescape(from int, to int) string {
+	text := l.input[from:to]
+	if !strings.Contains(text, `\`) {
+		return text
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' {
+			out.WriteByte(text[i])
+			continue
+		}
+
+		escapeStart := i
+		i++
+		if i == len(text) {
+			if to < len(l.input) {
+				// A backslash at the end of a line of a multi-line string
+				l.report(diagnostic.New(diagnostic.InvalidEscape, l.spanBetween(from+escapeStart, from+i),
+					"backslash at end of line").
+					WithHint(`write \\ for a backslash`))
+			}
+			break
+		}
+
+		switch text[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 'r':
+			out.WriteByte('\r')
+		case '0':
+			out.WriteByte(0)
+		case '\\':
+			out.WriteByte('\\')
+		case '"':
+			out.WriteByte('"')
+		case '$':
+			out.WriteByte('$')
+		case 'u':
+			r, length, ok := unicodeEscape(text[i+1:])
+			i += length
+			if !ok {
+				l.report(diagnostic.New(diagnostic.InvalidEscape, l.spanBetween(from+escapeStart, from+i+1),
+					"invalid Unicode escape %s", text[escapeStart:i+1]).
+					WithHint(`write Unicode escapes as \u{1F600}, with 1 to 6 hex digits`))
+				continue
+			}
+			out.WriteRune(r)
+		default:
+			_, width := utf8.DecodeRuneInString(text[i:])
+			l.report(diagnostic.New(diagnostic.InvalidEscape, l.spanBetween(from+escapeStart, from+i+width),
+				"unknown escape sequence %s", text[escapeStart:i+width]).
+				WithHint(`use \n, \t, \r, \0, \\, \", \$ or \u{...}, or a raw string`))
+			i += width - 1
+		}
+	}
+
+	return out.String()
+}
+
+// unicodeEscape decodes the {hex} part of a \u{hex} escape at the start of
+// text. It returns the rune, how many bytes it read and whether it is valid.
+func unicodeEscape(text string) (rune, int, bool) {
+	if !strings.HasPrefix(text, "{") {
+		return 0, 0, false
+	}
+
+	closing := strings.IndexByte(text, '}')
+	if closing < 0 {
+		return 0, 1, false
+	}
+
+	digits := text[1:closing]
+	value, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || len(digits) > 6 || strings.Contains(digits, "_") || !utf8.ValidRune(rune(value)) {
+		return 0, closing + 1, false
+	}
+
+	return rune(value), closing + 1, true
+}
+
+// spanBetween returns the span between two offsets of the input.
+func (l *Lexer) spanBetween(from int, to int) token.Span {
+	return token.Span{Start: l.positionAt(from), End: l.positionAt(to)}
+}
+
+func (l *Lexer) positionAt(offset int) token.Position {
+	lineStart := strings.LastIndexByte(l.input[:offset], '\n') + 1
+	line := strings.Count(l.input[:offset], "\n") + 1
+	column := utf8.RuneCountInString(l.input[lineStart:offset]) + 1
+	return token.Position{Offset: offset, Line: line, Column: column}
+}
@@ -0,0 +1,81 @@
+package object
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Limits bounds how much work a program may do. The zero value sets no
+// limits.
+type Limits struct {
+	MaxSteps int64     // Steps the program may take, or 0 for no limit
+	Deadline time.Time // When the program must have finished, or zero for no deadline
+}
+
+// HaltReason tells why a program was stopped from outside rather than failing
+// by itself.
+type HaltReason int
+
+const (
+	NotHalted HaltReason = iota
+	Cancelled
+	BudgetExceeded
+)
+
+// budgetCheckInterval is how many steps a program takes between checks of
+// its context and deadline, which are too slow to make on every step. The
+// first step checks them, so a program does not start once cancelled.
+const budgetCheckInterval = 1024
+
+// Budget tracks a program running under a context and Limits, and stops it
+// with an error once the context is done or the limits are exceeded. What a
+// step is depends on the engine: the evaluator takes one per node it
+// evaluates, the VM one per instruction it executes.
+type Budget struct {
+	ctx    context.Context // nil while no program is running under a budget
+	limits Limits
+	steps  int64
+}
+
+// Start begins tracking a program run under ctx and limits.
+func (b *Budget) Start(ctx context.Context, limits Limits) {
+	*b = Budget{ctx: ctx, limits: limits}
+}
+
+// Stop ends tracking the program, lifting its limits.
+func (b *Budget) Stop() {
+	*b = Budget{}
+}
+
+// Step counts a step of the program and returns the error to stop it with if
+// it has been cancelled or has run out of budget.
+func (b *Budget) Step() *Error {
+	if b.ctx == nil {
+		return nil
+	}
+
+	b.steps++
+	if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+		return halt(BudgetExceeded, "Budget exceeded: more than %d steps", b.limits.MaxSteps)
+	}
+	if b.steps%budgetCheckInterval != 1 {
+		return nil
+	}
+
+	switch b.ctx.Err() {
+	case nil:
+	case context.DeadlineExceeded:
+		return halt(BudgetExceeded, "Budget exceeded: deadline passed")
+	default:
+		return halt(Cancelled, "Execution cancelled")
+	}
+	if !b.limits.Deadline.IsZero() && time.Now().After(b.limits.Deadline) {
+		return halt(BudgetExceeded, "Budget exceeded: deadline passed")
+	}
+	return nil
+}
+
+func halt(reason HaltReason, format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...), Halt: reason}
+}
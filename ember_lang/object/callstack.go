@@ -0,0 +1,271 @@
+package object
+
+import (
+	"ember_lang/ember_lang/diagnostic"
+	"ember_lang/ember_lang/token"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxCallDepth is how many calls may be nested before a program fails
+// with a stack overflow, unless configured otherwise.
+const DefaultMaxCallDepth = 10000
+
+// MaxCallDepthLimit is the deepest the call depth limit may be set. Calls in
+// the evaluator nest on the Go stack, which the runtime will not grow much
+// past 1GB whatever its limit; this many calls fit with room to spare.
+const MaxCallDepthLimit = 50000
+
+// CallStack is the stack of function calls being evaluated, kept so runtime
+// errors can report the calls they were raised in and runaway recursion can
+// be stopped before it exhausts the interpreter's own stack.
+type CallStack struct {
+	calls       []Call
+	maxDepth    int
+	builtinCall token.Span // Where the builtin being run was called from
+}
+
+func NewCallStack() *CallStack {
+	return &CallStack{maxDepth: DefaultMaxCallDepth}
+}
+
+// Push records a call to the function named name, or to an anonymous
+// function if name is empty, made at span with args.
+func (s *CallStack) Push(name string, args []Object, span token.Span) {
+	s.calls = append(s.calls, Call{Name: name, Args: args, Span: span})
+}
+
+func (s *CallStack) Pop() {
+	s.calls[len(s.calls)-1] = Call{}
+	s.calls = s.calls[:len(s.calls)-1]
+}
+
+// EnterBuiltin records that a builtin is called at span, and returns the span
+// it replaces for LeaveBuiltin.
+func (s *CallStack) EnterBuiltin(span token.Span) token.Span {
+	previous := s.builtinCall
+	s.builtinCall = span
+	return previous
+}
+
+// LeaveBuiltin restores the span EnterBuiltin replaced once the builtin
+// returns.
+func (s *CallStack) LeaveBuiltin(previous token.Span) {
+	s.builtinCall = previous
+}
+
+// BuiltinCall returns where the innermost builtin being run was called from,
+// which is where the functions it calls back are called from.
+func (s *CallStack) BuiltinCall() token.Span {
+	return s.builtinCall
+}
+
+// Depth returns the number of calls being evaluated.
+func (s *CallStack) Depth() int {
+	return len(s.calls)
+}
+
+// MaxDepth returns how many calls may be nested.
+func (s *CallStack) MaxDepth() int {
+	return s.maxDepth
+}
+
+// Trace returns the stack trace of the calls being evaluated.
+func (s *CallStack) Trace() *StackTrace {
+	return NewStackTrace(len(s.calls), func(i int) Call {
+		return s.calls[len(s.calls)-1-i]
+	})
+}
+
+// Call is a function call in a stack trace. Its arguments are kept as values
+// and only summarized when the trace is shown, so errors that are caught cost
+// no formatting.
+type Call struct {
+	Name string // Empty for an anonymous function
+	Args []Object
+	Span token.Span // Where it was called from; invalid if not known
+}
+
+// Frame describes the call for display.
+func (c Call) Frame() diagnostic.Frame {
+	return NewFrame(c.Name, c.Args, c.Span)
+}
+
+// maxTraceCalls is how many calls a stack trace keeps. A deeper trace keeps
+// its innermost and outermost calls and counts the ones between them, which
+// is all that is ever shown of it.
+const maxTraceCalls = 20
+
+// StackTrace is the calls a runtime error was raised in, innermost first.
+type StackTrace struct {
+	calls   []Call // The innermost calls kept, then the outermost
+	omitted int    // Calls left out between the two halves of calls
+}
+
+// NewStackTrace returns the trace of depth calls, where call(i) is the i-th
+// innermost one, or nil if depth is zero. Only the calls kept are asked for.
+func NewStackTrace(depth int, call func(i int) Call) *StackTrace {
+	if depth == 0 {
+		return nil
+	}
+
+	trace := &StackTrace{}
+	for i := 0; i < depth; i++ {
+		if depth > maxTraceCalls && i == maxTraceCalls/2 {
+			trace.omitted = depth - maxTraceCalls
+			i += trace.omitted
+		}
+		trace.calls = append(trace.calls, call(i))
+	}
+	return trace
+}
+
+// Calls returns the calls kept, innermost first. Any omitted calls came
+// between its first and second halves.
+func (t *StackTrace) Calls() []Call {
+	if t == nil {
+		return nil
+	}
+	return t.calls
+}
+
+// Omitted returns how many calls were left out of the middle of the trace.
+func (t *StackTrace) Omitted() int {
+	if t == nil {
+		return 0
+	}
+	return t.omitted
+}
+
+// Depth returns how many calls the error was raised in.
+func (t *StackTrace) Depth() int {
+	return len(t.Calls()) + t.Omitted()
+}
+
+// Frames describes the calls kept, innermost first.
+func (t *StackTrace) Frames() []diagnostic.Frame {
+	calls := t.Calls()
+	frames := make([]diagnostic.Frame, len(calls))
+	for i, call := range calls {
+		frames[i] = call.Frame()
+	}
+	return frames
+}
+
+// NewFrame describes a call to the function named name with args, for a
+// stack trace.
+func NewFrame(name string, args []Object, call token.Span) diagnostic.Frame {
+	if name == "" {
+		name = "<anonymous>"
+	}
+
+	summaries := make([]string, len(args))
+	for i, arg := range args {
+		summaries[i] = summarize(arg)
+	}
+
+	return diagnostic.Frame{Function: name, Arguments: strings.Join(summaries, ", "), Call: call}
+}
+
+// maxSummaryLength is how many characters of an argument a stack trace shows.
+const maxSummaryLength = 24
+
+// summarize returns a short form of obj for a stack trace. Strings are
+// quoted, functions are not spelled out, and long values are cut short.
+func summarize(obj Object) string {
+	var s summary
+	s.add(obj)
+
+	if characters := []rune(s.out.String()); len(characters) > maxSummaryLength {
+		return string(characters[:maxSummaryLength-3]) + "..."
+	}
+	return s.out.String()
+}
+
+// summary builds the short form of an argument, and stops once it is longer
+// than a stack trace shows. Arrays, hashes and pointers are written out
+// element by element rather than inspected whole, so large values cost no
+// more than small ones, and values that contain themselves come to an end.
+type summary struct {
+	out       strings.Builder
+	length    int  // Characters written
+	inPointer bool // Set while writing the value a pointer refers to
+}
+
+func (s *summary) full() bool {
+	return s.length > maxSummaryLength
+}
+
+func (s *summary) write(text string) {
+	for _, character := range text {
+		if s.full() {
+			return
+		}
+		s.out.WriteRune(character)
+		s.length++
+	}
+}
+
+func (s *summary) add(obj Object) {
+	if s.full() {
+		return
+	}
+
+	switch obj := obj.(type) {
+	case *String:
+		// Only as much of the string as can be shown is quoted
+		value := obj.Value
+		if characters := []rune(value); len(characters) > maxSummaryLength {
+			value = string(characters[:maxSummaryLength])
+		}
+		s.write(strconv.Quote(value))
+	case *Function, *CompiledFunction, *Builtin, Callable:
+		s.write("fn")
+	case *Array:
+		s.write("[")
+		for i, element := range obj.Elements {
+			if s.full() {
+				return
+			}
+			if i > 0 {
+				s.write(", ")
+			}
+			s.add(element)
+		}
+		s.write("]")
+	case *Hash:
+		s.write("{")
+		i := 0
+		for _, pair := range obj.Pairs {
+			if s.full() {
+				return
+			}
+			if i > 0 {
+				s.write(", ")
+			}
+			s.add(pair.Key)
+			s.write(": ")
+			s.add(pair.Value)
+			i++
+		}
+		s.write("}")
+	case *Pointer:
+		// As in Pointer.Inspect, pointers inside the value only show what
+		// they refer to
+		if s.inPointer {
+			s.write("&" + obj.Target())
+			return
+		}
+		s.write("&" + obj.Target() + " (")
+		if value, ok := obj.Load(); ok {
+			s.inPointer = true
+			s.add(value)
+			s.inPointer = false
+		} else {
+			s.write("missing")
+		}
+		s.write(")")
+	default:
+		s.write(obj.Inspect())
+	}
+}
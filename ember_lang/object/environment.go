@@ -1,49 +1,144 @@
 package object
 
+// LanguageVersion selects between language semantics that changed over time,
+// so older scripts can keep running while they are migrated.
+type LanguageVersion int
+
+const (
+	// Version1 runs blocks and loop headers in the scope around them, so
+	// their variables stay visible after the block ends.
+	Version1 LanguageVersion = iota + 1
+	// Version2 gives every block and loop header its own lexical scope.
+	Version2
+
+	LatestVersion = Version2
+)
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
+	return &Environment{
+		store:    make(map[string]*Cell),
+		outer:    outer,
+		version:  outer.version,
+		integers: outer.integers,
+		calls:    outer.calls,
+		budget:   outer.budget,
+	}
+}
+
+// NewBlockEnvironment creates the scope of a block or loop header.
+func NewBlockEnvironment(outer *Environment) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.block = true
 	return env
 }
 
 func NewEnvironment() *Environment {
-	store := make(map[string]Object)
-	mutable := make(map[string]bool)
-	return &Environment{store: store, mutable: mutable}
+	return NewVersionedEnvironment(LatestVersion)
+}
 
+func NewVersionedEnvironment(version LanguageVersion) *Environment {
+	store := make(map[string]*Cell)
+	return &Environment{store: store, version: version, calls: NewCallStack(), budget: &Budget{}}
 }
 
+// Environment binds names to cells. Closures keep the environment they were
+// created in, and pointers keep the cell of the variable they refer to.
 type Environment struct {
-	store   map[string]Object
-	outer   *Environment
-	mutable map[string]bool
+	store map[string]*Cell
+	outer *Environment
+
+	version  LanguageVersion
+	integers IntegerMode
+	calls    *CallStack // Shared by every scope created from the same program
+	budget   *Budget    // Shared like calls
+	block    bool       // Scope of a block rather than of a function or program
+}
+
+// Version returns the language version the environment was created for.
+func (e *Environment) Version() LanguageVersion {
+	return e.version
+}
+
+// IntegerMode returns how integer overflow is handled in this environment.
+func (e *Environment) IntegerMode() IntegerMode {
+	return e.integers
+}
+
+// SetIntegerMode selects how integer overflow is handled. Scopes created from
+// this environment afterwards use the same mode.
+func (e *Environment) SetIntegerMode(mode IntegerMode) {
+	e.integers = mode
+}
+
+// CallStack returns the calls being evaluated in the program this
+// environment belongs to.
+func (e *Environment) CallStack() *CallStack {
+	return e.calls
+}
+
+// Budget returns the budget the program this environment belongs to runs
+// under.
+func (e *Environment) Budget() *Budget {
+	return e.budget
+}
+
+// SetMaxCallDepth limits how many calls may be nested in the program this
+// environment belongs to. Calls beyond the limit fail with a stack overflow.
+func (e *Environment) SetMaxCallDepth(depth int) {
+	e.calls.maxDepth = depth
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
-	if !ok && e.outer != nil {
-		obj, ok = e.outer.Get(name)
+	cell, ok := e.Cell(name)
+	if !ok {
+		return nil, false
+	}
+	return cell.Value, true
+}
+
+// Cell returns the cell bound to name in this scope or an enclosing one.
+func (e *Environment) Cell(name string) (*Cell, bool) {
+	for env := e; env != nil; env = env.outer {
+		if cell, ok := env.store[name]; ok {
+			return cell, true
+		}
 	}
-	return obj, ok
+	return nil, false
 }
 
+// Set binds name in this scope. Redefining a name that already lives in this
+// scope reuses its cell, so pointers to it see the new value.
 func (e *Environment) Set(name string, val Object, mutable bool) Object {
-	e.store[name] = val
-	e.mutable[name] = mutable
+	if cell, ok := e.store[name]; ok {
+		cell.Value = val
+		cell.Mutable = mutable
+		return val
+	}
+
+	e.store[name] = &Cell{Value: val, Mutable: mutable}
 	return val
 }
 
-func (e *Environment) IsMutable(name string) bool {
-	mutable, ok := e.mutable[name]
-	if ok {
-		return mutable
+// Assign stores val in the scope that declared name, so assigning from a
+// block, function or closure updates the variable it refers to instead of
+// binding a new one. A name declared nowhere is bound in the innermost
+// function scope.
+func (e *Environment) Assign(name string, val Object) Object {
+	if cell, ok := e.Cell(name); ok {
+		cell.Value = val
+		return val
 	}
 
-	// If not found in current environment, check outer environment
-	if e.outer != nil {
-		return e.outer.IsMutable(name)
+	env := e
+	for env.block {
+		env = env.outer
 	}
+	return env.Set(name, val, true)
+}
 
-	// Default to immutable if not found
-	return false
+// IsMutable reports whether name is bound to a mutable variable. Names that
+// are not bound are immutable.
+func (e *Environment) IsMutable(name string) bool {
+	cell, ok := e.Cell(name)
+	return ok && cell.Mutable
 }
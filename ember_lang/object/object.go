@@ -3,8 +3,14 @@ package object
 import (
 	"bytes"
 	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/code"
+	"ember_lang/ember_lang/diagnostic"
+	"ember_lang/ember_lang/token"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"strconv"
 	"strings"
 )
 
@@ -16,16 +22,26 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      ObjectType = "INTEGER"
+	BIGINT_OBJ       ObjectType = "BIGINT"
+	FLOAT_OBJ        ObjectType = "FLOAT"
 	BOOLEAN_OBJ      ObjectType = "BOOLEAN"
 	NULL_OBJ         ObjectType = "NULL"
 	RETURN_VALUE_OBJ ObjectType = "RETURN_VALUE"
+	BREAK_OBJ        ObjectType = "BREAK"
+	CONTINUE_OBJ     ObjectType = "CONTINUE"
+	TAIL_CALL_OBJ    ObjectType = "TAIL_CALL"
 	ERROR_OBJ        ObjectType = "ERROR"
+	ERROR_VALUE_OBJ  ObjectType = "ERROR_VALUE"
 	FUNCTION_OBJ     ObjectType = "FUNCTION"
 	STRING_OBJ       ObjectType = "STRING"
 	BUILTIN_OBJ      ObjectType = "BUILTIN"
 	ARRAY_OBJ        ObjectType = "ARRAY"
 	HASH_OBJ         ObjectType = "HASH"
 	POINTER_OBJ      ObjectType = "POINTER"
+	RANGE_OBJ        ObjectType = "RANGE"
+	ITERATOR_OBJ     ObjectType = "ITERATOR"
+
+	COMPILED_FUNCTION_OBJ ObjectType = "COMPILED_FUNCTION"
 )
 
 // ----------------------------------------------------------------------------
@@ -37,6 +53,16 @@ type Object interface {
 	Inspect() string
 }
 
+// Display returns the text that stands for obj in an interpolated string and
+// in the output of print. A string stands for its contents; any other value
+// is shown the way Inspect shows it.
+func Display(obj Object) string {
+	if str, ok := obj.(*String); ok {
+		return str.Value
+	}
+	return obj.Inspect()
+}
+
 // ----------------------------------------------------------------------------
 // Integer Object
 // ----------------------------------------------------------------------------
@@ -52,6 +78,58 @@ func (i *Integer) Inspect() string {
 	return fmt.Sprintf("%d", i.Value)
 }
 
+// ----------------------------------------------------------------------------
+// BigInt Object
+// ----------------------------------------------------------------------------
+
+// BigInt is an integer that does not fit in 64 bits. Integer arithmetic
+// promotes results to a BigInt when they overflow, and demotes them back to an
+// Integer when they fit again, so every integer value has one representation.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType {
+	return BIGINT_OBJ
+}
+
+func (b *BigInt) Inspect() string {
+	return b.Value.String()
+}
+
+// IntegerMode selects what integer arithmetic does with a result that does
+// not fit in 64 bits.
+type IntegerMode int
+
+const (
+	// BigIntegers promotes the result to a BigInt.
+	BigIntegers IntegerMode = iota
+	// CheckedIntegers reports an integer overflow error instead.
+	CheckedIntegers
+)
+
+// ----------------------------------------------------------------------------
+// Float Object
+// ----------------------------------------------------------------------------
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType {
+	return FLOAT_OBJ
+}
+
+// Inspect prints the shortest representation that reads back as the same
+// value, keeping a ".0" on whole numbers so floats never look like integers.
+func (f *Float) Inspect() string {
+	text := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if strings.ContainsAny(text, ".eIN") {
+		return text
+	}
+	return text + ".0"
+}
+
 // ----------------------------------------------------------------------------
 // Boolean Object
 // ----------------------------------------------------------------------------
@@ -98,12 +176,75 @@ func (r *ReturnValue) Inspect() string {
 	return r.Value.Inspect()
 }
 
+// ----------------------------------------------------------------------------
+// Break and Continue Objects
+// ----------------------------------------------------------------------------
+
+// Break unwinds the evaluation of a loop body up to the loop named by Label,
+// or to the innermost loop if Label is empty.
+type Break struct {
+	Label string
+}
+
+func (b *Break) Type() ObjectType {
+	return BREAK_OBJ
+}
+
+func (b *Break) Inspect() string {
+	if b.Label != "" {
+		return "break " + b.Label
+	}
+	return "break"
+}
+
+// Continue unwinds the evaluation of a loop body and starts the next
+// iteration of the loop named by Label, or of the innermost loop.
+type Continue struct {
+	Label string
+}
+
+func (c *Continue) Type() ObjectType {
+	return CONTINUE_OBJ
+}
+
+func (c *Continue) Inspect() string {
+	if c.Label != "" {
+		return "continue " + c.Label
+	}
+	return "continue"
+}
+
+// ----------------------------------------------------------------------------
+// TailCall Object
+// ----------------------------------------------------------------------------
+
+// TailCall unwinds the evaluation of a function body up to the call of the
+// function, which then calls Function with Arguments in its place. Call is the
+// span of the call expression, for stack traces.
+type TailCall struct {
+	Function  *Function
+	Arguments []Object
+	Call      token.Span
+}
+
+func (t *TailCall) Type() ObjectType {
+	return TAIL_CALL_OBJ
+}
+
+func (t *TailCall) Inspect() string {
+	return "tail call"
+}
+
 // ----------------------------------------------------------------------------
 // Error Object
 // ----------------------------------------------------------------------------
 
 type Error struct {
-	Message string
+	Message  string
+	Span     token.Span  // Source of the expression that failed, if known
+	Stack    *StackTrace // Calls the error was raised in, if known
+	Internal bool        // Set for Go panics recovered inside the interpreter
+	Halt     HaltReason  // Set when the program was stopped from outside; see Budget
 }
 
 func (e *Error) Type() ObjectType {
@@ -111,14 +252,55 @@ func (e *Error) Type() ObjectType {
 }
 
 func (e *Error) Inspect() string {
+	if e.Span.IsValid() {
+		return "\033[31mERROR: " + e.Span.Start.String() + ": " + e.Message + "\033[0m"
+	}
 	return "\033[31mERROR: " + e.Message + "\033[0m"
 }
 
+// Diagnostic describes the error for rendering against the program source.
+func (e *Error) Diagnostic() *diagnostic.Diagnostic {
+	if e.Internal {
+		return diagnostic.New(diagnostic.InternalError, e.Span, "%s", e.Message).
+			WithTrace(e.Stack.Frames(), e.Stack.Omitted()).
+			WithNote("this is a bug in the interpreter, not in your program")
+	}
+	switch e.Halt {
+	case Cancelled:
+		return diagnostic.New(diagnostic.Cancelled, e.Span, "%s", e.Message).WithTrace(e.Stack.Frames(), e.Stack.Omitted())
+	case BudgetExceeded:
+		return diagnostic.New(diagnostic.BudgetExceeded, e.Span, "%s", e.Message).WithTrace(e.Stack.Frames(), e.Stack.Omitted())
+	}
+	return diagnostic.New(diagnostic.RuntimeError, e.Span, "%s", e.Message).WithTrace(e.Stack.Frames(), e.Stack.Omitted())
+}
+
+// ----------------------------------------------------------------------------
+// Error Value Object
+// ----------------------------------------------------------------------------
+
+// ErrorValue is an error as a value of the language: what error() returns and
+// what a catch clause receives. Unlike an *Error it does not unwind anything
+// until it is thrown.
+type ErrorValue struct {
+	Message string
+	Span    token.Span  // Where the error was thrown, if it has been
+	Stack   *StackTrace // Calls it was thrown in, if known
+}
+
+func (e *ErrorValue) Type() ObjectType {
+	return ERROR_VALUE_OBJ
+}
+
+func (e *ErrorValue) Inspect() string {
+	return "error: " + e.Message
+}
+
 // ----------------------------------------------------------------------------
 // Function Object
 // ----------------------------------------------------------------------------
 
 type Function struct {
+	Name       string // Name the function was bound to by let, if any
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -129,19 +311,23 @@ func (f *Function) Type() ObjectType {
 }
 
 func (f *Function) Inspect() string {
+	return inspectFunction(f.Parameters, f.Body)
+}
+
+func inspectFunction(parameters []*ast.Identifier, body *ast.BlockStatement) string {
 	var out bytes.Buffer
 
 	out.WriteString("fn")
 	out.WriteString("(")
 
-	for i, param := range f.Parameters {
+	for i, param := range parameters {
 		out.WriteString(param.String())
-		if i != len(f.Parameters)-1 {
+		if i != len(parameters)-1 {
 			out.WriteString(", ")
 		}
 	}
 	out.WriteString(") {\n")
-	out.WriteString(f.Body.String())
+	out.WriteString(body.String())
 	out.WriteString("\n}")
 
 	return out.String()
@@ -231,6 +417,32 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey hashes the sign and magnitude of the value. A BigInt never holds a
+// value that fits in an Integer, so equal integers always share a key.
+func (b *BigInt) HashKey() HashKey {
+	hash := fnv.New64a()
+	if b.Value.Sign() < 0 {
+		hash.Write([]byte{'-'})
+	}
+	hash.Write(b.Value.Bytes())
+
+	return HashKey{Type: b.Type(), Value: hash.Sum64()}
+}
+
+// HashKey hashes a whole float like the integer it equals, since 1 == 1.0,
+// so both are the same key; this also makes 0.0 and -0.0 one key. Other
+// floats hash the bits of their value.
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && !math.IsInf(f.Value, 0) {
+		if f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+			return (&Integer{Value: int64(f.Value)}).HashKey()
+		}
+		whole, _ := big.NewFloat(f.Value).Int(nil)
+		return (&BigInt{Value: whole}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	hash := fnv.New64a()
 	hash.Write([]byte(s.Value))
@@ -276,9 +488,15 @@ type Hashable interface {
 // Pointer Object
 // ----------------------------------------------------------------------------
 
+// Pointer refers to the cell of the variable it was taken from, so it reads
+// and writes that variable wherever it is used and keeps it alive after its
+// scope ends. A pointer to an array element or hash entry also records the
+// indices leading to it from the variable, and finds the element again on
+// every access.
 type Pointer struct {
-	Name  string
-	Value Object
+	Name string // Variable the pointer was taken from, for messages
+	Cell *Cell
+	Path []Object // Array indices and hash keys below the variable
 }
 
 func (p *Pointer) Type() ObjectType {
@@ -286,5 +504,231 @@ func (p *Pointer) Type() ObjectType {
 }
 
 func (p *Pointer) Inspect() string {
-	return fmt.Sprintf("&%s (%s)", p.Name, p.Value.Inspect())
+	value, ok := p.Load()
+	if !ok {
+		return fmt.Sprintf("&%s (missing)", p.Target())
+	}
+	return fmt.Sprintf("&%s (%s)", p.Target(), inspectReferent(value))
+}
+
+// inspectReferent inspects the value a pointer refers to. The value may hold
+// the pointer itself, so pointers inside it only show what they refer to.
+func inspectReferent(obj Object) string {
+	switch obj := obj.(type) {
+	case *Pointer:
+		return "&" + obj.Target()
+	case *Array:
+		elements := make([]string, len(obj.Elements))
+		for i, element := range obj.Elements {
+			elements[i] = inspectReferent(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *Hash:
+		pairs := []string{}
+		for _, pair := range obj.Pairs {
+			pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), inspectReferent(pair.Value)))
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	default:
+		return obj.Inspect()
+	}
+}
+
+// Target describes what the pointer refers to, such as x, arr[3] or
+// config["port"].
+func (p *Pointer) Target() string {
+	var out strings.Builder
+
+	out.WriteString(p.Name)
+	for _, index := range p.Path {
+		if str, ok := index.(*String); ok {
+			fmt.Fprintf(&out, "[%q]", str.Value)
+		} else {
+			fmt.Fprintf(&out, "[%s]", index.Inspect())
+		}
+	}
+
+	return out.String()
+}
+
+// Load returns the value the pointer refers to. It reports false if the
+// element or entry no longer exists.
+func (p *Pointer) Load() (Object, bool) {
+	value := p.Cell.Value
+	for _, index := range p.Path {
+		element, ok := elementAt(value, index)
+		if !ok {
+			return nil, false
+		}
+		value = element
+	}
+	return value, value != nil
+}
+
+// Store replaces the value the pointer refers to. It reports false if the
+// element or entry no longer exists; it never adds one.
+func (p *Pointer) Store(value Object) bool {
+	if len(p.Path) == 0 {
+		p.Cell.Value = value
+		return true
+	}
+
+	container := p.Cell.Value
+	for _, index := range p.Path[:len(p.Path)-1] {
+		element, ok := elementAt(container, index)
+		if !ok {
+			return false
+		}
+		container = element
+	}
+
+	index := p.Path[len(p.Path)-1]
+	switch container := container.(type) {
+	case *Array:
+		position, ok := index.(*Integer)
+		if !ok || position.Value < 0 || position.Value >= int64(len(container.Elements)) {
+			return false
+		}
+		container.Elements[position.Value] = value
+		return true
+	case *Hash:
+		key, ok := index.(Hashable)
+		if !ok {
+			return false
+		}
+		pair, ok := container.Pairs[key.HashKey()]
+		if !ok {
+			return false
+		}
+		container.Pairs[key.HashKey()] = HashPair{Key: pair.Key, Value: value}
+		return true
+	}
+
+	return false
+}
+
+// elementAt returns the element of an array at a non-negative index, or the
+// value of a hash entry.
+func elementAt(container Object, index Object) (Object, bool) {
+	switch container := container.(type) {
+	case *Array:
+		position, ok := index.(*Integer)
+		if !ok || position.Value < 0 || position.Value >= int64(len(container.Elements)) {
+			return nil, false
+		}
+		return container.Elements[position.Value], true
+	case *Hash:
+		key, ok := index.(Hashable)
+		if !ok {
+			return nil, false
+		}
+		pair, ok := container.Pairs[key.HashKey()]
+		return pair.Value, ok
+	}
+
+	return nil, false
+}
+
+// ----------------------------------------------------------------------------
+// Range Object
+// ----------------------------------------------------------------------------
+
+// Range is the integers from Start up to End, including End when Inclusive
+// is set. A range whose end comes before its start is empty.
+type Range struct {
+	Start     int64
+	End       int64
+	Inclusive bool
+}
+
+func (r *Range) Type() ObjectType {
+	return RANGE_OBJ
+}
+
+func (r *Range) Inspect() string {
+	if r.Inclusive {
+		return fmt.Sprintf("%d..=%d", r.Start, r.End)
+	}
+	return fmt.Sprintf("%d..%d", r.Start, r.End)
+}
+
+// ----------------------------------------------------------------------------
+// Iterator Object
+// ----------------------------------------------------------------------------
+
+// Iterator steps through an array, hash, string or range for a for-in loop.
+// Next returns the next key and value, or false once the iterable is
+// exhausted. Iterators are internal and never reach Ember code.
+type Iterator struct {
+	Next func() (Object, Object, bool)
+}
+
+func (i *Iterator) Type() ObjectType {
+	return ITERATOR_OBJ
+}
+
+func (i *Iterator) Inspect() string {
+	return "iterator"
+}
+
+// ----------------------------------------------------------------------------
+// Callable Interface
+// ----------------------------------------------------------------------------
+
+// Callable is implemented by function values that can be invoked from Go,
+// such as closures created by the bytecode VM. Builtins like map and reduce
+// use it to call back into the engine that produced the function.
+type Callable interface {
+	Object
+	Call(args ...Object) Object
+}
+
+// ----------------------------------------------------------------------------
+// Cell Object
+// ----------------------------------------------------------------------------
+
+// Cell is a heap-allocated variable slot. The evaluator stores every binding
+// in a cell; the VM stores globals, captured locals and address-taken locals
+// in cells. Closures and pointers share the same storage as the variable.
+type Cell struct {
+	Value   Object
+	Mutable bool
+}
+
+// ----------------------------------------------------------------------------
+// CompiledFunction Object
+// ----------------------------------------------------------------------------
+
+// Capture describes where a closure's free variable comes from when the
+// closure is created: a cell in the enclosing frame's locals, the cell of a
+// global declared in a block, or one of the enclosing closure's own free
+// variables.
+type Capture struct {
+	Name   string
+	Local  bool
+	Global bool
+	Index  int
+}
+
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	Spans         []token.Span // Source span for every byte of Instructions
+	NumLocals     int
+	NumParameters int
+	LocalNames    []string  // Local variable names indexed by slot
+	Cells         []int     // Local slots that hold a *Cell
+	Free          []Capture // Free variables captured by OpClosure
+	Literal       *ast.FunctionLiteral
+}
+
+func (cf *CompiledFunction) Type() ObjectType {
+	return COMPILED_FUNCTION_OBJ
+}
+
+func (cf *CompiledFunction) Inspect() string {
+	if cf.Literal == nil {
+		return fmt.Sprintf("CompiledFunction[%p]", cf)
+	}
+
+	return inspectFunction(cf.Literal.Parameters, cf.Literal.Body)
 }
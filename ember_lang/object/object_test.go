@@ -1,6 +1,15 @@
 package object
 
-import "testing"
+import (
+	"context"
+	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/token"
+	"math"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -18,3 +27,216 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e-9, "1e-09"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect for %v. expected=%q, got=%q", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 1.5}).HashKey() != (&Float{Value: 1.5}).HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+	if (&Float{Value: 0}).HashKey() != (&Float{Value: math.Copysign(0, -1)}).HashKey() {
+		t.Errorf("zero and negative zero have different hash keys")
+	}
+	if (&Float{Value: 1.5}).HashKey() == (&Float{Value: 2.5}).HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+
+	equal := []struct {
+		float   float64
+		integer Hashable
+	}{
+		{1, &Integer{Value: 1}},
+		{-3, &Integer{Value: -3}},
+		{0, &Integer{Value: 0}},
+		{math.Copysign(0, -1), &Integer{Value: 0}},
+		{math.MinInt64, &Integer{Value: math.MinInt64}},
+		{math.Ldexp(1, 64), &BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 64)}},
+		{-math.Ldexp(1, 63), &Integer{Value: math.MinInt64}},
+		{math.Ldexp(1, 63), &BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 63)}},
+	}
+
+	for _, tt := range equal {
+		if (&Float{Value: tt.float}).HashKey() != tt.integer.HashKey() {
+			t.Errorf("float %v and the integer it equals have different hash keys", tt.float)
+		}
+	}
+
+	if (&Float{Value: math.Inf(1)}).HashKey() == (&Float{Value: math.Inf(-1)}).HashKey() {
+		t.Errorf("infinities of opposite sign have same hash keys")
+	}
+}
+
+func TestBigIntHashKey(t *testing.T) {
+	a, _ := new(big.Int).SetString("18446744073709551616", 10)
+	b := new(big.Int).Lsh(big.NewInt(1), 64)
+	negative := new(big.Int).Neg(a)
+
+	if (&BigInt{Value: a}).HashKey() != (&BigInt{Value: b}).HashKey() {
+		t.Errorf("big integers with same value have different hash keys")
+	}
+	if (&BigInt{Value: a}).HashKey() == (&BigInt{Value: negative}).HashKey() {
+		t.Errorf("big integers of opposite sign have same hash keys")
+	}
+}
+
+func TestNewFrame(t *testing.T) {
+	long := &Array{}
+	for i := 0; i < 20; i++ {
+		long.Elements = append(long.Elements, &Integer{Value: int64(i)})
+	}
+
+	// Values that contain themselves
+	cycle := &Array{Elements: []Object{nil}}
+	cycle.Elements[0] = cycle
+	self := &String{Value: "self"}
+	loop := &Hash{Pairs: map[HashKey]HashPair{}}
+	loop.Pairs[self.HashKey()] = HashPair{Key: self, Value: loop}
+	pointer := &Pointer{Name: "x", Cell: &Cell{}}
+	pointer.Cell.Value = pointer
+
+	tests := []struct {
+		name      string
+		args      []Object
+		function  string
+		arguments string
+	}{
+		{"fib", []Object{&Integer{Value: 10}}, "fib", "10"},
+		{"", nil, "<anonymous>", ""},
+		{"greet", []Object{&String{Value: "名前\n"}, &Boolean{Value: true}}, "greet", `"名前\n", true`},
+		{"apply", []Object{&Function{Body: &ast.BlockStatement{}}, &Builtin{}}, "apply", "fn, fn"},
+		{"sum", []Object{long}, "sum", "[0, 1, 2, 3, 4, 5, 6,..."},
+		{"cycle", []Object{cycle}, "cycle", "[[[[[[[[[[[[[[[[[[[[[..."},
+		{"loop", []Object{loop}, "loop", `{"self": {"self": {"s...`},
+		{"at", []Object{pointer}, "at", "&x (&x)"},
+	}
+
+	for _, tt := range tests {
+		frame := NewFrame(tt.name, tt.args, token.Span{})
+		if frame.Function != tt.function || frame.Arguments != tt.arguments {
+			t.Errorf("wrong frame for %s. want=%s(%s), got=%s(%s)",
+				tt.name, tt.function, tt.arguments, frame.Function, frame.Arguments)
+		}
+	}
+}
+
+// inspectCounter is an argument that counts how often it is formatted.
+type inspectCounter struct{ inspected int }
+
+func (c *inspectCounter) Type() ObjectType { return INTEGER_OBJ }
+func (c *inspectCounter) Inspect() string {
+	c.inspected++
+	return "counter"
+}
+
+func TestCallStackSummarizesOnlyForTraces(t *testing.T) {
+	arg := &inspectCounter{}
+	calls := NewCallStack()
+	for i := 0; i < 100; i++ {
+		calls.Push("f", []Object{arg}, token.Span{})
+	}
+	for i := 0; i < 50; i++ {
+		calls.Pop()
+	}
+	if arg.inspected != 0 {
+		t.Fatalf("arguments formatted %d times without a trace", arg.inspected)
+	}
+
+	trace := calls.Trace()
+	if trace.Depth() != 50 || arg.inspected != 0 {
+		t.Fatalf("wrong trace. got depth=%d, arguments formatted %d times", trace.Depth(), arg.inspected)
+	}
+
+	frames := trace.Frames()
+	if len(frames) != 20 || frames[0].Function != "f" || frames[0].Arguments != "counter" {
+		t.Fatalf("wrong frames. got=%d frames, first=%+v", len(frames), frames[0])
+	}
+	if arg.inspected != 20 {
+		t.Errorf("arguments formatted %d times for the 20 frames kept", arg.inspected)
+	}
+}
+
+func BenchmarkCallStackPush(b *testing.B) {
+	for _, size := range []int{1, 20000} {
+		arr := &Array{Elements: make([]Object, size)}
+		for i := range arr.Elements {
+			arr.Elements[i] = &Integer{Value: int64(i)}
+		}
+		args := []Object{arr}
+
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			calls := NewCallStack()
+			for i := 0; i < b.N; i++ {
+				calls.Push("f", args, token.Span{})
+				calls.Pop()
+			}
+		})
+	}
+}
+
+func TestBudget(t *testing.T) {
+	var budget Budget
+	for i := 0; i < 5000; i++ {
+		if err := budget.Step(); err != nil {
+			t.Fatalf("budget not started, but step %d failed: %s", i, err.Message)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		ctx      context.Context
+		limits   Limits
+		steps    int
+		expected string
+		halt     HaltReason
+	}{
+		{context.Background(), Limits{}, 5000, "", NotHalted},
+		{context.Background(), Limits{MaxSteps: 10}, 10, "", NotHalted},
+		{context.Background(), Limits{MaxSteps: 10}, 11, "Budget exceeded: more than 10 steps", BudgetExceeded},
+		{context.Background(), Limits{Deadline: time.Now().Add(-time.Second)}, 1, "Budget exceeded: deadline passed", BudgetExceeded},
+		{context.Background(), Limits{Deadline: time.Now().Add(time.Hour)}, 5000, "", NotHalted},
+		{cancelled, Limits{}, 1, "Execution cancelled", Cancelled},
+	}
+
+	for i, tt := range tests {
+		budget.Start(tt.ctx, tt.limits)
+
+		var err *Error
+		for step := 0; step < tt.steps && err == nil; step++ {
+			err = budget.Step()
+		}
+		budget.Stop()
+
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("test %d: unexpected error %q", i, err.Message)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("test %d: expected error %q, got none", i, tt.expected)
+			continue
+		}
+		if err.Message != tt.expected || err.Halt != tt.halt {
+			t.Errorf("test %d: wrong error. expected=%q (%d), got=%q (%d)", i, tt.expected, tt.halt, err.Message, err.Halt)
+		}
+	}
+}
@@ -2,21 +2,32 @@ package parser
 
 import (
 	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/diagnostic"
 	"ember_lang/ember_lang/lexer"
 	"ember_lang/ember_lang/token"
-	"fmt"
+	"errors"
+	"math/big"
+	"sort"
 	"strconv"
 )
 
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // =
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
-	ASSIGN      // =
+	RANGE       // .. or ..=
+	BITWISE_OR  // |
+	BITWISE_XOR // ^
+	BITWISE_AND // &
+	SHIFT       // << or >>
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
+	POWER       // **
 	CALL        // myFunction(X)
 	INCREMENT   // i++
 	INDEX       // array[index]
@@ -33,10 +44,21 @@ var precedences = map[token.TokenType]int{
 	token.MINUS:     SUM,
 	token.SLASH:     PRODUCT,
 	token.ASTERISK:  PRODUCT,
+	token.PERCENT:   PRODUCT,
+	token.POWER:     POWER,
+	token.AMPERSAND: BITWISE_AND,
+	token.PIPE:      BITWISE_OR,
+	token.CARET:     BITWISE_XOR,
+	token.SHL:       SHIFT,
+	token.SHR:       SHIFT,
+	token.DOTDOT:    RANGE,
+	token.DOTDOTEQ:  RANGE,
 	token.LPAREN:    CALL,
 	token.INCREMENT: INCREMENT,
 	token.LBRACKET:  INDEX,
 	token.ASSIGN:    ASSIGN,
+	token.AND:       LOGICAL_AND,
+	token.OR:        LOGICAL_OR,
 }
 
 func (parser *Parser) peekPrecedence() int {
@@ -64,7 +86,17 @@ type (
 
 type Parser struct {
 	lexer  *lexer.Lexer
-	errors []string
+	errors []*diagnostic.Diagnostic
+
+	// Set after a syntax error until the parser has skipped to the next
+	// statement, so a single mistake is only reported once.
+	panicking bool
+
+	depth int // Number of '{' consumed and not yet closed
+
+	// One entry per loop around the statement being parsed, plus one for
+	// each loop label in scope. Reset inside functions.
+	loops []string
 
 	curToken  token.Token
 	peekToken token.Token
@@ -74,12 +106,13 @@ type Parser struct {
 }
 
 func New(lexer *lexer.Lexer) *Parser {
-	parser := &Parser{lexer: lexer, errors: []string{}}
+	parser := &Parser{lexer: lexer, errors: []*diagnostic.Diagnostic{}}
 
 	// Prefix parse functions
 	parser.prefixParseFns = make(map[token.TokenType]PrefixParseFn)
 	parser.registerPrefix(token.IDENTIFIER, parser.parseIdentifier)
 	parser.registerPrefix(token.INT, parser.parseIntegerLiteral)
+	parser.registerPrefix(token.FLOAT, parser.parseFloatLiteral)
 	parser.registerPrefix(token.BANG, parser.parsePrefixExpression)
 	parser.registerPrefix(token.MINUS, parser.parsePrefixExpression)
 	parser.registerPrefix(token.PLUS, parser.parsePrefixExpression)
@@ -87,14 +120,18 @@ func New(lexer *lexer.Lexer) *Parser {
 	parser.registerPrefix(token.FALSE, parser.parseBooleanLiteral)
 	parser.registerPrefix(token.LPAREN, parser.parseGroupedExpression)
 	parser.registerPrefix(token.IF, parser.parseIfExpression)
+	parser.registerPrefix(token.TRY, parser.parseTryExpression)
 	parser.registerPrefix(token.FUNCTION, parser.parseFunctionLiteral)
 	parser.registerPrefix(token.STRING, parser.parseStringLiteral)
+	parser.registerPrefix(token.STRING_HEAD, parser.parseInterpolatedString)
 	parser.registerPrefix(token.LBRACKET, parser.parseArrayLiteral)
 	parser.registerPrefix(token.LBRACE, parser.parseHashLiteral)
 	parser.registerPrefix(token.WHILE, parser.parseWhileExpression)
 	parser.registerPrefix(token.FOR, parser.parseForExpression)
 	parser.registerPrefix(token.AMPERSAND, parser.parsePointerReferenceExpression)
 	parser.registerPrefix(token.ASTERISK, parser.parsePointerDereferenceExpression)
+	parser.registerPrefix(token.POWER, parser.parseDoubleDereferenceExpression)
+	parser.registerPrefix(token.TILDE, parser.parsePrefixExpression)
 
 	// Infix parse functions
 	parser.infixParseFns = make(map[token.TokenType]InfixParseFn)
@@ -112,6 +149,17 @@ func New(lexer *lexer.Lexer) *Parser {
 	parser.registerInfix(token.LBRACKET, parser.parseIndexExpression)
 	parser.registerInfix(token.INCREMENT, parser.parseIncrementExpression)
 	parser.registerInfix(token.ASSIGN, parser.parseAssignmentExpression)
+	parser.registerInfix(token.AND, parser.parseInfixExpression)
+	parser.registerInfix(token.OR, parser.parseInfixExpression)
+	parser.registerInfix(token.PERCENT, parser.parseInfixExpression)
+	parser.registerInfix(token.POWER, parser.parseInfixExpression)
+	parser.registerInfix(token.AMPERSAND, parser.parseInfixExpression)
+	parser.registerInfix(token.PIPE, parser.parseInfixExpression)
+	parser.registerInfix(token.CARET, parser.parseInfixExpression)
+	parser.registerInfix(token.SHL, parser.parseInfixExpression)
+	parser.registerInfix(token.SHR, parser.parseInfixExpression)
+	parser.registerInfix(token.DOTDOT, parser.parseRangeExpression)
+	parser.registerInfix(token.DOTDOTEQ, parser.parseRangeExpression)
 
 	// Read two tokens, so curToken and peekToken are both set
 	parser.nextToken()
@@ -128,9 +176,26 @@ func (parser *Parser) parseIntegerLiteral() ast.Expression {
 	literal := &ast.IntegerLiteral{Token: parser.curToken}
 
 	value, err := strconv.ParseInt(parser.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		literal.Big, _ = new(big.Int).SetString(parser.curToken.Literal, 0)
+		return literal
+	}
+	if err != nil {
+		parser.errorAt(diagnostic.InvalidInteger, parser.curToken.Span, "could not parse %q as integer", parser.curToken.Literal)
+		return nil
+	}
+
+	literal.Value = value
+	return literal
+}
+
+func (parser *Parser) parseFloatLiteral() ast.Expression {
+	literal := &ast.FloatLiteral{Token: parser.curToken}
+
+	value, err := strconv.ParseFloat(parser.curToken.Literal, 64)
 	if err != nil {
-		message := fmt.Sprintf("(line %d) could not parse %q as integer", parser.curToken.LineNumber, parser.curToken.Literal)
-		parser.errors = append(parser.errors, message)
+		parser.errorAt(diagnostic.InvalidFloat, parser.curToken.Span, "could not parse %q as float", parser.curToken.Literal).
+			WithNote("floats must fit in 64 bits")
 		return nil
 	}
 
@@ -148,9 +213,35 @@ func (parser *Parser) parsePrefixExpression() ast.Expression {
 
 	expression.Right = parser.parseExpression(PREFIX)
 
+	// The magnitude of the smallest 64-bit integer does not fit in 64 bits,
+	// so the sign is folded into a literal that is too large on its own
+	literal, ok := expression.Right.(*ast.IntegerLiteral)
+	if ok && expression.Operator == "-" && literal.Big != nil {
+		return negateIntegerLiteral(expression.Token, literal)
+	}
+
 	return expression
 }
 
+// negateIntegerLiteral folds a minus sign into the big integer literal after
+// it, so -9223372036854775808 is a 64-bit integer.
+func negateIntegerLiteral(minus token.Token, literal *ast.IntegerLiteral) *ast.IntegerLiteral {
+	negated := &ast.IntegerLiteral{
+		Token: token.Token{
+			Type:    literal.Token.Type,
+			Literal: minus.Literal + literal.Token.Literal,
+			Span:    minus.Span.Join(literal.Token.Span),
+		},
+		Big: new(big.Int).Neg(literal.Big),
+	}
+
+	if negated.Big.IsInt64() {
+		negated.Value, negated.Big = negated.Big.Int64(), nil
+	}
+
+	return negated
+}
+
 func (parser *Parser) parseBooleanLiteral() ast.Expression {
 	return &ast.Boolean{Token: parser.curToken, Value: parser.curTokenIs(token.TRUE)}
 }
@@ -160,9 +251,7 @@ func (parser *Parser) parseGroupedExpression() ast.Expression {
 
 	expression := parser.parseExpression(LOWEST)
 
-	if parser.peekTokenIs(token.RPAREN) {
-		parser.nextToken()
-	}
+	parser.expectPeek(token.RPAREN)
 
 	return expression
 }
@@ -201,6 +290,53 @@ func (parser *Parser) parseIfExpression() ast.Expression {
 
 }
 
+func (parser *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: parser.curToken}
+
+	if !parser.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Body = parser.parseBlockStatement()
+
+	if parser.peekTokenIs(token.CATCH) {
+		parser.nextToken()
+
+		if parser.peekTokenIs(token.LPAREN) {
+			parser.nextToken()
+			if !parser.expectPeek(token.IDENTIFIER) {
+				return nil
+			}
+			expression.Parameter = &ast.Identifier{Token: parser.curToken, Value: parser.curToken.Literal}
+			if !parser.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+
+		if !parser.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = parser.parseBlockStatement()
+	}
+
+	if parser.peekTokenIs(token.FINALLY) {
+		parser.nextToken()
+
+		if !parser.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = parser.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		parser.errorAt(diagnostic.ExpectedToken, parser.peekToken.Span,
+			"expected next token to be: CATCH or FINALLY, got: %s (%s) instead.", parser.peekToken.Type, parser.peekToken.Literal).
+			WithNote("a try block needs a catch block, a finally block or both")
+		return nil
+	}
+
+	return expression
+}
+
 func (parser *Parser) parseWhileExpression() ast.Expression {
 	expression := &ast.WhileExpression{Token: parser.curToken}
 
@@ -219,7 +355,7 @@ func (parser *Parser) parseWhileExpression() ast.Expression {
 		return nil
 	}
 
-	expression.Body = parser.parseBlockStatement()
+	expression.Body = parser.parseLoopBody()
 
 	return expression
 
@@ -233,6 +369,12 @@ func (parser *Parser) parseForExpression() ast.Expression {
 	}
 
 	parser.nextToken()
+
+	// `for (x in xs)` and `for (i, x in xs)` start with a name, the C-style
+	// loop with `let`
+	if parser.curTokenIs(token.IDENTIFIER) && (parser.peekTokenIs(token.IN) || parser.peekTokenIs(token.COMMA)) {
+		return parser.parseForInExpression(expression.Token)
+	}
 	expression.LetStatement = parser.parseForLoopLetStatement()
 
 	if !parser.expectPeek(token.SEMICOLON) {
@@ -253,11 +395,63 @@ func (parser *Parser) parseForExpression() ast.Expression {
 	parser.nextToken()
 	expression.Increment = parser.parseIncrementExpression(left)
 
+	if !parser.expectPeek(token.RPAREN) {
+		return nil
+	}
+
 	if !parser.expectPeek(token.LBRACE) {
 		return nil
 	}
 
-	expression.Body = parser.parseBlockStatement()
+	expression.Body = parser.parseLoopBody()
+
+	return expression
+}
+
+func (parser *Parser) parseForInExpression(forToken token.Token) ast.Expression {
+	expression := &ast.ForInExpression{Token: forToken}
+
+	expression.Value = &ast.Identifier{Token: parser.curToken, Value: parser.curToken.Literal}
+
+	if parser.peekTokenIs(token.COMMA) {
+		parser.nextToken()
+		if !parser.expectPeek(token.IDENTIFIER) {
+			return nil
+		}
+		expression.Key = expression.Value
+		expression.Value = &ast.Identifier{Token: parser.curToken, Value: parser.curToken.Literal}
+	}
+
+	if !parser.expectPeek(token.IN) {
+		return nil
+	}
+
+	parser.nextToken()
+	expression.Iterable = parser.parseExpression(LOWEST)
+
+	if !parser.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !parser.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = parser.parseLoopBody()
+
+	return expression
+}
+
+func (parser *Parser) parseRangeExpression(start ast.Expression) ast.Expression {
+	expression := &ast.RangeExpression{
+		Token:     parser.curToken,
+		Start:     start,
+		Inclusive: parser.curTokenIs(token.DOTDOTEQ),
+	}
+
+	precedence := parser.curPrecedence()
+	parser.nextToken()
+	expression.End = parser.parseExpression(precedence)
 
 	return expression
 }
@@ -295,7 +489,13 @@ func (parser *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	// break and continue cannot reach loops outside the function
+	loops := parser.loops
+	parser.loops = nil
 	literal.Body = parser.parseBlockStatement()
+	parser.loops = loops
+
+	ast.MarkTailCalls(literal)
 
 	return literal
 }
@@ -304,16 +504,52 @@ func (parser *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: parser.curToken, Value: parser.curToken.Literal}
 }
 
+func (parser *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: parser.curToken}
+
+	for {
+		if parser.curToken.Literal != "" {
+			str.Parts = append(str.Parts, parser.parseStringLiteral())
+		}
+		if parser.curTokenIs(token.STRING_TAIL) {
+			break
+		}
+
+		if parser.peekTokenIs(token.STRING_MIDDLE) || parser.peekTokenIs(token.STRING_TAIL) {
+			parser.errorAt(diagnostic.UnexpectedToken, parser.curToken.Span.Join(parser.peekToken.Span),
+				"empty interpolation in string").
+				WithHint("put an expression between ${ and }, or write \\${ for a literal ${")
+			return nil
+		}
+
+		parser.nextToken()
+		str.Parts = append(str.Parts, parser.parseExpression(LOWEST))
+
+		if !parser.peekTokenIs(token.STRING_MIDDLE) && !parser.peekTokenIs(token.STRING_TAIL) {
+			parser.peekError(token.RBRACE)
+			return nil
+		}
+		parser.nextToken()
+	}
+	str.Tail = parser.curToken
+
+	return str
+}
+
 func (parser *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: parser.curToken}
 
 	array.Elements = parser.parseExpressionList(token.RBRACKET)
+	if parser.curTokenIs(token.RBRACKET) {
+		array.RBracket = parser.curToken
+	}
 
 	return array
 }
 
 func (parser *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: parser.curToken}
+	level := parser.depth
 
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
 
@@ -322,7 +558,7 @@ func (parser *Parser) parseHashLiteral() ast.Expression {
 
 		key := parser.parseExpression(LOWEST)
 		if !parser.expectPeek(token.COLON) {
-			return nil
+			return parser.abandonHashLiteral(level)
 		}
 
 		parser.nextToken()
@@ -331,36 +567,71 @@ func (parser *Parser) parseHashLiteral() ast.Expression {
 		hash.Pairs[key] = value
 
 		if !parser.peekTokenIs(token.RBRACE) && !parser.expectPeek(token.COMMA) {
-			return nil
+			return parser.abandonHashLiteral(level)
 		}
 	}
 
 	if !parser.expectPeek(token.RBRACE) {
-		return nil
+		return parser.abandonHashLiteral(level)
 	}
+	hash.RBrace = parser.curToken
 
 	return hash
 }
 
-func (parser *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	expression := &ast.IndexExpression{Token: parser.curToken, Left: left}
+// abandonHashLiteral gives up on a hash literal whose '{' left the braces
+// nested level deep. A hash that failed where a statement ends was never
+// closed, so its '{' stops counting: recovery would otherwise look for its
+// '}' and skip the rest of the program.
+func (parser *Parser) abandonHashLiteral(level int) ast.Expression {
+	unclosed := parser.curTokenIs(token.SEMICOLON)
+	switch parser.peekToken.Type {
+	case token.SEMICOLON, token.LET, token.RETURN, token.THROW, token.BREAK, token.CONTINUE, token.EOF:
+		unclosed = true
+	}
 
+	if unclosed && parser.depth == level {
+		parser.depth--
+	}
+	return nil
+}
+
+func (parser *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	lbracket := parser.curToken
 	parser.nextToken()
-	expression.Index = parser.parseExpression(LOWEST)
+
+	var index ast.Expression
+	if !parser.curTokenIs(token.COLON) {
+		index = parser.parseExpression(LOWEST)
+		if !parser.peekTokenIs(token.COLON) {
+			expression := &ast.IndexExpression{Token: lbracket, Left: left, Index: index}
+			if !parser.expectPeek(token.RBRACKET) {
+				return nil
+			}
+			expression.RBracket = parser.curToken
+			return expression
+		}
+		parser.nextToken()
+	}
+
+	// A colon makes it a slice, left[start:end], where either bound may be
+	// left out
+	slice := &ast.SliceExpression{Token: lbracket, Left: left, Start: index}
+	if !parser.peekTokenIs(token.RBRACKET) {
+		parser.nextToken()
+		slice.End = parser.parseExpression(LOWEST)
+	}
 
 	if !parser.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	slice.RBracket = parser.curToken
 
-	return expression
+	return slice
 }
 
 func (parser *Parser) parseIncrementExpression(left ast.Expression) ast.Expression {
-	expression := &ast.IncrementExpression{Token: parser.curToken, Left: left}
-
-	parser.nextToken()
-
-	return expression
+	return &ast.IncrementExpression{Token: parser.curToken, Left: left}
 }
 
 func (parser *Parser) parseFunctionParameters() []*ast.Identifier {
@@ -397,6 +668,10 @@ func (parser *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := parser.curPrecedence()
+	if parser.curTokenIs(token.POWER) {
+		// ** is right-associative: 2 ** 3 ** 2 is 2 ** (3 ** 2)
+		precedence--
+	}
 	parser.nextToken()
 	expression.Right = parser.parseExpression(precedence)
 
@@ -407,6 +682,9 @@ func (parser *Parser) parseCallExpression(function ast.Expression) ast.Expressio
 	expression := &ast.CallExpression{Token: parser.curToken, Function: function}
 
 	expression.Arguments = parser.parseExpressionList(token.RPAREN)
+	if parser.curTokenIs(token.RPAREN) {
+		expression.RParen = parser.curToken
+	}
 
 	return expression
 }
@@ -429,21 +707,85 @@ func (parser *Parser) parseExpressionList(end token.TokenType) []ast.Expression
 		list = append(list, parser.parseExpression(LOWEST))
 	}
 
-	if parser.peekTokenIs(end) {
-		parser.nextToken()
-	}
+	parser.expectPeek(end)
 
 	return list
 }
 
-func (p *Parser) Errors() []string {
-	return p.errors
+// Errors returns the syntax errors found by the lexer and the parser, in
+// source order.
+func (p *Parser) Errors() []*diagnostic.Diagnostic {
+	errors := append(append([]*diagnostic.Diagnostic{}, p.lexer.Diagnostics()...), p.errors...)
+	sort.SliceStable(errors, func(i, j int) bool {
+		return errors[i].Span.Start.Offset < errors[j].Span.Start.Offset
+	})
+	return errors
+}
+
+// errorAt reports a syntax error. Errors raised while the parser is still
+// recovering from an earlier one are follow-on errors and are dropped.
+func (p *Parser) errorAt(code string, span token.Span, format string, a ...interface{}) *diagnostic.Diagnostic {
+	d := diagnostic.New(code, span, format, a...)
+	if !p.panicking {
+		p.errors = append(p.errors, d)
+		p.panicking = true
+	}
+	return d
+}
+
+// delimiters maps the tokens the parser expects by name to their source text,
+// for hints.
+var delimiters = map[token.TokenType]string{
+	token.ASSIGN:    "=",
+	token.COMMA:     ",",
+	token.SEMICOLON: ";",
+	token.COLON:     ":",
+	token.LPAREN:    "(",
+	token.RPAREN:    ")",
+	token.LBRACE:    "{",
+	token.RBRACE:    "}",
+	token.LBRACKET:  "[",
+	token.RBRACKET:  "]",
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	message := fmt.Sprintf("\x1b[31m (line %d) expected next token to be: %s, got: %s (%s) instead.\x1b[0m",
-		p.peekToken.LineNumber, t, p.peekToken.Type, p.peekToken.Literal)
-	p.errors = append(p.errors, message)
+	// The lexer has already reported characters it could not read and
+	// strings it could not finish
+	if p.peekTokenIs(token.ILLEGAL) || p.lexerReported(p.peekToken) {
+		p.panicking = true
+		return
+	}
+
+	d := p.errorAt(diagnostic.ExpectedToken, p.peekToken.Span, "expected next token to be: %s, got: %s (%s) instead.",
+		t, p.peekToken.Type, tokenText(p.peekToken.Literal))
+
+	if literal, ok := delimiters[t]; ok {
+		d.WithHint("insert %q here", literal)
+	}
+}
+
+// lexerReported reports whether the lexer found a problem starting at tok,
+// such as a string literal that is never closed.
+func (p *Parser) lexerReported(tok token.Token) bool {
+	for _, d := range p.lexer.Diagnostics() {
+		if d.Span.Start == tok.Span.Start {
+			return true
+		}
+	}
+	return false
+}
+
+// maxTokenText is how many characters of a token an error message shows.
+const maxTokenText = 20
+
+// tokenText returns the literal of a token for an error message: on one line,
+// with control characters escaped, and cut short if it is long.
+func tokenText(literal string) string {
+	if runes := []rune(literal); len(runes) > maxTokenText {
+		literal = string(runes[:maxTokenText-3]) + "..."
+	}
+	quoted := strconv.Quote(literal)
+	return quoted[1 : len(quoted)-1]
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -477,6 +819,15 @@ func (parser *Parser) nextToken() {
 	if parser.peekToken.Type == token.COMMENT {
 		parser.peekToken = parser.lexer.NextToken()
 	}
+
+	switch parser.curToken.Type {
+	case token.LBRACE:
+		parser.depth++
+	case token.RBRACE:
+		if parser.depth > 0 {
+			parser.depth--
+		}
+	}
 }
 
 func (parser *Parser) registerPrefix(tokenType token.TokenType, fn PrefixParseFn) {
@@ -493,8 +844,13 @@ func (parser *Parser) ParseProgram() *ast.Program {
 
 	for parser.curToken.Type != token.EOF {
 		statement := parser.parseStatement()
+		if statement != nil {
+			program.Statements = append(program.Statements, statement)
+		}
 
-		program.Statements = append(program.Statements, statement)
+		if parser.panicking {
+			parser.synchronize(0)
+		}
 
 		parser.nextToken()
 	}
@@ -505,15 +861,62 @@ func (parser *Parser) ParseProgram() *ast.Program {
 func (parser *Parser) parseStatement() ast.Statement {
 	switch parser.curToken.Type {
 	case token.LET:
-		return parser.parseLetStatement()
+		// Avoid returning a typed nil for a let statement that failed to parse
+		if statement := parser.parseLetStatement(); statement != nil {
+			return statement
+		}
+		return nil
 	case token.RETURN:
 		return parser.parseReturnStatement()
+	case token.THROW:
+		return parser.parseThrowStatement()
+	case token.BREAK, token.CONTINUE:
+		return parser.parseLoopControlStatement()
+	case token.IDENTIFIER:
+		if parser.peekTokenIs(token.COLON) {
+			return parser.parseLabeledStatement()
+		}
+		return parser.parseExpressionStatement()
 	default:
 		return parser.parseExpressionStatement()
 	}
 
 }
 
+// synchronize skips the rest of a statement that failed to parse, in a
+// statement list whose braces are nested level deep. It stops on the
+// statement's closing ';' or '}', before a token that starts a new statement,
+// or on the '}' that closes the enclosing block. The next statement then
+// parses from a known state.
+func (parser *Parser) synchronize(level int) {
+	parser.panicking = false
+
+	for !parser.curTokenIs(token.EOF) && parser.depth >= level {
+		if parser.depth == level {
+			if parser.curTokenIs(token.SEMICOLON) {
+				return
+			}
+
+			// An else, catch or finally clause belongs to the statement
+			// being skipped
+			if parser.curTokenIs(token.RBRACE) && !parser.peekTokenIs(token.ELSE) &&
+				!parser.peekTokenIs(token.CATCH) && !parser.peekTokenIs(token.FINALLY) {
+				if parser.peekTokenIs(token.SEMICOLON) {
+					parser.nextToken()
+				}
+				return
+			}
+
+			switch parser.peekToken.Type {
+			case token.LET, token.RETURN, token.THROW, token.BREAK, token.CONTINUE, token.FUNCTION, token.RBRACE, token.EOF:
+				return
+			}
+		}
+
+		parser.nextToken()
+	}
+}
+
 func (parser *Parser) parseLetStatement() *ast.LetStatement {
 	statement := &ast.LetStatement{Token: parser.curToken}
 	mutable := false
@@ -541,6 +944,10 @@ func (parser *Parser) parseLetStatement() *ast.LetStatement {
 
 	statement.Value = parser.parseExpression(LOWEST)
 
+	if function, ok := statement.Value.(*ast.FunctionLiteral); ok {
+		function.Name = statement.Name.Value
+	}
+
 	if parser.peekTokenIs(token.SEMICOLON) {
 		parser.nextToken()
 	}
@@ -562,6 +969,117 @@ func (parser *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return statement
 }
 
+// parseLoopControlStatement parses `break` or `continue`, with an optional
+// label naming the enclosing loop it applies to.
+func (parser *Parser) parseThrowStatement() *ast.ThrowStatement {
+	statement := &ast.ThrowStatement{Token: parser.curToken}
+
+	parser.nextToken()
+
+	statement.Value = parser.parseExpression(LOWEST)
+
+	if parser.peekTokenIs(token.SEMICOLON) {
+		parser.nextToken()
+	}
+
+	return statement
+}
+
+func (parser *Parser) parseLoopControlStatement() ast.Statement {
+	keyword := parser.curToken
+
+	var label *ast.Identifier
+	if parser.peekTokenIs(token.IDENTIFIER) {
+		parser.nextToken()
+		label = &ast.Identifier{Token: parser.curToken, Value: parser.curToken.Literal}
+	}
+
+	var statement ast.Statement
+	if keyword.Type == token.BREAK {
+		statement = &ast.BreakStatement{Token: keyword, Label: label}
+	} else {
+		statement = &ast.ContinueStatement{Token: keyword, Label: label}
+	}
+
+	switch {
+	case len(parser.loops) == 0:
+		parser.errorAt(diagnostic.OutsideLoop, statement.Span(), "%s outside of a loop", keyword.Literal)
+	case label != nil && !parser.inLoop(label.Value):
+		parser.errorAt(diagnostic.UndefinedLabel, label.Span(), "undefined loop label: %s", label.Value).
+			WithNote("labels name an enclosing loop, as in `%s: while (...) { ... }`", label.Value)
+	}
+
+	if parser.peekTokenIs(token.SEMICOLON) {
+		parser.nextToken()
+	}
+
+	return statement
+}
+
+func (parser *Parser) inLoop(label string) bool {
+	for _, loop := range parser.loops {
+		if loop == label {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLabeledStatement parses a loop preceded by a label: `outer: for ...`.
+func (parser *Parser) parseLabeledStatement() ast.Statement {
+	label := &ast.Identifier{Token: parser.curToken, Value: parser.curToken.Literal}
+	parser.nextToken()
+
+	statement := &ast.ExpressionStatement{Token: label.Token}
+
+	switch parser.peekToken.Type {
+	case token.WHILE:
+		parser.nextToken()
+		statement.Expression = parser.parseLoop(label, parser.parseWhileExpression)
+	case token.FOR:
+		parser.nextToken()
+		statement.Expression = parser.parseLoop(label, parser.parseForExpression)
+	default:
+		parser.errorAt(diagnostic.ExpectedToken, parser.peekToken.Span, "expected a loop after label %s, got: %s (%s) instead.",
+			label.Value, parser.peekToken.Type, parser.peekToken.Literal)
+		return nil
+	}
+
+	if parser.peekTokenIs(token.SEMICOLON) {
+		parser.nextToken()
+	}
+
+	return statement
+}
+
+// parseLoop parses a labeled loop with the given parse function and attaches
+// the label to it.
+func (parser *Parser) parseLoop(label *ast.Identifier, parse PrefixParseFn) ast.Expression {
+	parser.loops = append(parser.loops, label.Value)
+	loop := parse()
+	parser.loops = parser.loops[:len(parser.loops)-1]
+
+	switch loop := loop.(type) {
+	case *ast.WhileExpression:
+		loop.Label = label
+	case *ast.ForExpression:
+		loop.Label = label
+	case *ast.ForInExpression:
+		loop.Label = label
+	}
+
+	return loop
+}
+
+// parseLoopBody parses the block of a loop, with break and continue allowed
+// inside it.
+func (parser *Parser) parseLoopBody() *ast.BlockStatement {
+	parser.loops = append(parser.loops, "")
+	defer func() { parser.loops = parser.loops[:len(parser.loops)-1] }()
+
+	return parser.parseBlockStatement()
+}
+
 func (parser *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	statement := &ast.ExpressionStatement{Token: parser.curToken}
 
@@ -579,10 +1097,10 @@ func (parser *Parser) parseExpression(precedence int) ast.Expression {
 
 	if prefix == nil {
 		parser.noPrefixParseFnError(parser.curToken.Type)
-		return nil
+		return &ast.BadExpression{Token: parser.curToken}
 	}
 
-	leftExp := prefix()
+	leftExp := parser.orBad(prefix())
 
 	for !parser.peekTokenIs(token.SEMICOLON) && precedence < parser.peekPrecedence() {
 		infix := parser.infixParseFns[parser.peekToken.Type]
@@ -593,30 +1111,64 @@ func (parser *Parser) parseExpression(precedence int) ast.Expression {
 
 		parser.nextToken()
 
-		leftExp = infix(leftExp)
+		leftExp = parser.orBad(infix(leftExp))
 	}
 
 	return leftExp
 }
 
+// orBad returns expression, or a placeholder at the current token if it
+// failed to parse, so a tree with syntax errors has no missing expressions.
+func (parser *Parser) orBad(expression ast.Expression) ast.Expression {
+	if expression == nil {
+		return &ast.BadExpression{Token: parser.curToken}
+	}
+	return expression
+}
+
 func (parser *Parser) noPrefixParseFnError(t token.TokenType) {
-	message := fmt.Sprintf("\x1b[31m (line %d) no prefix parse function for %s found\x1b[0m", parser.curToken.LineNumber, t)
-	parser.errors = append(parser.errors, message)
+	// The lexer has already reported characters it could not read
+	if t == token.ILLEGAL {
+		parser.panicking = true
+		return
+	}
+
+	parser.errorAt(diagnostic.UnexpectedToken, parser.curToken.Span, "no prefix parse function for %s found", t).
+		WithNote("expected an expression, found %q", parser.curToken.Literal)
 }
 
 func (parser *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: parser.curToken}
 	block.Statements = []ast.Statement{}
+	level := parser.depth
 
 	parser.nextToken()
 
 	for !parser.curTokenIs(token.RBRACE) && parser.curToken.Type != token.EOF {
 		statement := parser.parseStatement()
-		block.Statements = append(block.Statements, statement)
+		if statement != nil {
+			block.Statements = append(block.Statements, statement)
+		}
+
+		if parser.panicking {
+			parser.synchronize(level)
+
+			// Recovery stopped on the brace that closes this block
+			if parser.depth < level {
+				break
+			}
+		}
 
 		parser.nextToken()
 	}
 
+	if parser.curTokenIs(token.RBRACE) {
+		block.RBrace = parser.curToken
+	} else {
+		parser.errorAt(diagnostic.ExpectedToken, block.Token.Span, "unclosed block").
+			WithHint("add a closing '}'")
+	}
+
 	return block
 }
 
@@ -630,7 +1182,8 @@ func (parser *Parser) parseAssignmentExpression(left ast.Expression) ast.Express
 	}
 
 	if !isValidTarget {
-		parser.errors = append(parser.errors, fmt.Sprintf("(line %d) invalid assignment target: %s", parser.curToken.LineNumber, left.TokenLiteral()))
+		parser.errorAt(diagnostic.InvalidAssignment, left.Span(), "invalid assignment target: %s", left.TokenLiteral()).
+			WithNote("only variables, index expressions and dereferenced pointers can be assigned to")
 	}
 
 	expression := &ast.AssignmentExpression{
@@ -638,9 +1191,9 @@ func (parser *Parser) parseAssignmentExpression(left ast.Expression) ast.Express
 		Left:  left,
 	}
 
-	precedence := parser.curPrecedence()
+	// Assignment is right-associative: a = b = c assigns c to both.
 	parser.nextToken()
-	expression.Right = parser.parseExpression(precedence)
+	expression.Right = parser.parseExpression(ASSIGN - 1)
 
 	return expression
 }
@@ -661,6 +1214,30 @@ func (parser *Parser) parsePointerReferenceExpression() ast.Expression {
 	return expression
 }
 
+// parseDoubleDereferenceExpression handles ** in prefix position, where the
+// lexer has merged two dereference operators into one token: **p is *(*p).
+func (parser *Parser) parseDoubleDereferenceExpression() ast.Expression {
+	outer, inner := parser.curToken, parser.curToken
+	outer.Type, outer.Literal = token.ASTERISK, "*"
+	inner.Type, inner.Literal = token.ASTERISK, "*"
+	outer.Span.End = token.Position{
+		Offset: outer.Span.Start.Offset + 1,
+		Line:   outer.Span.Start.Line,
+		Column: outer.Span.Start.Column + 1,
+	}
+	inner.Span.Start = outer.Span.End
+
+	parser.nextToken()
+
+	return &ast.PointerDereferenceExpression{
+		Token: outer,
+		Right: &ast.PointerDereferenceExpression{
+			Token: inner,
+			Right: parser.parseExpression(PREFIX),
+		},
+	}
+}
+
 func (parser *Parser) parsePointerDereferenceExpression() ast.Expression {
 	// Save the current token for the expression
 	currentToken := parser.curToken
@@ -2,7 +2,10 @@ package parser
 
 import (
 	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/diagnostic"
 	"ember_lang/ember_lang/lexer"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -111,6 +114,75 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	testLiteralExpression(t, statement.Expression, 5)
 }
 
+func TestNegativeBigIntegerLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		folded   bool
+	}{
+		{"-9223372036854775808", "-9223372036854775808", true},
+		{"-0x8000000000000000", "-0x8000000000000000", true},
+		{"-99999999999999999999", "-99999999999999999999", true},
+		{"-5", "(-5)", false},
+		{"-9223372036854775808 ** 1", "(-(9223372036854775808 ** 1))", false},
+	}
+
+	for _, tt := range tests {
+		lexer := lexer.New(tt.input)
+		parser := New(lexer)
+		program := parser.ParseProgram()
+		checkParserErrors(t, parser)
+
+		expression := program.Statements[0].(*ast.ExpressionStatement).Expression
+		if expression.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, expression.String())
+		}
+
+		literal, ok := expression.(*ast.IntegerLiteral)
+		if ok != tt.folded {
+			t.Errorf("wrong folding for %q. got=%T", tt.input, expression)
+			continue
+		}
+		if ok && literal.Span().Start.String() != "1:1" {
+			t.Errorf("folded literal %q does not start at the sign. got=%s", tt.input, literal.Span())
+		}
+	}
+
+	program := New(lexer.New("-9223372036854775808")).ParseProgram()
+	literal := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral)
+	if literal.Big != nil || literal.Value != math.MinInt64 {
+		t.Errorf("smallest integer is not a 64-bit literal. got=%d (big=%v)", literal.Value, literal.Big)
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9;", 1e-9},
+		{"2.5E3;", 2500},
+	}
+
+	for _, tt := range tests {
+		lexer := lexer.New(tt.input)
+		parser := New(lexer)
+		program := parser.ParseProgram()
+		checkParserErrors(t, parser)
+
+		statement := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := statement.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", statement.Expression)
+		}
+
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -196,6 +268,8 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"a * b / c", "((a * b) / c)"},
 		{"a + b * c + d / e - f", "(((a + (b * c)) + (d / e)) - f)"},
 		{"3 + 4; -5 * 5", "(3 + 4)((-5) * 5)"},
+		{"a++ + 1", "(++a + 1)"},
+		{"fn() { a++ }; b", "fn()++ab"},
 		{"5 > 4 == 3 < 4", "((5 > 4) == (3 < 4))"},
 		{"5 < 4 != 3 > 4", "((5 < 4) != (3 > 4))"},
 		{"3 + 4 * 5 == 3 * 1 + 4 * 5", "((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))"},
@@ -221,6 +295,37 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{"a || b && c", "(a || (b && c))"},
+		{"a && b || c && d", "((a && b) || (c && d))"},
+		{"a == b && c < d", "((a == b) && (c < d))"},
+		{"!a && b", "((!a) && b)"},
+		{"a && &b", "(a && &b)"},
+		{"a&&&b", "(a && &b)"},
+		{"*p || &q", "(*p || &q)"},
+		{"a % b * c", "((a % b) * c)"},
+		{"a + b % c", "(a + (b % c))"},
+		{"2 ** 3 ** 2", "(2 ** (3 ** 2))"},
+		{"-2 ** 2", "(-(2 ** 2))"},
+		{"2 ** -1", "(2 ** (-1))"},
+		{"a * b ** c", "(a * (b ** c))"},
+		{"a | b ^ c & d", "(a | (b ^ (c & d)))"},
+		{"a & b << c + d", "(a & (b << (c + d)))"},
+		{"a >> b == c", "((a >> b) == c)"},
+		{"a & 1 == 0", "((a & 1) == 0)"},
+		{"a | b && c", "((a | b) && c)"},
+		{"~a & ~b", "((~a) & (~b))"},
+		{"a & &b", "(a & &b)"},
+		{"a * *b", "(a * *b)"},
+		{"**p", "**p"},
+		{"x = a | b", "x = (a | b)"},
+		{"0..n + 1", "(0..(n + 1))"},
+		{"a..=b * 2", "(a..=(b * 2))"},
+		{"x = 0..3", "x = (0..3)"},
+		{"x = true && false", "x = (true && false)"},
+		{"found = found || y", "found = (found || y)"},
+		{"x = a == b", "x = (a == b)"},
+		{"x = y = z", "x = y = z"},
+		{"len(xs)..0", "(len(xs)..0)"},
 	}
 
 	for _, tt := range tests {
@@ -412,6 +517,74 @@ func TestFunctionLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestFunctionLiteralNames(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let add = fn(a, b) { a + b };", "add"},
+		{"let mut step = fn() { 1 };", "step"},
+		{"let f = (fn() { 1 });", "f"},
+		{"fn() { 1 };", ""},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		var expression ast.Expression
+		switch stmt := program.Statements[0].(type) {
+		case *ast.LetStatement:
+			expression = stmt.Value
+		case *ast.ExpressionStatement:
+			expression = stmt.Expression
+		}
+		function, ok := expression.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("expression is not ast.FunctionLiteral. got=%T", expression)
+		}
+		if function.Name != tt.expected {
+			t.Errorf("wrong name for %q. expected=%q, got=%q", tt.input, tt.expected, function.Name)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"fn(n) { f(n) }", []string{"f(n)"}},
+		{"fn(n) { f(n); g(n); }", []string{"g(n)"}},
+		{"fn(n) { return f(n); 1 }", []string{"f(n)"}},
+		{"fn(n) { f(g(n)) }", []string{"f(g(n))"}},
+		{"fn(n) { if (n) { f(n) } else { g(n) } }", []string{"f(n)", "g(n)"}},
+		{"fn(n) { if (n) { return f(n) } g(n) }", []string{"f(n)", "g(n)"}},
+		{"fn(n) { while (n) { if (n) { return f(n) } g(n) } }", []string{"f(n)"}},
+		{"fn(n) { for (x in n) { f(x) } }", nil},
+		{"fn(n) { 1 + f(n) }", nil},
+		{"fn(n) { let x = f(n); x }", nil},
+		{"fn(n) { try { return f(n) } catch { g(n) } finally { h(n) } }", nil},
+		{"fn(n) { fn(m) { f(m) } }", []string{"f(m)"}},
+		{"f(n)", nil},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		var calls []string
+		collectTailCalls(program, &calls)
+		if strings.Join(calls, ", ") != strings.Join(tt.expected, ", ") {
+			t.Errorf("wrong tail calls for %q. expected=%q, got=%q", tt.input, tt.expected, calls)
+		}
+	}
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -494,6 +667,39 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestInterpolatedStringExpression(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      string
+		expectedParts int
+	}{
+		{`"Hello, ${name}!"`, `"Hello, ${name}!"`, 3},
+		{`"${a}${b}"`, `"${a}${b}"`, 2},
+		{`"sum: ${1 + 2 * x}"`, `"sum: ${(1 + (2 * x))}"`, 2},
+		{`"${"in ${x}"} out"`, `"${"in ${x}"} out"`, 2},
+		{`"${ {"k": v}["k"] }"`, `"${({k:v}[k])}"`, 1},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+		}
+		if str.String() != tt.expected {
+			t.Errorf("wrong string for %q. expected=%q, got=%q", tt.input, tt.expected, str.String())
+		}
+		if len(str.Parts) != tt.expectedParts {
+			t.Errorf("wrong number of parts for %q. expected=%d, got=%d", tt.input, tt.expectedParts, len(str.Parts))
+		}
+	}
+}
+
 func TestParsingArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	l := lexer.New(input)
@@ -551,6 +757,39 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"s[1:3]", "(s[1:3])"},
+		{"s[:n - 1]", "(s[:(n - 1)])"},
+		{"s[-2:]", "(s[(-2):])"},
+		{"s[:]", "(s[:])"},
+		{"a[1:][0]", "((a[1:])[0])"},
+		{"{1: s[1:2]}", "{1:(s[1:2])}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if stmt.Expression.String() != tt.expected {
+			t.Errorf("wrong slice for %q. expected=%q, got=%q", tt.input, tt.expected, stmt.Expression.String())
+		}
+	}
+
+	l := lexer.New("s[1:2")
+	p := New(l)
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("no error for an unclosed slice")
+	}
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 	l := lexer.New(input)
@@ -706,6 +945,81 @@ func TestParsingWhileExpression(t *testing.T) {
 
 }
 
+func TestParsingLoopControl(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (true) { break; }", "while true {break;}"},
+		{"while (true) { continue }", "while true {continue;}"},
+		{"for (let i = 0; i < 3; i++) { if (i == 1) { continue; } }", "for let i = 0;; (i < 3); ++i {if (i == 1) ? continue;}"},
+		{"outer: while (a) { inner: while (b) { break outer; continue inner; } }",
+			"outer: while a {inner: while b {break outer;continue inner;}}"},
+		{"outer: for (let i = 0; i < 3; i++) { while (true) { continue outer; } };",
+			"outer: for let i = 0;; (i < 3); ++i {while true {continue outer;}}"},
+		{"while (true) { outer: while (true) { break; } break; }", "while true {outer: while true {break;}break;}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q.\nexpected=%q\ngot=     %q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestParsingForInExpression(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedKey   string
+		expectedValue string
+		expected      string
+	}{
+		{"for (x in xs) { x }", "", "x", "for (x in xs) {x}"},
+		{"for (i, x in [1, 2]) { i + x; }", "i", "x", "for (i, x in [1, 2]) {(i + x)}"},
+		{"for (k, v in {}) { }", "k", "v", "for (k, v in {}) {}"},
+		{"for (n in 0..=10) { break; }", "", "n", "for (n in (0..=10)) {break;}"},
+		{"outer: for (c in \"ab\") { continue outer; }", "", "c", "outer: for (c in ab) {continue outer;}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not *ast.ExpressionStatement. got=%T", program.Statements[0])
+		}
+
+		fe, ok := stmt.Expression.(*ast.ForInExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not *ast.ForInExpression. got=%T", stmt.Expression)
+		}
+
+		if tt.expectedKey == "" && fe.Key != nil {
+			t.Errorf("expected no key for %q. got=%s", tt.input, fe.Key)
+		}
+		if tt.expectedKey != "" && !testIdentifier(t, fe.Key, tt.expectedKey) {
+			continue
+		}
+		testIdentifier(t, fe.Value, tt.expectedValue)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
 func TestParsingForExpression(t *testing.T) {
 	input := "for (let i = 0; i < 10; i++) { 0 + i; }"
 	l := lexer.New(input)
@@ -758,12 +1072,13 @@ func TestParsingInvalidAssignmentTargets(t *testing.T) {
 	tests := []struct {
 		input           string
 		expectedMessage string
+		expectedColumn  int
 	}{
-		{"5 = 10;", "(line 1) invalid assignment target: 5"},
-		{"true = false;", "(line 1) invalid assignment target: true"},
-		{"\"hello\" = \"world\";", "(line 1) invalid assignment target: hello"},
-		{"(x + y) = 10;", "(line 1) invalid assignment target: +"},
-		{"fn(x) { x } = 10;", "(line 1) invalid assignment target: fn"},
+		{"5 = 10;", "invalid assignment target: 5", 1},
+		{"true = false;", "invalid assignment target: true", 1},
+		{"\"hello\" = \"world\";", "invalid assignment target: hello", 1},
+		{"(x + y) = 10;", "invalid assignment target: +", 2},
+		{"fn(x) { x } = 10;", "invalid assignment target: fn", 1},
 	}
 
 	for _, tt := range tests {
@@ -776,9 +1091,15 @@ func TestParsingInvalidAssignmentTargets(t *testing.T) {
 			continue
 		}
 
-		if p.Errors()[0] != tt.expectedMessage {
+		err := p.Errors()[0]
+		if err.Message != tt.expectedMessage {
 			t.Errorf("wrong error message. expected=%q, got=%q",
-				tt.expectedMessage, p.Errors()[0])
+				tt.expectedMessage, err.Message)
+		}
+
+		if err.Span.Start.Line != 1 || err.Span.Start.Column != tt.expectedColumn {
+			t.Errorf("wrong error position. expected=1:%d, got=%s",
+				tt.expectedColumn, err.Span.Start)
 		}
 	}
 }
@@ -892,3 +1213,346 @@ func TestPointerDereferenceExpression(t *testing.T) {
 
 	testIdentifier(t, exp.Right, "p")
 }
+
+func TestNodeSpans(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedStart string
+		expectedEnd   string
+	}{
+		{"1 + 2 * 3", "1:1", "1:10"},
+		{"add(1, 2)", "1:1", "1:10"},
+		{"  arr[0]", "1:3", "1:9"},
+		{"-x", "1:1", "1:3"},
+		{"{\"a\": 1}", "1:1", "1:9"},
+		{"if (x) {\n  1\n} else {\n  2\n}", "1:1", "5:2"},
+		{"fn(a) {\n  a\n}", "1:1", "3:2"},
+		{"x = [1,\n 2]", "1:1", "2:4"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+		}
+
+		span := stmt.Expression.Span()
+		if span.Start.String() != tt.expectedStart || span.End.String() != tt.expectedEnd {
+			t.Errorf("wrong span for %q. expected=%s-%s, got=%s",
+				tt.input, tt.expectedStart, tt.expectedEnd, span)
+		}
+	}
+}
+
+func TestParsingTryExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f() } catch (e) { g(e) }", "try f() catch (e) g(e)"},
+		{"try { f() } catch { 0 }", "try f() catch 0"},
+		{"try { f() } finally { close() }", "try f() finally close()"},
+		{"try { f() } catch (e) { throw e; } finally { close() }", "try f() catch (e) throw e; finally close()"},
+		{"let x = try { 1 } catch { 2 };", "let x = try 1 catch 2;"},
+		{`throw "boom";`, `throw boom;`},
+		{`throw error("a" + b)`, `throw error((a + b));`},
+		{"while (true) { try { break } finally { 1 } }", "while true {try break; finally 1}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q.\nexpected=%q\ngot=     %q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	l := lexer.New("try { f() } catch (e) { g(e) } finally { h() }")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Parameter, "e") {
+		return
+	}
+	if exp.Catch == nil || exp.Finally == nil {
+		t.Fatalf("try expression is missing a clause. got=%q", exp.String())
+	}
+}
+
+func TestParserDiagnostics(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedCode  string
+		expectedStart string
+		expectedHint  string
+	}{
+		{"if (x { 1 }", diagnostic.ExpectedToken, "1:7", `insert ")" here`},
+		{"let = 5;", diagnostic.ExpectedToken, "1:5", ""},
+		{"let x = @;", diagnostic.IllegalCharacter, "1:9", ""},
+		{"let x = 09;", diagnostic.InvalidNumber, "1:9", "write 9"},
+		{"let x = 0755;", diagnostic.InvalidNumber, "1:9", "write 755 for a decimal number, or 0o755 for an octal one"},
+		{"5 = 10;", diagnostic.InvalidAssignment, "1:1", ""},
+		{"break;", diagnostic.OutsideLoop, "1:1", ""},
+		{"if (true) { continue }", diagnostic.OutsideLoop, "1:13", ""},
+		{"while (true) { let f = fn() { break; }; }", diagnostic.OutsideLoop, "1:31", ""},
+		{"while (true) { break outer; }", diagnostic.UndefinedLabel, "1:22", ""},
+		{"outer: while (true) { } while (true) { break outer; }", diagnostic.UndefinedLabel, "1:46", ""},
+		{"outer: 5;", diagnostic.ExpectedToken, "1:8", ""},
+		{"for (x, in xs) { }", diagnostic.ExpectedToken, "1:9", ""},
+		{"for (x in xs { }", diagnostic.ExpectedToken, "1:14", `insert ")" here`},
+		{"let r = 1.;", diagnostic.IllegalCharacter, "1:10", "use .. or ..= for a range"},
+		{"let f = 1e999;", diagnostic.InvalidFloat, "1:9", ""},
+		{`let s = "a ${} b";`, diagnostic.UnexpectedToken, "1:9", "put an expression between ${ and }, or write \\${ for a literal ${"},
+		{`let s = "a ${x y} b";`, diagnostic.ExpectedToken, "1:16", `insert "}" here`},
+		{"try { f() }; g()", diagnostic.ExpectedToken, "1:12", ""},
+		{"try { f() } catch (1) { }", diagnostic.ExpectedToken, "1:20", ""},
+		{"try { f() } catch (e { }", diagnostic.ExpectedToken, "1:22", `insert ")" here`},
+		{"let a = [1, 2;", diagnostic.ExpectedToken, "1:14", `insert "]" here`},
+		{"f(1, 2;", diagnostic.ExpectedToken, "1:7", `insert ")" here`},
+		{"let x = (1 + 2;", diagnostic.ExpectedToken, "1:15", `insert ")" here`},
+		{"if (true) { 1", diagnostic.ExpectedToken, "1:11", "add a closing '}'"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("no diagnostics for %q", tt.input)
+			continue
+		}
+
+		d := errors[0]
+		if d.Code != tt.expectedCode {
+			t.Errorf("wrong code for %q. expected=%s, got=%s (%s)", tt.input, tt.expectedCode, d.Code, d.Message)
+		}
+
+		if d.Span.Start.String() != tt.expectedStart {
+			t.Errorf("wrong position for %q. expected=%s, got=%s", tt.input, tt.expectedStart, d.Span.Start)
+		}
+
+		if d.Hint != tt.expectedHint {
+			t.Errorf("wrong hint for %q. expected=%q, got=%q", tt.input, tt.expectedHint, d.Hint)
+		}
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	input := `
+let x = 5;
+if (x > 10 {
+  print(x);
+}
+let h = {"a" 1};
+let f = fn() {
+  let y = ;
+  return 1;
+};
+let ok = 1 +;
+let b = {"k": 2;
+let c = 5 + ;
+let d = );
+let z = 3;
+z`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	expectedLines := []int{3, 6, 8, 11, 12, 13, 14}
+	errors := p.Errors()
+	if len(errors) != len(expectedLines) {
+		for _, err := range errors {
+			t.Errorf("parser error: %s", err)
+		}
+		t.Fatalf("wrong number of errors. want=%d, got=%d", len(expectedLines), len(errors))
+	}
+
+	for i, line := range expectedLines {
+		if errors[i].Span.Start.Line != line {
+			t.Errorf("errors[%d] on wrong line. want=%d, got=%d (%s)", i, line, errors[i].Span.Start.Line, errors[i].Message)
+		}
+	}
+
+	// Statements around the errors survive, and the function keeps the part
+	// of its body that parsed
+	names := []string{}
+	for _, statement := range program.Statements {
+		if statement == nil {
+			t.Fatalf("program contains a nil statement")
+		}
+		if let, ok := statement.(*ast.LetStatement); ok {
+			names = append(names, let.Name.Value)
+		}
+	}
+
+	expectedNames := []string{"x", "h", "f", "ok", "b", "c", "d", "z"}
+	if strings.Join(names, ",") != strings.Join(expectedNames, ",") {
+		t.Errorf("wrong let statements recovered. want=%v, got=%v", expectedNames, names)
+	}
+
+	last, ok := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	if !ok || last.String() != "z" {
+		t.Errorf("last statement not recovered. got=%v", program.Statements[len(program.Statements)-1])
+	}
+
+	for _, statement := range program.Statements {
+		let, ok := statement.(*ast.LetStatement)
+		if !ok || let.Name.Value != "f" {
+			continue
+		}
+
+		fn, ok := let.Value.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("f is not a function literal. got=%T", let.Value)
+		}
+		if len(fn.Body.Statements) != 2 {
+			t.Errorf("function body has wrong number of statements. want=2, got=%d", len(fn.Body.Statements))
+		}
+		if _, ok := fn.Body.Statements[1].(*ast.ReturnStatement); !ok {
+			t.Errorf("return statement after the error not recovered. got=%T", fn.Body.Statements[1])
+		}
+	}
+}
+
+func TestLexerErrorsAreNotRepeated(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let @ = 1", "illegal character '@'"},
+		{"(1 @ 2)", "illegal character '@'"},
+		{"let \xff = 1", "invalid UTF-8 byte 0xff"},
+		{"let x = 0b102;", `invalid digit '2' in binary literal "0b102"`},
+		{`"${x"`, "unterminated string literal"},
+		{"let s = \"${x\" + `raw", "unterminated string literal"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0].Message != tt.expected {
+			t.Errorf("wrong errors for %q. expected only %q, got=%v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestExpectedTokenMessages(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"f(1;", "expected next token to be: RPAREN, got: SEMICOLON (;) instead."},
+		{"f(1 \"); 1\n\")", `expected next token to be: RPAREN, got: STRING (); 1\n) instead.`},
+		{"f(1 \"a very long string literal\")", "expected next token to be: RPAREN, got: STRING (a very long strin...) instead."},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0].Message != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestRecoveredTreesCanBePrinted(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(x) { x + ; }", "let f = fn(x)(x + <bad expression>);"},
+		{"let ok = 1 +;", "let ok = (1 + <bad expression>);"},
+		{"let y = ;", "let y = <bad expression>;"},
+		{"-;", "(-<bad expression>)"},
+		{"f(1, );", "f(1, <bad expression>)"},
+		{"a[];", "<bad expression>"},
+		{"let a = [1, 2;", "let a = [1, 2];"},
+		{"(1 + 2", "(1 + 2)"},
+		{"x = ;", "x = <bad expression>"},
+		{"return ;", "return <bad expression>;"},
+		{`"a${}b"`, ""},
+	}
+
+	inputs := []string{
+		"if (x > 10 { 1 }", "fn(a, b { a }", "let h = {1 2};", "let a = [1, 2;", "while (true { }",
+		"for (x in ) { }", "for (let i = 0; i <; i++) { }", "try { 1 }", "try { } catch (", "throw ;",
+		"a[:", "&;", "*;", "(1 + 2", "outer: while (true) { break outer", `"${1 +}"`,
+		"let f = fn(x) { if (x) { x + } else { - } }",
+	}
+	for _, tt := range tests {
+		inputs = append(inputs, tt.input)
+	}
+
+	for _, input := range inputs {
+		l := lexer.New(input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected errors for %q", input)
+		}
+
+		// Neither may panic on the holes the errors left
+		_ = program.String()
+		_ = program.Span()
+		for _, statement := range program.Statements {
+			_ = statement.Span()
+		}
+	}
+
+	for _, tt := range tests {
+		if tt.expected == "" {
+			continue
+		}
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong tree for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestParserReportsEachErrorOnce(t *testing.T) {
+	tests := []string{
+		"let = 5; let y = 1;",
+		"fn(a, b { a };",
+		"let h = {1 2, 3: 4};",
+		"if (x { y } else { z }",
+		"let x = 5 @ 1;",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+
+		if len(p.Errors()) != 1 {
+			t.Errorf("expected exactly one error for %q. got=%v", input, p.Errors())
+		}
+	}
+}
@@ -219,3 +219,50 @@ func testIncrementExpression(t *testing.T, exp ast.Expression, left any, operato
 
 	return true
 }
+
+// collectTailCalls appends the calls under node marked as tail calls, in
+// source order.
+func collectTailCalls(node ast.Node, calls *[]string) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, statement := range node.Statements {
+			collectTailCalls(statement, calls)
+		}
+	case *ast.BlockStatement:
+		if node == nil {
+			return
+		}
+		for _, statement := range node.Statements {
+			collectTailCalls(statement, calls)
+		}
+	case *ast.ExpressionStatement:
+		collectTailCalls(node.Expression, calls)
+	case *ast.LetStatement:
+		collectTailCalls(node.Value, calls)
+	case *ast.ReturnStatement:
+		collectTailCalls(node.ReturnValue, calls)
+	case *ast.InfixExpression:
+		collectTailCalls(node.Left, calls)
+		collectTailCalls(node.Right, calls)
+	case *ast.IfExpression:
+		collectTailCalls(node.Consequence, calls)
+		collectTailCalls(node.Alternative, calls)
+	case *ast.WhileExpression:
+		collectTailCalls(node.Body, calls)
+	case *ast.ForInExpression:
+		collectTailCalls(node.Body, calls)
+	case *ast.TryExpression:
+		collectTailCalls(node.Body, calls)
+		collectTailCalls(node.Catch, calls)
+		collectTailCalls(node.Finally, calls)
+	case *ast.FunctionLiteral:
+		collectTailCalls(node.Body, calls)
+	case *ast.CallExpression:
+		if node.Tail {
+			*calls = append(*calls, node.String())
+		}
+		for _, argument := range node.Arguments {
+			collectTailCalls(argument, calls)
+		}
+	}
+}
@@ -1,17 +1,22 @@
 package repl
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"strings"
 
 	"github.com/chzyer/readline"
 
+	"ember_lang/ember_lang/compiler"
+	"ember_lang/ember_lang/diagnostic"
 	"ember_lang/ember_lang/evaluator"
 	"ember_lang/ember_lang/lexer"
 	"ember_lang/ember_lang/object"
 	"ember_lang/ember_lang/parser"
+	"ember_lang/ember_lang/vm"
 )
 
 const (
@@ -25,6 +30,7 @@ Ember Programming Language REPL
 Commands:
   help              Show this help message
   exit, quit        Exit the REPL
+  Ctrl-C            Stop the line being run
 
 Basic Syntax:
 ------------
@@ -35,6 +41,7 @@ Basic Syntax:
   for (let i = 0; i < 5; i++) {   // For loop
     ...
   }
+  break; continue;                // Loop control, with optional label
   [1, 2, 3]                       // Array literal
   {"a": 1, "b": 2}                // Hash/map literal
 
@@ -44,13 +51,25 @@ Built-in Functions:
   len(array)        Get length of array or string
   type(value)       Get type of value
   push(arr, item)   Append item to array
+  delete(hash, key) Remove key from hash
 
 Type 'help' for this message, 'exit' or 'quit' to exit.
 `
 )
 
-func Start(in io.Reader, out io.Writer, debug string) {
-	env := object.NewEnvironment()
+// Start runs the REPL on the given engine, "eval" or "vm", with the semantics
+// of the given language version and integer mode, allowing at most maxDepth
+// nested calls. Both engines keep their bindings between lines. Errors and
+// their stack traces are colored if color is set.
+func Start(in io.Reader, out io.Writer, debug string, engine string, version object.LanguageVersion, integers object.IntegerMode, maxDepth int, color bool) {
+	env := object.NewVersionedEnvironment(version)
+	env.SetIntegerMode(integers)
+	env.SetMaxCallDepth(maxDepth)
+
+	// State carried between lines by the vm engine
+	symbolTable := compiler.NewVersionedSymbolTable(version)
+	constants := []object.Object{}
+	globals := make([]*object.Cell, vm.GlobalsSize)
 
 	readline, err := readline.NewEx(&readline.Config{
 		Prompt:          PROMPT,
@@ -112,23 +131,42 @@ func Start(in io.Reader, out io.Writer, debug string) {
 		}
 
 		if len(parser.Errors()) != 0 {
-			printParserErrors(out, parser.Errors())
+			printDiagnostics(out, line, parser.Errors(), color)
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
+		// Ctrl-C cancels the line being run rather than ending the REPL
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+
+		var evaluated object.Object
+		if engine == "vm" {
+			comp := compiler.NewWithState(symbolTable, constants)
+			if err := comp.Compile(program); err != nil {
+				stop()
+				_, _ = fmt.Fprintf(out, "\033[31mCompilation failed:\033[0m\n\t%s\n", err)
+				continue
+			}
+			constants = comp.Constants()
+
+			machine := vm.NewWithGlobalsStore(comp.Bytecode(), globals)
+			machine.SetIntegerMode(integers)
+			machine.SetMaxCallDepth(maxDepth)
+			evaluated = machine.RunContext(ctx, object.Limits{})
+		} else {
+			evaluated = evaluator.EvalContext(ctx, program, env, object.Limits{})
+		}
+		stop()
+
+		if err, ok := evaluated.(*object.Error); ok {
+			printDiagnostics(out, line, []*diagnostic.Diagnostic{err.Diagnostic()}, color)
+		} else if evaluated != nil {
 			_, _ = io.WriteString(out, evaluated.Inspect())
 			_, _ = io.WriteString(out, "\n")
 		}
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	_, _ = io.WriteString(out, "\033[31mFailed to parse program!\033[0m\n\n")
-	_, _ = io.WriteString(out, "\033[31mPARSER ERRORS:\033[0m\n")
-
-	for _, msg := range errors {
-		_, _ = io.WriteString(out, "\t"+msg+"\n")
-	}
+func printDiagnostics(out io.Writer, source string, diagnostics []*diagnostic.Diagnostic, color bool) {
+	renderer := &diagnostic.Renderer{Source: source, Color: color}
+	_, _ = io.WriteString(out, renderer.RenderAll(diagnostics))
 }
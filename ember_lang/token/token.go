@@ -6,10 +6,63 @@ import (
 
 type TokenType string
 
+// Position is a location in the source. Offset is a byte offset from the start
+// of the input; Line and Column are 1-based, and Column counts characters
+// rather than bytes. The zero Position is unknown.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Span is the half-open range of source [Start, End).
+type Span struct {
+	Start Position
+	End   Position
+}
+
+func (s Span) IsValid() bool {
+	return s.Start.IsValid()
+}
+
+func (s Span) String() string {
+	return s.Start.String() + "-" + s.End.String()
+}
+
+// Join returns the span covering both s and other. Unknown spans are ignored.
+func (s Span) Join(other Span) Span {
+	if !s.IsValid() {
+		return other
+	}
+	if !other.IsValid() {
+		return s
+	}
+
+	joined := s
+	if other.Start.Offset < joined.Start.Offset {
+		joined.Start = other.Start
+	}
+	if other.End.Offset > joined.End.Offset {
+		joined.End = other.End
+	}
+	return joined
+}
+
 type Token struct {
-	Type       TokenType
-	Literal    string
-	LineNumber int
+	Type    TokenType
+	Literal string
+	Span    Span
 }
 
 // String returns a colored string representation of the token
@@ -34,15 +87,16 @@ func (t Token) String() string {
 		typeColor = red
 	case FUNCTION:
 		typeColor = blue
-	case LET, IF, ELSE, RETURN, WHILE, FOR:
+	case LET, IF, ELSE, RETURN, WHILE, FOR, IN, BREAK, CONTINUE, TRY, CATCH, FINALLY, THROW:
 		typeColor = purple
 	case TRUE, FALSE:
 		typeColor = green
-	case PLUS, MINUS, BANG, ASTERISK, SLASH, LT, GT, LTE, GTE, EQ, NEQ, ASSIGN:
+	case PLUS, MINUS, BANG, ASTERISK, SLASH, PERCENT, POWER, AMPERSAND, PIPE, CARET, TILDE, SHL, SHR,
+		LT, GT, LTE, GTE, EQ, NEQ, ASSIGN, AND, OR, DOTDOT, DOTDOTEQ:
 		typeColor = white
-	case INT:
+	case INT, FLOAT:
 		typeColor = cyan
-	case STRING:
+	case STRING, STRING_HEAD, STRING_MIDDLE, STRING_TAIL:
 		typeColor = orange
 	case COMMA, SEMICOLON, COLON, LPAREN, RPAREN, LBRACE, RBRACE, LBRACKET, RBRACKET:
 		typeColor = gray
@@ -70,8 +124,15 @@ const (
 
 	IDENTIFIER = "IDENTIFIER" // add, foobar, x, y
 	INT        = "INT"
+	FLOAT      = "FLOAT"
 	STRING     = "STRING"
 
+	// Interpolated strings: "a${x}b${y}c" is read as STRING_HEAD "a", the
+	// tokens of x, STRING_MIDDLE "b", the tokens of y and STRING_TAIL "c".
+	STRING_HEAD   = "STRING_HEAD"   // "...${
+	STRING_MIDDLE = "STRING_MIDDLE" // }...${
+	STRING_TAIL   = "STRING_TAIL"   // }..."
+
 	// Operators
 	ASSIGN    = "ASSIGN"
 	PLUS      = "PLUS"
@@ -79,7 +140,22 @@ const (
 	BANG      = "BANG"
 	ASTERISK  = "ASTERISK"
 	SLASH     = "SLASH"
-	AMPERSAND = "AMPERSAND" // New token for &
+	PERCENT   = "PERCENT"   // %
+	POWER     = "POWER"     // **
+	AMPERSAND = "AMPERSAND" // & (bitwise and, or pointer reference in prefix position)
+	PIPE      = "PIPE"      // |
+	CARET     = "CARET"     // ^
+	TILDE     = "TILDE"     // ~
+	SHL       = "SHL"       // <<
+	SHR       = "SHR"       // >>
+
+	// Ranges
+	DOTDOT   = "DOTDOT"   // ..
+	DOTDOTEQ = "DOTDOTEQ" // ..=
+
+	// Logical operators
+	AND = "AND" // &&
+	OR  = "OR"  // ||
 
 	// Suffix operators
 	INCREMENT = "INCREMENT"
@@ -113,8 +189,17 @@ const (
 	RETURN   = "RETURN"
 
 	// Loops
-	WHILE = "WHILE"
-	FOR   = "FOR"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+
+	// Errors
+	TRY     = "TRY"
+	CATCH   = "CATCH"
+	FINALLY = "FINALLY"
+	THROW   = "THROW"
 
 	// Mutable
 	MUT = "MUT"
@@ -124,16 +209,23 @@ const (
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"while":  WHILE,
-	"for":    FOR,
-	"mut":    MUT,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"mut":      MUT,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
 }
 
 func LookupIdentifier(identifier string) TokenType {
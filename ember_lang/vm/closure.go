@@ -0,0 +1,29 @@
+package vm
+
+import (
+	"ember_lang/ember_lang/object"
+)
+
+// Closure is a compiled function paired with the cells of the variables it
+// captured. It reports itself as a FUNCTION so scripts cannot tell it apart
+// from a function created by the evaluator.
+type Closure struct {
+	Fn   *object.CompiledFunction
+	Free []*object.Cell
+
+	vm *VM
+}
+
+func (c *Closure) Type() object.ObjectType {
+	return object.FUNCTION_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	return c.Fn.Inspect()
+}
+
+// Call runs the closure to completion on the VM that created it. Builtins
+// such as map and reduce use it to call back into Ember code.
+func (c *Closure) Call(args ...object.Object) object.Object {
+	return c.vm.callFromGo(c, args)
+}
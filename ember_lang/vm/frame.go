@@ -0,0 +1,40 @@
+package vm
+
+import (
+	"ember_lang/ember_lang/code"
+	"ember_lang/ember_lang/object"
+	"ember_lang/ember_lang/token"
+)
+
+type Frame struct {
+	cl          *Closure
+	ip          int
+	basePointer int
+	cells       []*object.Cell // Indexed by local slot; only boxed slots are set
+	call        token.Span     // Where the function was called from, for stack traces
+}
+
+func NewFrame(cl *Closure, basePointer int) *Frame {
+	frame := &Frame{cl: cl, ip: -1, basePointer: basePointer}
+
+	if len(cl.Fn.Cells) > 0 {
+		frame.cells = make([]*object.Cell, cl.Fn.NumLocals)
+		for _, slot := range cl.Fn.Cells {
+			frame.cells[slot] = &object.Cell{}
+		}
+	}
+
+	return frame
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// span returns the source span of the instruction being executed.
+func (f *Frame) span() token.Span {
+	if f.ip < 0 || f.ip >= len(f.cl.Fn.Spans) {
+		return token.Span{}
+	}
+	return f.cl.Fn.Spans[f.ip]
+}
@@ -0,0 +1,967 @@
+package vm
+
+import (
+	"context"
+	"ember_lang/ember_lang/code"
+	"ember_lang/ember_lang/compiler"
+	"ember_lang/ember_lang/evaluator"
+	"ember_lang/ember_lang/object"
+	"ember_lang/ember_lang/token"
+	"math"
+)
+
+const (
+	StackSize   = 1 << 16 // Initial size of the value stack, which grows with the calls made
+	GlobalsSize = 1 << 16
+	MaxFrames   = 1 << 14
+)
+
+// Integers in this range are preallocated so arithmetic on loop counters and
+// indices does not allocate.
+const (
+	minCachedInteger = -128
+	maxCachedInteger = 1024
+)
+
+var cachedIntegers [maxCachedInteger - minCachedInteger + 1]*object.Integer
+
+func init() {
+	for i := range cachedIntegers {
+		cachedIntegers[i] = &object.Integer{Value: int64(i + minCachedInteger)}
+	}
+}
+
+func newInteger(value int64) *object.Integer {
+	if value >= minCachedInteger && value <= maxCachedInteger {
+		return cachedIntegers[value-minCachedInteger]
+	}
+	return &object.Integer{Value: value}
+}
+
+type VM struct {
+	constants []object.Object
+
+	globals     []*object.Cell
+	globalNames []string
+
+	stack []object.Object
+	sp    int // Always points to the next free slot. Top of stack is stack[sp-1]
+
+	frames      []*Frame
+	framesIndex int
+	maxDepth    int // Calls that may be nested below the main frame
+
+	handlers []handler // Try blocks being run, innermost last
+
+	integers object.IntegerMode
+	budget   object.Budget
+}
+
+// handler is where an error raised inside a try block is taken: the frame
+// and stack height when the block was entered, and the position of the code
+// that receives the error.
+type handler struct {
+	framesIndex int
+	sp          int
+	ip          int
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobalsStore(bytecode, make([]*object.Cell, GlobalsSize))
+}
+
+// NewWithGlobalsStore creates a VM that shares globals with earlier runs, as
+// the REPL does between lines.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, globals []*object.Cell) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Spans: bytecode.Spans}
+
+	vm := &VM{
+		constants:   bytecode.Constants,
+		globals:     globals,
+		globalNames: bytecode.GlobalNames,
+		stack:       make([]object.Object, StackSize),
+		frames:      make([]*Frame, MaxFrames),
+		maxDepth:    object.DefaultMaxCallDepth,
+	}
+
+	mainClosure := &Closure{Fn: mainFn, vm: vm}
+	vm.stack[0] = mainClosure
+	vm.sp = 1
+	vm.pushFrame(NewFrame(mainClosure, 1))
+
+	return vm
+}
+
+// SetIntegerMode selects how integer overflow is handled.
+func (vm *VM) SetIntegerMode(mode object.IntegerMode) {
+	vm.integers = mode
+}
+
+// SetMaxCallDepth limits how many calls may be nested. Calls beyond the
+// limit fail with a stack overflow.
+func (vm *VM) SetMaxCallDepth(depth int) {
+	vm.maxDepth = depth
+	if depth+1 > len(vm.frames) {
+		vm.frames = append(vm.frames, make([]*Frame, depth+1-len(vm.frames))...)
+	}
+}
+
+// Run executes the program and returns the value of its last statement, or
+// the *object.Error that stopped it. A Go panic inside the VM is returned as
+// an internal error at the current instruction instead of crashing the host.
+func (vm *VM) Run() (result object.Object) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err := evaluator.NewInternalError(recovered)
+			err.Span = vm.currentFrame().span()
+			result = err
+		}
+	}()
+
+	return vm.run(1)
+}
+
+// RunContext executes the program like Run, but stops it with an error once
+// ctx is done or it exceeds limits, as evaluator.EvalContext does. Each
+// instruction executed is a step.
+func (vm *VM) RunContext(ctx context.Context, limits object.Limits) object.Object {
+	vm.budget.Start(ctx, limits)
+	defer vm.budget.Stop()
+
+	return vm.Run()
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// run executes instructions until the frame at depth returns, and yields its
+// return value. Errors that do not know where they happened yet are
+// attributed to the instruction and calls that raised them, and are then
+// handed to the innermost try block of the frames being run, if there is one.
+func (vm *VM) run(depth int) object.Object {
+	for {
+		result := vm.execute(depth)
+
+		err, ok := result.(*object.Error)
+		if !ok {
+			return result
+		}
+
+		if !err.Span.IsValid() {
+			err.Span = vm.currentFrame().span()
+		}
+		if err.Stack == nil {
+			err.Stack = vm.stackTrace()
+		}
+
+		if !vm.catch(err, depth) {
+			return err
+		}
+	}
+}
+
+// stackTrace records the calls being run, or returns nil outside of any call.
+// Arguments are the current values of the parameters.
+func (vm *VM) stackTrace() *object.StackTrace {
+	return object.NewStackTrace(vm.framesIndex-1, func(i int) object.Call {
+		frame := vm.frames[vm.framesIndex-1-i]
+		fn := frame.cl.Fn
+
+		name := ""
+		if fn.Literal != nil {
+			name = fn.Literal.Name
+		}
+
+		// The stack slots are reused once the error unwinds the call
+		args := make([]object.Object, fn.NumParameters)
+		copy(args, vm.stack[frame.basePointer:frame.basePointer+fn.NumParameters])
+
+		return object.Call{Name: name, Args: args, Span: frame.call}
+	})
+}
+
+// catch unwinds to the innermost try block entered at depth or deeper and
+// resumes there with the caught error on the stack. It reports false if there
+// is no such block or err may not be caught, dropping the blocks the error
+// leaves.
+func (vm *VM) catch(err *object.Error, depth int) bool {
+	if len(vm.handlers) == 0 || vm.handlers[len(vm.handlers)-1].framesIndex < depth {
+		return false
+	}
+
+	caught, ok := evaluator.Catch(err)
+	if !ok {
+		for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].framesIndex >= depth {
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		}
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.framesIndex = h.framesIndex
+	vm.sp = h.sp
+	vm.currentFrame().ip = h.ip - 1
+
+	vm.push(caught)
+	return true
+}
+
+func (vm *VM) execute(depth int) object.Object {
+	for {
+		frame := vm.currentFrame()
+		frame.ip++
+
+		if err := vm.budget.Step(); err != nil {
+			return err
+		}
+
+		ins := frame.Instructions()
+		ip := frame.ip
+		op := code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			if err := vm.push(vm.constants[constIndex]); err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpNull:
+			if err := vm.push(evaluator.NULL); err != nil {
+				return err
+			}
+
+		case code.OpTrue:
+			if err := vm.push(evaluator.TRUE); err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			if err := vm.push(evaluator.FALSE); err != nil {
+				return err
+			}
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+			code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan,
+			code.OpLessEqual, code.OpGreaterEqual:
+			if err := vm.executeBinaryOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpMinus, code.OpPlus, code.OpBang, code.OpBitNot:
+			if err := vm.executePrefixOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpIncrement:
+			result := evaluator.EvalIncrement(vm.pop())
+			if isError(result) {
+				return result
+			}
+			if err := vm.push(result); err != nil {
+				return err
+			}
+
+		case code.OpJump:
+			position := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip = position - 1
+
+		case code.OpJumpNotTruthy:
+			position := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			condition := vm.pop()
+			if !evaluator.IsTruthy(condition) {
+				frame.ip = position - 1
+			}
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			cell := vm.globals[globalIndex]
+			if cell == nil || cell.Value == nil {
+				return evaluator.NewError("Identifier not found: %s", vm.globalNames[globalIndex])
+			}
+			if err := vm.push(cell.Value); err != nil {
+				return err
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			if vm.globals[globalIndex] == nil {
+				vm.globals[globalIndex] = &object.Cell{Mutable: true}
+			}
+			vm.globals[globalIndex].Value = vm.pop()
+
+		case code.OpDefineGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			mutable := code.ReadUint8(ins[ip+3:]) == 1
+			frame.ip += 3
+
+			if vm.globals[globalIndex] == nil {
+				vm.globals[globalIndex] = &object.Cell{}
+			}
+			vm.globals[globalIndex].Value = vm.pop()
+			vm.globals[globalIndex].Mutable = mutable
+
+		case code.OpNewGlobalCell:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			vm.globals[globalIndex] = &object.Cell{}
+
+		case code.OpGetLocal:
+			localIndex := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+
+			value := vm.stack[frame.basePointer+localIndex]
+			if value == nil {
+				return evaluator.NewError("Identifier not found: %s", frame.cl.Fn.LocalNames[localIndex])
+			}
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+
+			vm.stack[frame.basePointer+localIndex] = vm.pop()
+
+		case code.OpGetCell:
+			localIndex := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+
+			value := frame.cells[localIndex].Value
+			if value == nil {
+				return evaluator.NewError("Identifier not found: %s", frame.cl.Fn.LocalNames[localIndex])
+			}
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpSetCell:
+			localIndex := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+
+			frame.cells[localIndex].Value = vm.pop()
+
+		case code.OpDefineCell:
+			localIndex := int(code.ReadUint8(ins[ip+1:]))
+			mutable := code.ReadUint8(ins[ip+2:]) == 1
+			frame.ip += 2
+
+			frame.cells[localIndex].Value = vm.pop()
+			frame.cells[localIndex].Mutable = mutable
+
+		case code.OpNewCell:
+			localIndex := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+
+			frame.cells[localIndex] = &object.Cell{}
+
+		case code.OpGetFree:
+			freeIndex := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+
+			value := frame.cl.Free[freeIndex].Value
+			if value == nil {
+				return evaluator.NewError("Identifier not found: %s", frame.cl.Fn.Free[freeIndex].Name)
+			}
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+
+			frame.cl.Free[freeIndex].Value = vm.pop()
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(&object.Array{Elements: elements}); err != nil {
+				return err
+			}
+
+		case code.OpInterpolate:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			str := evaluator.Interpolate(vm.stack[vm.sp-numParts : vm.sp])
+			vm.sp = vm.sp - numParts
+
+			if err := vm.push(str); err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			hash := vm.buildHash(vm.sp-numElements, vm.sp)
+			if isError(hash) {
+				return hash
+			}
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			result := evaluator.EvalIndex(left, index)
+			if isError(result) {
+				return result
+			}
+			if err := vm.push(result); err != nil {
+				return err
+			}
+
+		case code.OpSlice:
+			end := vm.pop()
+			start := vm.pop()
+			left := vm.pop()
+
+			result := evaluator.EvalSlice(left, start, end)
+			if isError(result) {
+				return result
+			}
+			if err := vm.push(result); err != nil {
+				return err
+			}
+
+		case code.OpRange:
+			inclusive := code.ReadUint8(ins[ip+1:]) == 1
+			frame.ip += 1
+
+			end := vm.pop()
+			start := vm.pop()
+
+			result := evaluator.EvalRange(start, end, inclusive)
+			if isError(result) {
+				return result
+			}
+			if err := vm.push(result); err != nil {
+				return err
+			}
+
+		case code.OpIterator:
+			withKeys := code.ReadUint8(ins[ip+1:]) == 1
+			frame.ip += 1
+
+			iterator := evaluator.NewIterator(vm.pop(), withKeys)
+			if isError(iterator) {
+				return iterator
+			}
+			if err := vm.push(iterator); err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			position := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			iterator := vm.stack[vm.sp-1].(*object.Iterator)
+			key, value, ok := iterator.Next()
+			if !ok {
+				frame.ip = position - 1
+				continue
+			}
+
+			if err := vm.push(key); err != nil {
+				return err
+			}
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpSetIndex:
+			right := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			result := evaluator.EvalIndexAssignment(left, index, right)
+			if isError(result) {
+				return result
+			}
+			if err := vm.push(result); err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 3
+
+			if err := vm.pushClosure(int(constIndex)); err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+
+			if err := vm.executeCall(numArgs); err != nil {
+				return err
+			}
+
+		case code.OpTailCall:
+			numArgs := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+
+			if err := vm.executeTailCall(numArgs); err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			returning := vm.popFrame()
+			vm.sp = returning.basePointer - 1
+
+			if vm.framesIndex < depth {
+				return returnValue
+			}
+
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+
+		case code.OpAddress:
+			scope := code.ReadUint8(ins[ip+1:])
+			index := int(code.ReadUint16(ins[ip+2:]))
+			nameIndex := code.ReadUint16(ins[ip+4:])
+			frame.ip += 5
+
+			name := vm.constants[nameIndex].(*object.String).Value
+
+			var cell *object.Cell
+			switch scope {
+			case code.ScopeGlobal:
+				cell = vm.globals[index]
+			case code.ScopeLocal:
+				cell = frame.cells[index]
+			case code.ScopeFree:
+				cell = frame.cl.Free[index]
+			}
+
+			if cell == nil || cell.Value == nil {
+				return evaluator.NewError("Cannot take address of undefined variable: %s", name)
+			}
+
+			if err := vm.push(&object.Pointer{Name: name, Cell: cell}); err != nil {
+				return err
+			}
+
+		case code.OpAddressIndex:
+			index := vm.pop()
+			base := vm.pop()
+
+			pointer := evaluator.ElementPointer(base, index)
+			if isError(pointer) {
+				return pointer
+			}
+
+			if err := vm.push(pointer); err != nil {
+				return err
+			}
+
+		case code.OpDeref:
+			right := vm.pop()
+
+			pointer, ok := right.(*object.Pointer)
+			if !ok {
+				return evaluator.NewError("Cannot dereference non-pointer value: %s", right.Type())
+			}
+			value := evaluator.Dereference(pointer)
+			if isError(value) {
+				return value
+			}
+
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpSetDeref:
+			right := vm.pop()
+			target := vm.pop()
+
+			pointer, ok := target.(*object.Pointer)
+			if !ok {
+				return evaluator.NewError("Cannot dereference non-pointer value: %s", target.Type())
+			}
+			if !pointer.Cell.Mutable {
+				return evaluator.NewError("Cannot assign to immutable variable: %s", pointer.Name)
+			}
+
+			if result := evaluator.StoreThroughPointer(pointer, right); isError(result) {
+				return result
+			}
+
+			if err := vm.push(right); err != nil {
+				return err
+			}
+
+		case code.OpError:
+			constIndex := code.ReadUint16(ins[ip+1:])
+
+			return &object.Error{Message: vm.constants[constIndex].(*object.String).Value}
+
+		case code.OpTry:
+			handlerPos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			vm.handlers = append(vm.handlers, handler{framesIndex: vm.framesIndex, sp: vm.sp, ip: handlerPos})
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpThrow:
+			return evaluator.Throw(vm.pop())
+
+		default:
+			def, _ := code.Lookup(byte(op))
+			return evaluator.NewError("Unsupported instruction: %v", def)
+		}
+	}
+}
+
+func (vm *VM) push(o object.Object) *object.Error {
+	if vm.sp >= len(vm.stack) {
+		vm.growStack(vm.sp + 1)
+	}
+	if vm.integers == object.CheckedIntegers {
+		if err := evaluator.IntegerOverflow(o, vm.integers); err != nil {
+			return err
+		}
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+// growStack makes room for at least size values on the stack. Calls are
+// bounded by the call depth limit rather than by the size of the stack, so
+// that both engines overflow at the same depth.
+func (vm *VM) growStack(size int) {
+	grown := make([]object.Object, max(size, 2*len(vm.stack)))
+	copy(grown, vm.stack[:vm.sp])
+	vm.stack = grown
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) *object.Error {
+	right := vm.pop()
+	left := vm.pop()
+
+	// Fast path for integer arithmetic and comparisons
+	if leftInt, ok := left.(*object.Integer); ok {
+		if rightInt, ok := right.(*object.Integer); ok {
+			if result := integerBinaryOperation(op, leftInt.Value, rightInt.Value); result != nil {
+				return vm.push(result)
+			}
+		}
+	}
+
+	result := evaluator.EvalInfix(binaryOperators[op], left, right)
+	if isError(result) {
+		return result.(*object.Error)
+	}
+
+	return vm.push(result)
+}
+
+var binaryOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShiftLeft:    "<<",
+	code.OpShiftRight:   ">>",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpLessThan:     "<",
+	code.OpGreaterThan:  ">",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+}
+
+func integerBinaryOperation(op code.Opcode, left int64, right int64) object.Object {
+	// Results that overflow are left to the evaluator, which promotes them
+	switch op {
+	case code.OpAdd:
+		sum := left + right
+		if (sum > left) != (right > 0) {
+			return nil
+		}
+		return newInteger(sum)
+	case code.OpSub:
+		difference := left - right
+		if (difference < left) != (right > 0) {
+			return nil
+		}
+		return newInteger(difference)
+	case code.OpMul:
+		product := left * right
+		if left != 0 && (product/left != right || (left == -1 && right == math.MinInt64)) {
+			return nil
+		}
+		return newInteger(product)
+	case code.OpDiv:
+		if right == 0 || (left == math.MinInt64 && right == -1) {
+			return nil // Reported or promoted by the evaluator
+		}
+		return newInteger(left / right)
+	case code.OpBitAnd:
+		return newInteger(left & right)
+	case code.OpBitOr:
+		return newInteger(left | right)
+	case code.OpBitXor:
+		return newInteger(left ^ right)
+	case code.OpEqual:
+		return evaluator.NativeBoolToBooleanObject(left == right)
+	case code.OpNotEqual:
+		return evaluator.NativeBoolToBooleanObject(left != right)
+	case code.OpLessThan:
+		return evaluator.NativeBoolToBooleanObject(left < right)
+	case code.OpGreaterThan:
+		return evaluator.NativeBoolToBooleanObject(left > right)
+	case code.OpLessEqual:
+		return evaluator.NativeBoolToBooleanObject(left <= right)
+	case code.OpGreaterEqual:
+		return evaluator.NativeBoolToBooleanObject(left >= right)
+	default:
+		return nil
+	}
+}
+
+var prefixOperators = map[code.Opcode]string{
+	code.OpMinus:  "-",
+	code.OpPlus:   "+",
+	code.OpBang:   "!",
+	code.OpBitNot: "~",
+}
+
+func (vm *VM) executePrefixOperation(op code.Opcode) *object.Error {
+	right := vm.pop()
+
+	if integer, ok := right.(*object.Integer); ok && op == code.OpMinus && integer.Value != math.MinInt64 {
+		return vm.push(newInteger(-integer.Value))
+	}
+
+	result := evaluator.EvalPrefix(prefixOperators[op], right)
+	if isError(result) {
+		return result.(*object.Error)
+	}
+
+	return vm.push(result)
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) object.Object {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return evaluator.NewError("unusable as hash key: %s", key.Type())
+		}
+
+		hashedPairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: hashedPairs}
+}
+
+func (vm *VM) pushClosure(constIndex int) *object.Error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return evaluator.NewError("Not a function: %+v", constant)
+	}
+
+	frame := vm.currentFrame()
+	free := make([]*object.Cell, len(function.Free))
+	for i, capture := range function.Free {
+		if capture.Local {
+			free[i] = frame.cells[capture.Index]
+		} else if capture.Global {
+			free[i] = vm.globals[capture.Index]
+		} else {
+			free[i] = frame.cl.Free[capture.Index]
+		}
+	}
+
+	return vm.push(&Closure{Fn: function, Free: free, vm: vm})
+}
+
+func (vm *VM) executeCall(numArgs int) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *Closure:
+		return vm.callClosure(callee, numArgs, vm.currentFrame().span())
+	case *object.Builtin:
+		return vm.callBuiltin(callee.Fn, numArgs)
+	case object.Callable:
+		return vm.callBuiltin(callee.Call, numArgs)
+	default:
+		return evaluator.NewError("Not a function: %s", callee.Type())
+	}
+}
+
+// executeTailCall makes a call that the current frame returns the result of
+// as is. A closure is called in place of the frame, which is dropped first
+// along with its stack, so tail recursion runs in constant space. Anything
+// else is called as usual.
+func (vm *VM) executeTailCall(numArgs int) *object.Error {
+	callee, ok := vm.stack[vm.sp-1-numArgs].(*Closure)
+	if !ok || numArgs < callee.Fn.NumParameters {
+		return vm.executeCall(numArgs)
+	}
+
+	call := vm.currentFrame().span()
+	returning := vm.popFrame()
+
+	// Move the callee and its arguments down to where the callee of the
+	// returning frame was
+	start := returning.basePointer - 1
+	copy(vm.stack[start:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	vm.sp = start + 1 + numArgs
+
+	return vm.callClosure(callee, numArgs, call)
+}
+
+// callClosure calls cl with the numArgs values on top of the stack. call is
+// the span of the call expression, for stack traces.
+func (vm *VM) callClosure(cl *Closure, numArgs int, call token.Span) *object.Error {
+	numParameters := cl.Fn.NumParameters
+	if numArgs < numParameters {
+		return evaluator.NewError("Wrong number of arguments: want=%d, got=%d", numParameters, numArgs)
+	}
+
+	// Extra arguments are ignored, as in the evaluator
+	vm.sp -= numArgs - numParameters
+
+	if vm.framesIndex > vm.maxDepth {
+		return evaluator.StackOverflow(vm.maxDepth)
+	}
+
+	basePointer := vm.sp - numParameters
+	if basePointer+cl.Fn.NumLocals >= len(vm.stack) {
+		vm.growStack(basePointer + cl.Fn.NumLocals + 1)
+	}
+
+	frame := NewFrame(cl, basePointer)
+	frame.call = call
+	for _, slot := range cl.Fn.Cells {
+		if slot < numParameters {
+			frame.cells[slot].Value = vm.stack[basePointer+slot]
+		}
+	}
+	for i := basePointer + numParameters; i < basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+
+	vm.pushFrame(frame)
+	vm.sp = basePointer + cl.Fn.NumLocals
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(fn object.BuiltinFunction, numArgs int) *object.Error {
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+
+	result := fn(args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if isError(result) {
+		return result.(*object.Error)
+	}
+	if result == nil {
+		result = evaluator.NULL
+	}
+
+	return vm.push(result)
+}
+
+// callFromGo runs cl with args on top of the current stack and returns its
+// result once it has returned.
+func (vm *VM) callFromGo(cl *Closure, args []object.Object) object.Object {
+	sp, framesIndex := vm.sp, vm.framesIndex
+
+	if err := vm.push(cl); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			vm.sp = sp
+			return err
+		}
+	}
+
+	if err := vm.callClosure(cl, len(args), vm.currentFrame().span()); err != nil {
+		vm.sp = sp
+		return err
+	}
+
+	result := vm.run(vm.framesIndex)
+	if isError(result) {
+		vm.sp, vm.framesIndex = sp, framesIndex
+	}
+
+	return result
+}
+
+func isError(obj object.Object) bool {
+	if obj != nil {
+		return obj.Type() == object.ERROR_OBJ
+	}
+	return false
+}
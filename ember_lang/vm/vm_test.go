@@ -0,0 +1,677 @@
+package vm
+
+import (
+	"context"
+	"ember_lang/ember_lang/ast"
+	"ember_lang/ember_lang/code"
+	"ember_lang/ember_lang/compiler"
+	"ember_lang/ember_lang/evaluator"
+	"ember_lang/ember_lang/lexer"
+	"ember_lang/ember_lang/object"
+	"ember_lang/ember_lang/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func testRun(t *testing.T, input string) object.Object {
+	return testRunVersion(t, input, object.LatestVersion)
+}
+
+func testRunVersion(t *testing.T, input string, version object.LanguageVersion) object.Object {
+	comp := compiler.NewWithState(compiler.NewVersionedSymbolTable(version), []object.Object{})
+	if err := comp.Compile(parse(t, input)); err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+
+	return New(comp.Bytecode()).Run()
+}
+
+// TestEvaluatorParity runs the inputs of the evaluator test suite through both
+// engines and expects identical results.
+func TestEvaluatorParity(t *testing.T) {
+	inputs := []string{
+		// Integers and booleans
+		"5", "-10", "5 + 5 + 5 + 5 - 10", "2 * 2 * 2 * 2 * 2", "-50 + 100 + -50",
+		"50 / 2 * 2 + 10", "(5 + 10 * 2 + 15 / 3) * 2 + -10", "+5", "2000 * 2000",
+		"true", "1 < 2", "1 > 1", "1 == 1", "1 != 2", "true == false", "true != false",
+		"(1 < 2) == true", "(10 <= 10) == true", "(10 >= 10) == true", "(10 != 10) == false",
+		"4 <= 5", "10 >= 11", "!true", "!5", "!!false", "!!5",
+
+		// Floats
+		"3.14", "1e-9", "-2.5", "+0.5", "1 + 0.5", "7 / 2.0", "7.5 % 2", "2 ** 0.5", "2.0 * 3",
+		"1 == 1.0", "1.5 < 2", "2 >= 2.5", "0.1 + 0.2", "1.5 / 0", "1.5 & 1", "~1.5", "1.5 + true",
+		"let mut x = 1.5; x++; x", `{1.5: "a", 2: "b"}[1.5]`, `{0.0: "zero"}[-0.0]`,
+		`{1: "a"}[1.0]`, `{2.0: "b"}[2]`, `{2 ** 64: "big"}[2.0 ** 64]`, `len({1: "a", 1.0: "b"})`,
+		`add(1, 0.5)`, `div(1, 4.0)`, `reduce([0.5, 1.5], add, 0)`, `type(2.5)`,
+		"9007199254740993 == 9007199254740992.0", "9007199254740993 > 9007199254740992.0",
+		"{9007199254740993: 1}[9007199254740992.0]", "99999999999999999999 == 1e20",
+		"100000000000000000001 > 1e20", "(-1.0) ** 0.5 == (-1.0) ** 0.5",
+
+		// Integers beyond 64 bits
+		"0xFF", "0b1010 | 0o7", "1_000_000 * 3", "0xFFFF_FFFF_FFFF_FFFF_F", "1_000.25",
+		"9223372036854775807 + 1", "-9223372036854775807 - 2", "4294967296 * 4294967296", "-4294967296 * 4294967296",
+		"(-9223372036854775807 - 1) * -1", "-1 * (-9223372036854775807 - 1)", "(-9223372036854775807 - 1) / -1",
+		"-(-9223372036854775807 - 1)", "2 ** 100", "1 << 64", "1 << 62", "99999999999999999999", "(2 ** 64) - (2 ** 64)",
+		"let mut x = 9223372036854775807; x++; x", "2 ** 64 > 1.5", "(2 ** 64) / 0", "1 << 100000000",
+		`{(2 ** 64): "big"}[2 ** 32 * 2 ** 32]`, "type(2 ** 64)", "0..(2 ** 64)", "~(2 ** 64)", "(2 ** 64) & 1.5",
+		"let fact = fn(n) { if (n <= 1) { return 1; } n * fact(n - 1) }; fact(25)",
+
+		// Conditionals and returns
+		"if (true) { 10 }", "if (false) { 10 }", "if (1) { 10 }", "if (1 > 2) { 10 } else { 20 }",
+		"if (1 >= 1) { 10 } else { 20 }", "if (true) { }",
+		"return 10; 9;", "9; return 2 * 5; 9;",
+		"if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+		"if (10 <= 10) { return 10; } return 20;",
+
+		// Errors
+		"5 + true;", "5 + true; 5;", "-true", "true + false;", "5; true + false; 5",
+		"if (10 > 1) { true + false; }", "foobar", `"Hello" - "World!"`,
+		`{"name": "Monkey"}[fn(x) { x }];`, `{fn(x) { x }: 1}`, "5(1)",
+		"for (let i = 1 / 0; false; i++) {}; 7",
+
+		// Let statements and functions
+		"let a = 5; a;", "let a = 5; let b = a; let c = a + b + 5; c;", "let a = 5;",
+		"fn(x) { x + 2; };",
+		"let identity = fn(x) { return x; }; identity(5);",
+		"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));",
+		"fn(x) { x; }(5)", "fn() { }()", "fn(x) { x }(1, 2)",
+		"let newAdder = fn(x) { fn(y) { x + y }; }; let addTwo = newAdder(2); addTwo(2);",
+		"let fib = fn(n) { if (n <= 1) { return n; } return fib(n - 1) + fib(n - 2); }; fib(10);",
+		`let f = fn() { let g = fn(n) { if (n == 0) { return 0; } g(n - 1) }; g(3) }; f()`,
+		`let f = fn() { let mut x = 1; let g = fn() { x }; x = 2; g() }; f()`,
+		"let x = 10; let f = fn() { let x = x + 1; x }; f()",
+
+		// Strings, arrays and hashes
+		`"Hello World!"`, `"Hello" + " " + "World!"`, `len("a\tb\u{1F600}")`, "`raw\\n` + \"\\\"\"",
+		"let f = fn() { \"\"\"\n    a\n      b\n    \"\"\" }; f()",
+		`len("")`, `len("hello world")`, `len([1, 2, 3])`, `len(1)`,
+		`push([1, 2], 3)`, "[1, 2 * 2, 3 + 3]", "[1, 2, 3][1 + 1];", "[1, 2, 3][3]",
+		"[1, 2, 3][-1]", "[1, 2, 3][-8]", `["one", "two", "three"][1]`,
+		"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]",
+		`map([1, 2, 3], fn(x) { x * 2; })`, `map(["one", "two", "three"], len)`,
+		`reduce([1, 2, 3], fn(acc, x) { acc * x; }, 1)`, `reduce([1, 2, 3], sub, 0)`,
+		`reduce([1, 2, 3], div, 1)`, `reduce(map(["one", "two", "three"], len), add, 0)`,
+		`map([1, 2], fn(x) { x + true })`,
+		`{"foo": 5}["foo"]`, `{"foo": 5}["bar"]`, `let key = "foo"; {"foo": 5}[key]`,
+		`{5: 5}[5]`, `{true: 5}[true]`, `{}["foo"]`,
+		`[1, 2, 3] + [4, 5, 6]`, `[] + []`, `concat([1, 2, 3], [4])`,
+		`type(1)`, `type("hello")`, `type(true)`, `type([1])`, `type({"a": 1})`,
+		`type(fn(x) { x + 1; })`, `type(len)`,
+
+		// Logical operators
+		"true && false", "false || true", "5 && 0", "false && undefinedVariable",
+		"true || undefinedVariable", "true && undefinedVariable", "if (false) { 1 } || 0",
+		`let mut c = {"n": 0}; let f = fn() { c["n"] = c["n"] + 1; true }; false && f(); true || f(); true && f(); c["n"]`,
+		"let x = 5; let b = true; b&&&x",
+
+		// Loops, increments and assignment
+		`let mut i = 0; i = i++; i = i++; return i;`,
+		`let mut i = 0; while (i < 10) { i = i++; } return i;`,
+		`for (let i = 0; i < 10; i++) { let x = 0; } return x;`,
+		`let mut x = 0; for (let i = 0; i < 10; i++) { let x = i; } return x;`,
+		`let sum = 0; for (let i = 0; i < 3; i++) { for (let j = 0; j < 2; j++) { let sum = sum + 1; } } return sum;`,
+		`let mut x = 5; for (let i = 0; i < 0; i++) { let x = 10; } return x;`,
+		`let mut x = 10; x = 5; let mut x = 6; return x;`,
+		"let x = 5; x = 10; return x;",
+		"let x = 5; if (true) { x = 20; }; return x;",
+		"let f = fn(x) { x = 20; return x; }; f(5);",
+		"let mut sum = 0; for (let i = 0; i < 5; i++) { sum = sum + i; }; return sum;",
+		"let mut x = 5; if (true) { if (true) { x = 20; } }; return x;",
+		"let numbers = [1, 2, 3];\nnumbers[0] = 10;",
+		"let mut numbers = [1, 2, 3];\nnumbers[0] = 10;\nreturn numbers[0];",
+		"let mapping = {\"a\": 1};\n\nmapping[\"a\"] = 10;",
+		"let mut mapping = {\"a\": 1}; mapping[\"a\"] = 10; return mapping[\"a\"];",
+		"let mut numbers = [1]; numbers[5] = 1;",
+		"let mut x = 5; if ((x = 10) > 5) { return x; } return 0;",
+		"let mut x = true; x = true && false; x", "let mut x = false; x = false || true; x",
+		"let mut x = 1; let mut y = 2; x = y = 3; x + y",
+		"let mut x = 0; let f = fn() { return 10; }; x = f(); return x;",
+		"len = 1",
+
+		// Break and continue
+		`let mut i = 0; while (true) { i = i + 1; if (i == 5) { break; } } i`,
+		`let mut sum = 0; for (let i = 0; i < 10; i++) { if (i % 2 == 0) { continue; } sum = sum + i; } sum`,
+		`let mut i = 0; let mut s = 0; while (i < 5) { i = i + 1; if (i == 3) { continue; } s = s + i; } s`,
+		`let mut n = 0; while (true) { if (n < 3) { n = n + 1; } else { break; } } n`,
+		`let mut c = 0; for (let i = 0; i < 3; i++) { while (true) { c = c + 1; break; } } c`,
+		`let mut c = 0; outer: for (let i = 0; i < 5; i++) { for (let j = 0; j < 5; j++) { if (j == 2) { continue outer; } if (i == 3) { break outer; } c = c + 1; } } c`,
+		`let mut c = 0; outer: while (true) { while (true) { c = c + 1; if (c == 4) { break outer; } continue outer; } } c`,
+		`let f = fn() { let mut i = 0; while (true) { i = i + 1; if (i == 3) { return i * 10; } } }; f()`,
+		`let f = fn() { for (let i = 0; i < 10; i++) { if (i == 4) { return i; } } return 99; }; f()`,
+		`let f = fn() { for (let i = 0; i < 3; i++) { let g = fn() { return 1; }; g(); } return 7; }; f()`,
+		"while (true) { break; }", "for (let i = 0; i < 3; i++) { continue; }",
+
+		// For-in loops and ranges
+		`let mut s = 0; for (x in [1, 2, 3]) { s = s + x; } s`,
+		`let mut s = 0; for (i, x in [10, 20, 30]) { s = s + i * x; } s`,
+		`let mut s = 0; for (k, v in {"a": 1, "b": 2}) { s = s + v; } s`,
+		`let mut s = 0; for (i, c in "héllo") { s = s + i; } s`,
+		`let mut s = 0; for (i in 0..5) { s = s + i; } s`,
+		`let mut s = 0; for (i in 0..=5) { s = s + i; } s`,
+		`let mut s = 0; for (i in 5..0) { s = s + 1; } s`,
+		`let mut s = 0; for (i in 3..3) { s = s + 1; } s`,
+		`let mut s = 0; for (i in 3..=3) { s = s + i; } s`,
+		`let mut s = 0; for (i, v in 10..13) { s = s + i; } s`,
+		`let mut s = 0; for (i in -2..2) { s = s + i; } s`,
+		`let mut s = 0; for (i in 0..100) { if (i % 2 == 1) { continue; } if (i > 6) { break; } s = s + i; } s`,
+		`let f = fn(xs) { for (x in xs) { if (x > 2) { return x; } } return -1; }; f([1, 5, 3])`,
+		`let mut n = 0; outer: for (i in 0..3) { for (j in 0..3) { if (j > i) { continue outer; } n = n + 1; } } n`,
+		`let mut calls = {"n": 0}; let xs = fn() { calls["n"] = calls["n"] + 1; [1, 2, 3] }; for (x in xs()) { } calls["n"]`,
+		`let xs = [1, 2]; let mut s = 0; for (xs in xs) { s = s + xs; } s`,
+		`let mut out = ""; for (k in {"b": 1, "a": 2, "c": 3}) { out = out + k; } out`,
+		`let mut out = ""; for (k, v in {2: "x", true: "y", 1: "z", "a": "w", false: "v"}) { out = out + v; } out`,
+		`let mut out = ""; for (c in "abc") { out = c + out; } out`,
+		`type(1..=2)`,
+		`for (x in 5) { }`,
+		`"a"..3`,
+		`1..=true`,
+		`for (x in [1]) { x = 2; }`,
+		"0..3", "1..=8", "for (x in [1]) { }", "for (i, x in []) { x }",
+		"let f = fn() { let mut s = 0; for (i, x in [4, 5]) { s = s + i * x; } s }; f()",
+		"let f = fn(xs) { for (x in xs) { let g = fn() { x }; } }; f([1])",
+		"let mut n = 0; outer: for (i in 0..3) { for (j in 0..3) { if (i == 1) { break outer; } n = n + 1; } } n",
+		"let mut n = 0; for (i in 0..3) { while (true) { for (j in [1]) { break; } break; } n = n + i; } n",
+		"let mut n = 0; outer: for (i in 0..5000) { for (j in [1, 2]) { n = n + j; continue outer; } } n",
+
+		// Pointers
+		"let mut x = 5; let p = &x; *p;",
+		"let mut x = 5; let p = &x; *p = 10; x;",
+		"let mut x = 5; let p = &x; let mut y = *p; y = 10; x;",
+		"let mut x = 5; let p = &x; x = 10; *p;",
+		"let mut x = 0; let p = &x; x = p; x", "let mut x = 0; let p = &x; x = p; **p",
+		`let mut x = 0; let p = &x; x = p; "${x}"`,
+		"let x = 5;\nlet p = &x;\n*p = 10;",
+		"&5;", "let x = 5;\n*x;", "&nothing", "&len",
+		"let mut arr = [1, 2, 3]; let p = &arr; *p = [4, 5, 6]; arr;",
+		"let mut arr = [1, 2, 3]; let p = &arr; (*p)[0] = 42; arr;",
+		"let mut arr = [1, 2, 3]; let p = &arr; p[1];",
+		"let f = fn() { let mut y = 1; let p = &y; *p = 2; y }; f()",
+		"let mut x = 1; let p = &x; let f = fn(x) { *p }; f(99)",
+		"let mut x = 1; let p = &x; let f = fn() { let mut x = 50; *p = 2; x }; f() * 10 + x",
+		"let f = fn() { let mut x = 1; &x }; let p = f(); *p = 5; *p",
+		"let f = fn(n) { let mut x = n; &x }; let a = f(1); let b = f(2); *a = 10; *a + *b",
+		"let f = fn() { if (true) { let mut y = 7; return &y; } }; *f()",
+		"let fill = fn(p, n) { if (n == 0) { return 0; }; *p = *p + n; fill(p, n - 1) }; let mut t = 0; fill(&t, 4); t",
+		"let f = fn(n) { let mut x = n; let p = &x; if (n > 0) { f(n - 1); }; *p }; f(3)",
+		"let mut x = 1; let p = &x; let g = fn() { *p = *p + 1; }; g(); g(); x",
+		"let mut x = 1; let p = &x; let mut x = 2; *p",
+		"let mut x = 1; let p = &x; x = 2; p",
+		`let mut arr = [1, 2, 3]; let p = &arr[1]; *p = 20; arr[1]`,
+		`let arr = [1, 2, 3]; let p = &arr[-1]; *p`,
+		`let mut config = {"port": 80}; let p = &config["port"]; *p = 8080; config["port"]`,
+		`let mut grid = [[1, 2], [3, 4]]; let p = &grid[1][0]; *p = 30; grid[1][0]`,
+		`let mut h = {"a": {"b": 1}}; let p = &h["a"]["b"]; *p = 2; h["a"]["b"]`,
+		`let mut arr = [1, 2]; let q = &arr; let p = &(*q)[0]; *p = 5; arr[0]`,
+		`let mut arr = [1, 2]; let q = &arr; let p = &q[1]; *p = 7; arr[1]`,
+		`let mut arr = [1, 2]; let p = &arr[0]; arr = [9, 8]; *p`,
+		`let set = fn(p, v) { *p = v; }; let mut xs = [0, 0]; set(&xs[1], 4); xs[1]`,
+		`let arr = [1, 2]; let p = &arr[0]; *p = 5`,
+		`let arr = [1]; &arr[3]`,
+		`let h = {"a": 1}; &h["b"]`,
+		`let h = {"a": 1}; &h[[1]]`,
+		`let mut h = {"port": 80}; let p = &h["port"]; h = delete(h, "port"); *p`,
+		`let mut h = {"port": 80}; let p = &h["port"]; h = delete(h, "port"); *p = 1`,
+		`let mut a = [1, 2, 3]; let p = &a[2]; a = [1]; *p`,
+		`let x = 5; &x[0]`,
+		`let f = fn() { [1] }; &f()[0]`,
+		`let n = 5; &(*n)[0]`,
+		`let mut h = {"port": 80}; let p = &h["port"]; h = delete(h, "port"); p`,
+		"let f = fn() { let mut xs = [1, 2]; let p = &xs[1]; *p = 5; xs }; f()",
+		"let f = fn(xs) { let p = &xs[0]; *p }; f([3])",
+		"let x = 1; let p = &x; let f = fn() { let mut x = 5; *p = 3; }; f()",
+
+		// Assignment updates the declaring scope
+		"let makeCounter = fn() { let mut count = 0; fn() { count = count + 1; count } }; let c = makeCounter(); c(); c(); c()",
+		"let makeCounter = fn() { let mut count = 0; fn() { count++ } }; let a = makeCounter(); let b = makeCounter(); a(); a(); b(); a() * 10 + b()",
+		"let mut total = 0; let add = fn(n) { total = total + n; }; add(2); add(3); total",
+		"let outer = fn() { let mut x = 1; let middle = fn() { let inner = fn() { x = x * 10; }; inner(); x = x + 2; }; middle(); middle(); x }; outer()",
+		"let mut x = 1; let f = fn() { let mut x = 5; x = 6; x }; f() + x",
+		"let mut x = 1; let f = fn() { x++; x++; }; f(); x",
+		"let mut i = 5; let j = i++; i * 10 + j", "let mut i = 0; while (i < 3) { i++; } i",
+		"let i = 0; i++", "let i = 0; let f = fn() { i++ }; f()", "len++", "nothing++",
+
+		// String interpolation
+		`let name = "Ann"; let age = 41; "Hello, ${name}, you are ${age + 1}"`,
+		`"${1.5} ${2 ** 70} ${true} ${[1, "a"]} ${if (false) { 1 }}"`,
+		`let greet = fn(who) { "hi ${who}" }; "${greet("${1 + 1}")}!"`,
+		`let f = fn() { let x = 3; fn() { "x=${x}" } }; f()()`,
+		`"a ${1 + "b"} c"`, `"${missing}"`,
+
+		// String indexing, slicing and comparison
+		`"abc"[1]`, `"größe"[-2]`, `"abc"[5]`, `"hello"[1:3]`, `"hello"[:2]`, `"hello"[-3:]`, `"hello"[:]`,
+		`"hello"[4:2]`, `[1, 2, 3, 4][1:-1]`, `let a = [1, 2, 3]; let p = &a; p[1:]`,
+		`"abc"[1:"b"]`, `{"a": 1}[0:1]`, `"abc"["a"]`,
+		`"a" == "a"`, `"apple" < "banana"`, `"b" >= "ä"`, `"a" < 1`,
+		`let mut words = ["pear", "apple", "fig"]; let mut min = words[0]; for (w in words) { if (w < min) { min = w; } } min`,
+
+		// Unicode
+		`let größe = 5; let 名前 = "größe"; [größe, 名前, len(名前)]`,
+		`bytes("é")`, `len(bytes("名前"))`, `bytes(1)`,
+		`let mut n = 0; for (c in "añ😀") { n = n + len(bytes(c)); } n`,
+
+		// Errors
+		`try { throw "boom"; 1 } catch (e) { e["message"] }`, `try { 1 / 0 } catch (e) { [e["message"], e["line"], e["stack"]] }`,
+		`try { 1 } catch { 2 }`, `try { [1][nope] } catch { "caught" }`, `try { throw error("bad") } catch (e) { e }`,
+		`throw "boom"`, `let f = fn() { throw error("bad") }; f() + 1`, `throw 1 + true`, `error(1)`,
+		`try { try { throw "inner" } catch (e) { throw e } } catch (e) { e["line"] }`,
+		`let f = fn(x) { if (x < 0) { throw "negative" } x }; map([1, -1], fn(x) { try { f(x) } catch { 0 } })`,
+		`try { map([1], fn(x) { throw "in map" }) } catch (e) { e["message"] }`,
+		`let mut log = []; try { try { throw "x" } finally { log = push(log, 1) } } catch (e) { log = push(log, e["message"]) }; log`,
+		`let mut log = []; let f = fn() { try { return 1 } finally { log = push(log, 2) } }; [f(), log]`,
+		`let f = fn() { try { throw "x" } catch (e) { return e["message"] } finally { return 2 } }; f()`,
+		`let mut n = 0; for (i in 0..5) { try { if (i == 3) { break } n = n + i } finally { n = n + 100 } }; n`,
+		`let mut log = []; outer: for (a in [1, 2]) { try { for (b in [1, 2]) { try { if (b == 2) { continue outer } } finally { log = push(log, b) } } } finally { log = push(log, "o") } }; log`,
+		`let mut i = 0; while (i < 3) { i++; try { if (i == 2) { continue } } catch { 0 } } i`,
+		`let f = fn() { let mut xs = []; for (x in [1, 2, 3]) { try { if (x == 2) { return xs } xs = push(xs, x) } finally { xs = push(xs, 0) } } }; f()`,
+		`let c = try { throw "q" } catch (e) { fn() { e["message"] } }; c()`,
+		`try { throw "x" } finally { 1 }`, `try { 1 } finally { throw "from finally" }`,
+		`is_error(error("x"))`, `is_error(1)`, `type(error("x"))`, `error("x")["line"]`,
+		`let f = fn(n) { throw "x" }; let g = fn() { f([1, "a"]) }; try { g() } catch (e) { e["stack"] }`,
+		`let f = fn(n) { if (n == 0) { "done" } else { f(n - 1) } }; f(20000)`,
+		`let f = fn(n) { if (n == 0) { nope } else { f(n - 1) } }; f(3)`,
+		`let f = fn(xs) { if (len(xs) == 0) { return 0; } f(rest(xs)) + 1 }; f([1, 2, 3])`,
+		`let f = fn(n) { try { if (n == 0) { throw "x" } f(n - 1) } catch (e) { n } }; f(3)`,
+		`let f = fn(n) { 1 + f(n + 1) }; f(0)`, `let f = fn(n) { 1 + f(n + 1) }; try { f(0) } catch (e) { [e["message"], len(e["stack"])] }`,
+
+		// Block scopes
+		"let x = 5; if (true) { let x = 10; } x", "let x = 5; if (true) { let x = 10; x }",
+		"if (true) { let y = 1; } y", "for (let i = 0; i < 3; i++) { } i",
+		"let mut total = 0; for (let i = 0; i < 4; i++) { total = total + i; } total",
+		"let i = 10; let mut s = 0; for (let i = i - 2; i < 10; i++) { s = s + i; } s + i",
+		"let mut x = 1; let p = &x; if (true) { *p = 2; } x",
+		"let f = fn() { let mut a = 1; if (true) { let mut a = 2; a = 3; } a }; f()",
+		"let f = fn() { let mut n = 0; while (n < 3) { let m = n + 1; n = m; } n }; f()",
+		"let f = fn() { let mut fs = []; for (i in 0..3) { let j = i * 2; fs = push(fs, fn() { j }); } fs[1]() }; f()",
+		"let f = fn() { let mut fs = []; for (i in 0..3) { fs = push(fs, fn() { i }); } fs[0]() + fs[2]() }; f()",
+		"let f = fn() { if (true) { let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) + 1 } }; g(3) } }; f()",
+
+		// Top-level blocks bind captured variables afresh on every run too
+		"let mut gs = []; for (let j = 0; j < 3; j++) { let k = j; gs = push(gs, fn() { k }) }; [gs[0](), gs[2]()]",
+		"let mut gs = []; for (i in 0..3) { gs = push(gs, fn() { i }) }; [gs[0](), gs[2]()]",
+		"let mut gs = []; for (k, v in [5, 6]) { gs = push(gs, fn() { [k, v] }) }; [gs[0](), gs[1]()]",
+		"let mut ps = []; for (i in 0..3) { let v = i * 10; ps = push(ps, &v) }; [*ps[0], *ps[2]]",
+		"let mut gs = []; let mut n = 0; while (n < 2) { let m = n; gs = push(gs, fn() { fn() { m } }); n++ }; gs[0]()() + gs[1]()()",
+		"let mut gs = []; for (i in 0..2) { try { throw i } catch (e) { gs = push(gs, fn() { e }) } }; [gs[0](), gs[1]()]",
+		"let mut gs = []; for (i in 0..2) { let mut c = i; gs = push(gs, fn() { c = c + 10; c }) }; [gs[0](), gs[0](), gs[1]()]",
+		"let mut gs = []; for (i in 0..2) { let g = fn(n) { if (n == 0) { i } else { g(n - 1) } }; gs = push(gs, g) }; [gs[0](3), gs[1](3)]",
+		"let k = 1; let g = fn() { k }; for (i in 0..2) { let k = i; }; g()",
+	}
+
+	testParity(t, inputs, object.LatestVersion)
+}
+
+// TestLegacyScopingParity checks both engines agree on Version1, where blocks
+// share the scope around them.
+func TestLegacyScopingParity(t *testing.T) {
+	inputs := []string{
+		"for (let i = 0; i < 10; i++) { let x = 0; } return x;",
+		"let mut x = 0; for (let i = 0; i < 10; i++) { let x = i; } return x;",
+		"let sum = 0; for (let i = 0; i < 3; i++) { for (let j = 0; j < 2; j++) { let sum = sum + 1; } } return sum;",
+		"let x = 5; if (true) { let x = 10; } x", "if (true) { let y = 1; } y",
+		"for (x in [1, 2]) { } x", "let f = fn() { for (let i = 0; i < 3; i++) { } i }; f()",
+		`try { throw "x" } catch (e) { 1 }; e["message"]`,
+	}
+
+	testParity(t, inputs, object.Version1)
+}
+
+// TestCheckedIntegerParity checks both engines report the same overflows
+// under object.CheckedIntegers.
+func TestCheckedIntegerParity(t *testing.T) {
+	inputs := []string{
+		"9223372036854775807 + 1", "9223372036854775806 + 1", "4294967296 * 4294967296", "-(-9223372036854775807 - 1)",
+		"99999999999999999999", "mul(4294967296, 4294967296)", "let mut x = 9223372036854775807; x++",
+		"let fact = fn(n) { if (n <= 1) { return 1; } n * fact(n - 1) }; fact(25)",
+		"let f = fn(n) { n * 4294967296 }; f(4294967296)", "2 ** 62 + (2 ** 62 - 1)",
+		"-9223372036854775808", "-9223372036854775809", "-0x8000000000000000 + 1",
+	}
+
+	testParityWith(t, inputs, object.LatestVersion, object.CheckedIntegers)
+}
+
+func testParity(t *testing.T, inputs []string, version object.LanguageVersion) {
+	t.Helper()
+	testParityWith(t, inputs, version, object.BigIntegers)
+}
+
+func testParityWith(t *testing.T, inputs []string, version object.LanguageVersion, integers object.IntegerMode) {
+	t.Helper()
+
+	for _, input := range inputs {
+		env := object.NewVersionedEnvironment(version)
+		env.SetIntegerMode(integers)
+		expected := evaluator.Eval(parse(t, input), env)
+
+		comp := compiler.NewWithState(compiler.NewVersionedSymbolTable(version), []object.Object{})
+		if err := comp.Compile(parse(t, input)); err != nil {
+			t.Fatalf("compiler error for %q: %s", input, err)
+		}
+		machine := New(comp.Bytecode())
+		machine.SetIntegerMode(integers)
+		actual := machine.Run()
+
+		if expected == nil {
+			expected = evaluator.NULL
+		}
+
+		if expected.Type() != actual.Type() || expected.Inspect() != actual.Inspect() {
+			t.Errorf("result mismatch for %q.\nevaluator: %s (%s)\nvm:        %s (%s)",
+				input, expected.Inspect(), expected.Type(), actual.Inspect(), actual.Type())
+		}
+	}
+}
+
+func TestPointerWritesFromFunctions(t *testing.T) {
+	// Pointers refer to the variable's storage, so writes through them are
+	// visible to the caller (language spec 2.7.5).
+	input := "let modify = fn(ptr) { *ptr = *ptr * 2; }; let mut x = 10; modify(&x); x;"
+
+	result := testRun(t, input)
+	integer, ok := result.(*object.Integer)
+	if !ok || integer.Value != 20 {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+}
+
+func TestEvalQuickSortAlgorithm(t *testing.T) {
+	input := `
+	let partition = fn(arr, low, high) {
+		let mut arr = arr;
+		let pivot = arr[high];
+		let mut i = low - 1;
+
+		for (let j = low; j < high; j++) {
+			if (arr[j] < pivot) {
+				i = i + 1;
+				let temp = arr[i];
+				arr[i] = arr[j];
+				arr[j] = temp;
+			}
+		}
+
+		let temp = arr[i + 1];
+		arr[i + 1] = arr[high];
+		arr[high] = temp;
+
+		return i + 1;
+	};
+
+	let quicksort = fn(arr, low, high) {
+		if (low < high) {
+			let pi = partition(arr, low, high);
+			quicksort(arr, low, pi - 1);
+			quicksort(arr, pi + 1, high);
+		}
+		return arr;
+	};
+
+	let array = [10, 7, 8, 9, 1, 5, 3, 2, 6, 4];
+	quicksort(array, 0, len(array) - 1);
+	`
+
+	result := testRun(t, input)
+	if result.Inspect() != "[1, 2, 3, 4, 5, 6, 7, 8, 9, 10]" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+}
+
+// TestStackTraces checks the VM reports the calls an error was raised in as
+// the evaluator does. Calls made by builtins know where the builtin was
+// called.
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"1 / 0", nil},
+		{"let f = fn(a) {\n  a / 0\n};\nf(1)", []string{"f(1) called at 4:1"}},
+		{"let inner = fn(s, xs) { s + 1 };\nlet outer = fn(n) { [inner(\"a\", [n])] };\nouter(2)",
+			[]string{`inner("a", [2]) called at 2:22`, "outer(2) called at 3:1"}},
+		{"let fact = fn(n) { if (n == 0) { nope } n * fact(n - 1) };\nfact(2)",
+			[]string{"fact(0) called at 1:45", "fact(1) called at 1:45", "fact(2) called at 2:1"}},
+		{"let apply = fn(f) { f(1) };\napply(fn(x) { x + true })",
+			[]string{"<anonymous>(1) called at 1:21"}},
+		{"let mut a = [1];\na[0] = a;\nlet f = fn(x) { x + true };\nf(a)",
+			[]string{"f([[[[[[[[[[[[[[[[[[[[[...) called at 4:1"}},
+		{"let g = fn(x) { throw \"bad\" };\nmap([1], fn(x) { g(x) })",
+			[]string{"g(1) called at 2:18"}},
+		{"let r = map([1, 2], fn(x) { x / 0 })", []string{"<anonymous>(1) called at 1:9"}},
+		{"let f = fn(xs) { reduce(xs, fn(a, x) { [map([x], fn(y) { y / 0 })] }, 0) };\nf([1])",
+			[]string{"<anonymous>(1) called at 1:41", "<anonymous>(0, 1) called at 1:18", "f([1]) called at 2:1"}},
+		{"let f = fn() { throw \"bad\" };\nlet e = try { f() } catch (e) { e };\nlet g = fn() { throw e };\ng()",
+			[]string{"f() called at 2:15"}},
+
+		// A tail call takes over the frame of the call it is made from
+		{"let even = fn(n) { if (n == 0) { nope } odd(n - 1) };\nlet odd = fn(n) { even(n - 1) };\neven(4)",
+			[]string{"even(0) called at 2:19"}},
+	}
+
+	for _, tt := range tests {
+		result := testRun(t, tt.input)
+
+		err, ok := result.(*object.Error)
+		if !ok {
+			t.Errorf("expected error for %q. got=%T (%+v)", tt.input, result, result)
+			continue
+		}
+
+		frames := err.Stack.Frames()
+		trace := make([]string, len(frames))
+		for i, frame := range frames {
+			trace[i] = frame.String()
+		}
+		if strings.Join(trace, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("wrong stack trace for %q.\nexpected=%q\ngot=     %q", tt.input, tt.expected, trace)
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(t, "let f = fn(n) { 1 + f(n + 1) };\nf(0)")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	machine := New(comp.Bytecode())
+	machine.SetMaxCallDepth(50)
+	result := machine.Run()
+
+	err, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("expected error. got=%T (%+v)", result, result)
+	}
+	if err.Message != "Stack overflow: maximum call depth of 50 exceeded" {
+		t.Errorf("wrong error message. got=%q", err.Message)
+	}
+	if err.Stack.Depth() != 50 {
+		t.Fatalf("wrong stack trace depth. expected=50, got=%d", err.Stack.Depth())
+	}
+	frames := err.Stack.Frames()
+	if len(frames) != 20 || err.Stack.Omitted() != 30 {
+		t.Fatalf("wrong calls kept. expected=20 of 50, got=%d, omitted=%d", len(frames), err.Stack.Omitted())
+	}
+	if frames[0].String() != "f(49) called at 1:21" || frames[9].String() != "f(40) called at 1:21" ||
+		frames[10].String() != "f(9) called at 1:21" || frames[19].String() != "f(0) called at 2:1" {
+		t.Errorf("wrong stack trace. got=%s ... %s, %s ... %s", frames[0], frames[9], frames[10], frames[19])
+	}
+
+	// A limit above MaxFrames grows the frame stack
+	comp = compiler.New()
+	if err := comp.Compile(parse(t, "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } };\nf(20000)")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine = New(comp.Bytecode())
+	machine.SetMaxCallDepth(MaxFrames * 2)
+	result = machine.Run()
+	if integer, ok := result.(*object.Integer); !ok || integer.Value != 0 {
+		t.Errorf("wrong result. got=%T (%+v)", result, result)
+	}
+
+	// Calls that need more than StackSize values grow the value stack, and
+	// overflow only at the limit
+	comp = compiler.New()
+	if err := comp.Compile(parse(t, "let f = fn(n) { let a = n; if (n == 0) { 0 } else { 1 + f(a - 1) } };\nf(49999)")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine = New(comp.Bytecode())
+	machine.SetMaxCallDepth(50000)
+	result = machine.Run()
+	if integer, ok := result.(*object.Integer); !ok || integer.Value != 49999 {
+		t.Errorf("wrong result. got=%T (%+v)", result, result)
+	}
+
+	machine = New(comp.Bytecode())
+	machine.SetMaxCallDepth(49999)
+	result = machine.Run()
+	if err, ok := result.(*object.Error); !ok || err.Message != evaluator.StackOverflow(49999).Message {
+		t.Errorf("expected stack overflow. got=%T (%+v)", result, result)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	// Each input recurses far deeper than the call depth limit allows
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(1000000, 0)", 1000000},
+		{`let even = fn(n) { if (n == 0) { return 1; } odd(n - 1) };
+		  let odd = fn(n) { if (n == 0) { return 0; } even(n - 1) };
+		  even(100001)`, 0},
+		{"let f = fn(n) { while (true) { if (n == 0) { return 7; } return f(n - 1); } }; f(100000)", 7},
+		{"let f = fn(n) { for (x in [1]) { if (n == 0) { return 5; } return f(n - 1); } }; f(100000)", 5},
+		{"let f = fn(n, unused) { if (n == 0) { 3 } else { f(n - 1, n, n) } }; f(100000, 0)", 3},
+		{"let f = fn(n) { if (n == 0) { len(\"abc\") } else { f(n - 1) } }; f(100000)", 3},
+		{"let f = fn(n) { let g = fn() { n }; if (n == 0) { g } else { f(n - 1) } }; f(100000)()", 0},
+	}
+
+	for _, tt := range tests {
+		result := testRun(t, tt.input)
+		integer, ok := result.(*object.Integer)
+		if !ok || integer.Value != tt.expected {
+			t.Errorf("wrong result for %q. expected=%d, got=%T (%+v)", tt.input, tt.expected, result, result)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		ctx      context.Context
+		input    string
+		limits   object.Limits
+		expected string
+		halt     object.HaltReason
+	}{
+		{context.Background(), "while (true) { }", object.Limits{MaxSteps: 1000},
+			"Budget exceeded: more than 1000 steps", object.BudgetExceeded},
+		{context.Background(), "let f = fn() { f() }; f()", object.Limits{MaxSteps: 100000},
+			"Budget exceeded: more than 100000 steps", object.BudgetExceeded},
+		{context.Background(), "while (true) { }", object.Limits{Deadline: time.Now().Add(10 * time.Millisecond)},
+			"Budget exceeded: deadline passed", object.BudgetExceeded},
+		{cancelled, "while (true) { }", object.Limits{},
+			"Execution cancelled", object.Cancelled},
+		{context.Background(), "try { while (true) { } } catch { 1 } finally { 2 }", object.Limits{MaxSteps: 1000},
+			"Budget exceeded: more than 1000 steps", object.BudgetExceeded},
+		{context.Background(), "map([1, 2], fn(x) { while (true) { } })", object.Limits{MaxSteps: 1000},
+			"Budget exceeded: more than 1000 steps", object.BudgetExceeded},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(t, tt.input)); err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+
+		result := New(comp.Bytecode()).RunContext(tt.ctx, tt.limits)
+
+		err, ok := result.(*object.Error)
+		if !ok {
+			t.Errorf("expected error for %q. got=%T (%+v)", tt.input, result, result)
+			continue
+		}
+		if err.Message != tt.expected || err.Halt != tt.halt {
+			t.Errorf("wrong error for %q. expected=%q (%d), got=%q (%d)",
+				tt.input, tt.expected, tt.halt, err.Message, err.Halt)
+		}
+	}
+}
+
+func TestInternalErrorRecovery(t *testing.T) {
+	// Popping an empty stack panics; Run reports it instead of crashing
+	bytecode := &compiler.Bytecode{Instructions: code.Make(code.OpPop)}
+
+	result := New(bytecode).Run()
+
+	err, ok := result.(*object.Error)
+	if !ok || !err.Internal {
+		t.Fatalf("expected internal error. got=%T (%+v)", result, result)
+	}
+}
+
+func TestGlobalsPersistAcrossRuns(t *testing.T) {
+	symbolTable := compiler.NewSymbolTable()
+	constants := []object.Object{}
+	globals := make([]*object.Cell, GlobalsSize)
+
+	lines := []string{
+		"let mut counter = 1;",
+		"let bump = fn() { counter = counter + 1; };",
+		"bump(); bump();",
+		"counter",
+	}
+
+	var result object.Object
+	for _, line := range lines {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(parse(t, line)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		constants = comp.Constants()
+
+		result = NewWithGlobalsStore(comp.Bytecode(), globals).Run()
+	}
+
+	integer, ok := result.(*object.Integer)
+	if !ok || integer.Value != 3 {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+}
+
+func TestExamplesRun(t *testing.T) {
+	paths, err := filepath.Glob("../../examples/*.em")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The examples print as they run; keep the test output readable
+	stdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() { os.Stdout = stdout }()
+
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result := testRun(t, string(source)); isError(result) {
+			t.Errorf("%s failed: %s", path, result.Inspect())
+		}
+	}
+}
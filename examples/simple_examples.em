@@ -11,10 +11,11 @@ let mut y = 5;     // Explicitly mutable
 y = 10;            // Works fine
 
 // For loop
+let mut total = 0;
 for (let i = 0; i < 5; i++) {
-    let forIndex = i;
+    total = total + i;
 }
-print(forIndex);
+print(total); // 10
 
 // While loop
 let mut i = 0;